@@ -0,0 +1,365 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/adrg/xdg"
+)
+
+// BookmarkService manages the user's sidebar bookmarks.
+// Bookmarks are persisted in the lazydir config directory and kept in sync with
+// the GTK bookmarks file (~/.config/gtk-3.0/bookmarks) shared by Thunar, Nautilus
+// and the GTK file chooser, so existing bookmarks show up automatically.
+type BookmarkService struct {
+	mu sync.Mutex
+}
+
+const (
+	bookmarksFileName    = "bookmarks.json"
+	bookmarksFileVersion = 1
+)
+
+// bookmarksFile is the on-disk format of bookmarks.json
+type bookmarksFile struct {
+	Version   int        `json:"version"`
+	Bookmarks []Shortcut `json:"bookmarks"`
+}
+
+// gtkBookmark is one line of the GTK bookmarks file: "<uri> [label]"
+type gtkBookmark struct {
+	URI   string
+	Label string
+}
+
+var bookmarkLogos = []ShortcutLogo{
+	ShortcutLogoDefault, ShortcutLogoFolder, ShortcutLogoDrive, ShortcutLogoHome,
+	ShortcutLogoDocs, ShortcutLogoMusic, ShortcutLogoPics, ShortcutLogoVideos,
+	ShortcutLogoDesktop, ShortcutLogoDownloads, ShortcutLogoNetwork,
+}
+
+// GetBookmarks returns the user's bookmarks in sidebar order, after importing
+// any changes made to the GTK bookmarks file by other applications.
+func (b *BookmarkService) GetBookmarks() Result[[]Shortcut] {
+	return b.update(func(list []Shortcut, gtk []gtkBookmark) ([]Shortcut, []gtkBookmark, *AppError) {
+		return list, gtk, nil
+	})
+}
+
+// AddBookmark appends a bookmark for a local directory or a remote URI (e.g. sftp://host/path).
+// An empty name defaults to the last path element.
+func (b *BookmarkService) AddBookmark(name string, path string, logo ShortcutLogo) Result[[]Shortcut] {
+	bookmark := Shortcut{Name: strings.TrimSpace(name), Logo: logo, Source: ShortcutSourceBookmark}
+
+	if isRemoteURI(path) {
+		bookmark.Path = path
+		bookmark.Remote = true
+	} else {
+		pathResult := canonicalPath(path)
+		if pathResult.Error != nil {
+			return Result[[]Shortcut]{Error: pathResult.Error}
+		}
+		bookmark.Path = *pathResult.Data
+
+		info, err := os.Stat(bookmark.Path)
+		if err != nil {
+			return Result[[]Shortcut]{Error: &AppError{
+				Code:       ResolvePathError,
+				Message:    fmt.Sprintf("cannot access %s: %v", bookmark.Path, err),
+				InnerError: err,
+			}}
+		}
+		if !info.IsDir() {
+			return Result[[]Shortcut]{Error: &AppError{
+				Code:    ResolvePathError,
+				Message: fmt.Sprintf("%s is not a directory", bookmark.Path),
+			}}
+		}
+	}
+
+	if bookmark.Name == "" {
+		bookmark.Name = defaultBookmarkName(bookmark.Path)
+	}
+	if bookmark.Logo == "" {
+		bookmark.Logo = ShortcutLogoFolder
+		if bookmark.Remote {
+			bookmark.Logo = ShortcutLogoNetwork
+		}
+	}
+	if !slices.Contains(bookmarkLogos, bookmark.Logo) {
+		return Result[[]Shortcut]{Error: &AppError{Code: BookmarkStoreError, Message: fmt.Sprintf("unknown logo %q", logo)}}
+	}
+
+	return b.update(func(list []Shortcut, gtk []gtkBookmark) ([]Shortcut, []gtkBookmark, *AppError) {
+		if indexOfBookmark(list, bookmark.Path) >= 0 {
+			return nil, nil, &AppError{Code: BookmarkExistsError, Message: fmt.Sprintf("%s is already bookmarked", bookmark.Path)}
+		}
+		return append(list, bookmark), gtk, nil
+	})
+}
+
+// RemoveBookmark deletes the bookmark pointing to path.
+// Bookmarks imported from GTK are also removed from the GTK bookmarks file,
+// otherwise they would come back on the next sync.
+func (b *BookmarkService) RemoveBookmark(path string) Result[[]Shortcut] {
+	return b.update(func(list []Shortcut, gtk []gtkBookmark) ([]Shortcut, []gtkBookmark, *AppError) {
+		i := indexOfBookmark(list, path)
+		if i < 0 {
+			return nil, nil, bookmarkNotFound(path)
+		}
+		if list[i].Source == ShortcutSourceGtk {
+			gtk = slices.DeleteFunc(gtk, func(g gtkBookmark) bool { return gtkBookmarkPath(g.URI) == path })
+		}
+		return slices.Delete(list, i, i+1), gtk, nil
+	})
+}
+
+// RenameBookmark changes the display name of a bookmark.
+func (b *BookmarkService) RenameBookmark(path string, name string) Result[[]Shortcut] {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Result[[]Shortcut]{Error: &AppError{Code: BookmarkStoreError, Message: "bookmark name cannot be empty"}}
+	}
+	return b.update(func(list []Shortcut, gtk []gtkBookmark) ([]Shortcut, []gtkBookmark, *AppError) {
+		i := indexOfBookmark(list, path)
+		if i < 0 {
+			return nil, nil, bookmarkNotFound(path)
+		}
+		list[i].Name = name
+		if list[i].Source == ShortcutSourceGtk {
+			for j := range gtk {
+				if gtkBookmarkPath(gtk[j].URI) == path {
+					gtk[j].Label = name
+				}
+			}
+		}
+		return list, gtk, nil
+	})
+}
+
+// SetBookmarkLogo changes the icon shown next to a bookmark.
+func (b *BookmarkService) SetBookmarkLogo(path string, logo ShortcutLogo) Result[[]Shortcut] {
+	if !slices.Contains(bookmarkLogos, logo) {
+		return Result[[]Shortcut]{Error: &AppError{Code: BookmarkStoreError, Message: fmt.Sprintf("unknown logo %q", logo)}}
+	}
+	return b.update(func(list []Shortcut, gtk []gtkBookmark) ([]Shortcut, []gtkBookmark, *AppError) {
+		i := indexOfBookmark(list, path)
+		if i < 0 {
+			return nil, nil, bookmarkNotFound(path)
+		}
+		list[i].Logo = logo
+		return list, gtk, nil
+	})
+}
+
+// MoveBookmark moves the bookmark pointing to path to position index (reordering).
+func (b *BookmarkService) MoveBookmark(path string, index int) Result[[]Shortcut] {
+	return b.update(func(list []Shortcut, gtk []gtkBookmark) ([]Shortcut, []gtkBookmark, *AppError) {
+		i := indexOfBookmark(list, path)
+		if i < 0 {
+			return nil, nil, bookmarkNotFound(path)
+		}
+		if index < 0 || index >= len(list) {
+			return nil, nil, &AppError{Code: BookmarkStoreError, Message: fmt.Sprintf("bookmark index %d out of bounds", index)}
+		}
+		bookmark := list[i]
+		list = slices.Delete(list, i, i+1)
+		return slices.Insert(list, index, bookmark), gtk, nil
+	})
+}
+
+// update loads and syncs the bookmarks, applies fn and persists the result.
+// The GTK bookmarks file is only rewritten when fn changed it.
+func (b *BookmarkService) update(fn func(list []Shortcut, gtk []gtkBookmark) ([]Shortcut, []gtkBookmark, *AppError)) Result[[]Shortcut] {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	storePath, err := configFilePath(bookmarksFileName)
+	if err != nil {
+		return Result[[]Shortcut]{Error: bookmarkStoreError("failed to resolve bookmarks file", err)}
+	}
+
+	var store bookmarksFile
+	if _, err := readJSONFile(storePath, &store); err != nil {
+		return Result[[]Shortcut]{Error: bookmarkStoreError("failed to read bookmarks", err)}
+	}
+
+	gtk, err := readGtkBookmarks()
+	if err != nil {
+		return Result[[]Shortcut]{Error: bookmarkStoreError("failed to read GTK bookmarks", err)}
+	}
+
+	synced := mergeGtkBookmarks(store.Bookmarks, gtk)
+	original := slices.Clone(gtk)
+
+	list, gtk, appErr := fn(synced, gtk)
+	if appErr != nil {
+		return Result[[]Shortcut]{Error: appErr}
+	}
+	if list == nil {
+		list = []Shortcut{}
+	}
+
+	if !slices.Equal(gtk, original) {
+		if err := writeGtkBookmarks(gtk); err != nil {
+			return Result[[]Shortcut]{Error: bookmarkStoreError("failed to write GTK bookmarks", err)}
+		}
+	}
+
+	if store.Version != bookmarksFileVersion || !slices.Equal(store.Bookmarks, list) {
+		store = bookmarksFile{Version: bookmarksFileVersion, Bookmarks: list}
+		if err := writeJSONFileAtomic(storePath, store); err != nil {
+			return Result[[]Shortcut]{Error: bookmarkStoreError("failed to save bookmarks", err)}
+		}
+	}
+
+	return Result[[]Shortcut]{Data: &list}
+}
+
+// mergeGtkBookmarks brings the stored list up to date with the GTK bookmarks file:
+// GTK entries that disappeared are dropped, renamed ones are updated and new ones are
+// appended. Bookmarks created in lazydir are left untouched and win over GTK duplicates.
+func mergeGtkBookmarks(stored []Shortcut, gtk []gtkBookmark) []Shortcut {
+	gtkByPath := make(map[string]gtkBookmark, len(gtk))
+	for _, g := range gtk {
+		gtkByPath[gtkBookmarkPath(g.URI)] = g
+	}
+
+	merged := make([]Shortcut, 0, len(stored)+len(gtk))
+	seen := make(map[string]bool, len(stored))
+	for _, s := range stored {
+		if s.Source == ShortcutSourceGtk {
+			g, ok := gtkByPath[s.Path]
+			if !ok {
+				continue
+			}
+			if g.Label != "" {
+				s.Name = g.Label
+			}
+		}
+		if seen[s.Path] {
+			continue
+		}
+		seen[s.Path] = true
+		merged = append(merged, s)
+	}
+
+	for _, g := range gtk {
+		path := gtkBookmarkPath(g.URI)
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		merged = append(merged, gtkBookmarkToShortcut(g))
+	}
+	return merged
+}
+
+func gtkBookmarkToShortcut(g gtkBookmark) Shortcut {
+	path := gtkBookmarkPath(g.URI)
+	remote := isRemoteURI(path)
+
+	name := g.Label
+	if name == "" {
+		name = defaultBookmarkName(path)
+	}
+	logo := ShortcutLogoFolder
+	if remote {
+		logo = ShortcutLogoNetwork
+	}
+	return Shortcut{Name: name, Path: path, Logo: logo, Source: ShortcutSourceGtk, Remote: remote}
+}
+
+// gtkBookmarksPath returns the location of the GTK 3 bookmarks file.
+func gtkBookmarksPath() string {
+	return filepath.Join(xdg.ConfigHome, "gtk-3.0", "bookmarks")
+}
+
+func readGtkBookmarks() ([]gtkBookmark, error) {
+	file, err := os.Open(gtkBookmarksPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var bookmarks []gtkBookmark
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		uri, label, _ := strings.Cut(line, " ")
+		bookmarks = append(bookmarks, gtkBookmark{URI: uri, Label: strings.TrimSpace(label)})
+	}
+	return bookmarks, scanner.Err()
+}
+
+func writeGtkBookmarks(bookmarks []gtkBookmark) error {
+	path := gtkBookmarksPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	for _, g := range bookmarks {
+		sb.WriteString(g.URI)
+		if g.Label != "" {
+			sb.WriteString(" " + g.Label)
+		}
+		sb.WriteString("\n")
+	}
+	return writeFileAtomic(path, []byte(sb.String()), 0o644)
+}
+
+// gtkBookmarkPath converts a GTK bookmark URI to the path lazydir uses:
+// file:// URIs become local paths, anything else (sftp://, smb://...) is kept as is.
+func gtkBookmarkPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.Clean(filepath.FromSlash(u.Path))
+}
+
+// isRemoteURI reports whether p is a URI such as sftp://host/dir rather than a local path.
+func isRemoteURI(p string) bool {
+	scheme, _, ok := strings.Cut(p, "://")
+	// A single letter "scheme" is a Windows drive (C://), not a URI.
+	return ok && len(scheme) > 1 && !strings.ContainsAny(scheme, `/\`)
+}
+
+func defaultBookmarkName(p string) string {
+	if isRemoteURI(p) {
+		if u, err := url.Parse(p); err == nil {
+			if base := filepath.Base(u.Path); u.Path != "" && base != "/" {
+				return base + " on " + u.Host
+			}
+			return u.Host
+		}
+		return p
+	}
+	return filepath.Base(p)
+}
+
+func indexOfBookmark(list []Shortcut, path string) int {
+	return slices.IndexFunc(list, func(s Shortcut) bool { return s.Path == path })
+}
+
+func bookmarkNotFound(path string) *AppError {
+	return &AppError{Code: BookmarkNotFoundError, Message: fmt.Sprintf("no bookmark for %s", path)}
+}
+
+func bookmarkStoreError(message string, err error) *AppError {
+	return &AppError{Code: BookmarkStoreError, Message: fmt.Sprintf("%s: %v", message, err), InnerError: err}
+}
@@ -0,0 +1,503 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// ChecksumService hashes files, writes SHA256SUMS style checksum files and
+// verifies them. Every operation runs in the background: it returns an id right
+// away, reports EventChecksumProgress while reading and ends with EventChecksumDone.
+// CancelChecksum stops it.
+type ChecksumService struct {
+	App *application.App // used to report progress, may be nil
+
+	mu   sync.Mutex
+	jobs map[string]context.CancelFunc
+}
+
+// ChecksumProgress is emitted (EventChecksumProgress) at most every checksumProgressInterval.
+type ChecksumProgress struct {
+	ID         string `json:"id"`
+	File       string `json:"file"` // file being read
+	FilesDone  int    `json:"filesDone"`
+	FilesTotal int    `json:"filesTotal"`
+	BytesDone  int64  `json:"bytesDone"`
+	BytesTotal int64  `json:"bytesTotal"`
+}
+
+// ChecksumDone is emitted (EventChecksumDone) when an operation ends, successfully or not.
+type ChecksumDone struct {
+	ID        string    `json:"id"`
+	Error     *AppError `json:"error,omitempty"`
+	Cancelled bool      `json:"cancelled,omitempty"`
+
+	Files        []FileChecksum        `json:"files,omitempty"`        // ComputeChecksums
+	ChecksumFile string                `json:"checksumFile,omitempty"` // WriteChecksumFile
+	Verification *ChecksumVerification `json:"verification,omitempty"` // VerifyChecksumFile
+}
+
+type FileChecksum struct {
+	Path  string            `json:"path"`
+	Size  int64             `json:"size"`
+	Sums  map[string]string `json:"sums,omitempty"` // algorithm -> lowercase hex digest
+	Error string            `json:"error,omitempty"`
+}
+
+// ChecksumVerification compares a checksum file with the files next to it.
+type ChecksumVerification struct {
+	Algorithm  string             `json:"algorithm"`
+	OK         []string           `json:"ok"`
+	Mismatched []ChecksumMismatch `json:"mismatched"`
+	Missing    []string           `json:"missing"`  // listed but not found
+	Unlisted   []string           `json:"unlisted"` // found next to the checksum file or a listed file, but not listed
+}
+
+type ChecksumMismatch struct {
+	Path     string `json:"path"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+const (
+	EventChecksumProgress = "checksum:progress"
+	EventChecksumDone     = "checksum:done"
+
+	ChecksumMD5    = "md5"
+	ChecksumSHA1   = "sha1"
+	ChecksumSHA256 = "sha256"
+	ChecksumSHA512 = "sha512"
+	ChecksumCRC32  = "crc32"
+
+	checksumProgressInterval = 200 * time.Millisecond
+)
+
+var checksumAlgorithms = map[string]func() hash.Hash{
+	ChecksumMD5:    md5.New,
+	ChecksumSHA1:   sha1.New,
+	ChecksumSHA256: sha256.New,
+	ChecksumSHA512: sha512.New,
+	ChecksumCRC32:  func() hash.Hash { return crc32.NewIEEE() },
+}
+
+// checksumFileNames are the usual names of checksum files, to guess their algorithm.
+var checksumFileNames = map[string]string{
+	"MD5SUMS":    ChecksumMD5,
+	"SHA1SUMS":   ChecksumSHA1,
+	"SHA256SUMS": ChecksumSHA256,
+	"SHA512SUMS": ChecksumSHA512,
+	".md5":       ChecksumMD5,
+	".sha1":      ChecksumSHA1,
+	".sha256":    ChecksumSHA256,
+	".sha512":    ChecksumSHA512,
+	".sfv":       ChecksumCRC32,
+}
+
+// ComputeChecksums hashes the given files (directories are walked) with each of algorithms.
+// The sums come with EventChecksumDone.
+func (c *ChecksumService) ComputeChecksums(paths []string, algorithms []string) Result[string] {
+	if len(algorithms) == 0 {
+		algorithms = []string{ChecksumSHA256}
+	}
+	for _, algorithm := range algorithms {
+		if checksumAlgorithms[algorithm] == nil {
+			return Result[string]{Error: &AppError{Code: ChecksumError, Message: fmt.Sprintf("unknown checksum algorithm %q", algorithm)}}
+		}
+	}
+	files, appErr := collectChecksumFiles(paths)
+	if appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+
+	return c.start(func(ctx context.Context, progress *checksumProgress) ChecksumDone {
+		done := ChecksumDone{Files: make([]FileChecksum, 0, len(files))}
+		for _, file := range files {
+			sums, err := progress.hashFile(ctx, file, algorithms)
+			if ctx.Err() != nil {
+				break
+			}
+			result := FileChecksum{Path: file.path, Size: file.size, Sums: sums}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			done.Files = append(done.Files, result)
+		}
+		return done
+	}, files)
+}
+
+// WriteChecksumFile hashes the given files with algorithm and writes their sums to
+// outputPath in the coreutils format ("<digest>  <path>"), with paths relative to
+// the directory of outputPath so the file can be verified anywhere.
+func (c *ChecksumService) WriteChecksumFile(paths []string, algorithm string, outputPath string) Result[string] {
+	if checksumAlgorithms[algorithm] == nil {
+		return Result[string]{Error: &AppError{Code: ChecksumError, Message: fmt.Sprintf("unknown checksum algorithm %q", algorithm)}}
+	}
+	outputResult := canonicalPath(outputPath)
+	if outputResult.Error != nil {
+		return Result[string]{Error: outputResult.Error}
+	}
+	output := *outputResult.Data
+	files, appErr := collectChecksumFiles(paths)
+	if appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+	// Never list the checksum file in itself
+	files = slices.DeleteFunc(files, func(file checksumFile) bool { return file.path == output })
+
+	return c.start(func(ctx context.Context, progress *checksumProgress) ChecksumDone {
+		var content strings.Builder
+		for _, file := range files {
+			sums, err := progress.hashFile(ctx, file, []string{algorithm})
+			if ctx.Err() != nil {
+				return ChecksumDone{}
+			}
+			if err != nil {
+				return ChecksumDone{Error: &AppError{Code: ChecksumError, Message: fmt.Sprintf("failed to hash %s: %v", file.path, err), InnerError: err}}
+			}
+			rel, err := filepath.Rel(filepath.Dir(output), file.path)
+			if err != nil {
+				rel = file.path
+			}
+			fmt.Fprintf(&content, "%s  %s\n", sums[algorithm], filepath.ToSlash(rel))
+		}
+
+		if err := writeFileAtomic(output, []byte(content.String()), 0o644); err != nil {
+			return ChecksumDone{Error: &AppError{Code: ChecksumError, Message: fmt.Sprintf("failed to write %s: %v", output, err), InnerError: err}}
+		}
+		return ChecksumDone{ChecksumFile: output}
+	}, files)
+}
+
+// VerifyChecksumFile checks the files listed in a checksum file, relative to its
+// directory. Both the coreutils ("<digest>  <path>") and BSD ("SHA256 (<path>) = <digest>")
+// formats are read; the algorithm comes from the file name or the digest length.
+func (c *ChecksumService) VerifyChecksumFile(checksumPath string) Result[string] {
+	pathResult := canonicalPath(checksumPath)
+	if pathResult.Error != nil {
+		return Result[string]{Error: pathResult.Error}
+	}
+	sumsPath := *pathResult.Data
+	entries, algorithm, err := readChecksumFile(sumsPath)
+	if err != nil {
+		return Result[string]{Error: &AppError{Code: ChecksumError, Message: fmt.Sprintf("failed to read %s: %v", sumsPath, err), InnerError: err}}
+	}
+
+	dir := filepath.Dir(sumsPath)
+	verification := &ChecksumVerification{Algorithm: algorithm, OK: []string{}, Mismatched: []ChecksumMismatch{}, Missing: []string{}, Unlisted: []string{}}
+	listed := map[string]bool{sumsPath: true}
+	// Only the directories the entries are in are searched for unlisted files,
+	// a checksum file at the top of a large tree does not cover all of it
+	listedDirs := map[string]bool{dir: true}
+	var files []checksumFile
+	expected := map[string]string{}
+	for _, entry := range entries {
+		path := filepath.Clean(filepath.Join(dir, filepath.FromSlash(entry.path)))
+		if filepath.IsAbs(filepath.FromSlash(entry.path)) {
+			path = filepath.Clean(entry.path)
+		}
+		listed[path] = true
+		if rel, err := filepath.Rel(dir, filepath.Dir(path)); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			listedDirs[filepath.Dir(path)] = true
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			verification.Missing = append(verification.Missing, entry.path)
+			continue
+		}
+		files = append(files, checksumFile{path: path, size: info.Size(), display: entry.path})
+		expected[path] = entry.digest
+	}
+
+	return c.start(func(ctx context.Context, progress *checksumProgress) ChecksumDone {
+		for _, file := range files {
+			sums, err := progress.hashFile(ctx, file, []string{algorithm})
+			if ctx.Err() != nil {
+				return ChecksumDone{}
+			}
+			if err != nil {
+				verification.Missing = append(verification.Missing, file.display)
+				continue
+			}
+			if actual := sums[algorithm]; actual == expected[file.path] {
+				verification.OK = append(verification.OK, file.display)
+			} else {
+				verification.Mismatched = append(verification.Mismatched, ChecksumMismatch{Path: file.display, Expected: expected[file.path], Actual: actual})
+			}
+		}
+
+		// Files of those directories the checksum file does not cover
+		for listedDir := range listedDirs {
+			dirEntries, _ := os.ReadDir(listedDir)
+			for _, d := range dirEntries {
+				path := filepath.Join(listedDir, d.Name())
+				if d.Type().IsRegular() && !listed[path] {
+					rel, _ := filepath.Rel(dir, path)
+					verification.Unlisted = append(verification.Unlisted, filepath.ToSlash(rel))
+				}
+			}
+		}
+		slices.Sort(verification.Unlisted)
+		return ChecksumDone{Verification: verification}
+	}, files)
+}
+
+// CancelChecksum stops a running checksum operation; it ends with a cancelled EventChecksumDone.
+func (c *ChecksumService) CancelChecksum(id string) Result[string] {
+	c.mu.Lock()
+	cancel, ok := c.jobs[id]
+	c.mu.Unlock()
+	if !ok {
+		return Result[string]{Error: &AppError{Code: ChecksumError, Message: fmt.Sprintf("no running checksum operation %s", id)}}
+	}
+	cancel()
+	return Result[string]{Data: ptrString("Checksum cancelled")}
+}
+
+// ServiceShutdown stops the running operations.
+func (c *ChecksumService) ServiceShutdown() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cancel := range c.jobs {
+		cancel()
+	}
+	return nil
+}
+
+// start runs work in the background and returns its id.
+func (c *ChecksumService) start(work func(ctx context.Context, progress *checksumProgress) ChecksumDone, files []checksumFile) Result[string] {
+	id := newUUID()
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mu.Lock()
+	if c.jobs == nil {
+		c.jobs = map[string]context.CancelFunc{}
+	}
+	c.jobs[id] = cancel
+	c.mu.Unlock()
+
+	progress := &checksumProgress{service: c, state: ChecksumProgress{ID: id, FilesTotal: len(files)}}
+	for _, file := range files {
+		progress.state.BytesTotal += file.size
+	}
+
+	go func() {
+		done := work(ctx, progress)
+		done.ID = id
+		if ctx.Err() != nil && done.Error == nil {
+			done = ChecksumDone{ID: id, Cancelled: true}
+		}
+
+		c.mu.Lock()
+		delete(c.jobs, id)
+		c.mu.Unlock()
+		cancel()
+		c.emit(EventChecksumDone, done)
+	}()
+
+	return Result[string]{Data: &id}
+}
+
+func (c *ChecksumService) emit(name string, data any) {
+	if c.App != nil {
+		c.App.Event.Emit(name, data)
+	}
+}
+
+type checksumFile struct {
+	path    string
+	size    int64
+	display string // path as listed in a checksum file
+}
+
+// collectChecksumFiles resolves paths to the regular files to hash, walking directories.
+func collectChecksumFiles(paths []string) ([]checksumFile, *AppError) {
+	if len(paths) == 0 {
+		return nil, &AppError{Code: ChecksumError, Message: "no files selected"}
+	}
+	var files []checksumFile
+	for _, p := range paths {
+		pathResult := canonicalPath(p)
+		if pathResult.Error != nil {
+			return nil, pathResult.Error
+		}
+		err := filepath.WalkDir(*pathResult.Data, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			files = append(files, checksumFile{path: path, size: info.Size(), display: path})
+			return nil
+		})
+		if err != nil {
+			return nil, &AppError{Code: ChecksumError, Message: fmt.Sprintf("failed to list %s: %v", *pathResult.Data, err), InnerError: err}
+		}
+	}
+	return files, nil
+}
+
+// checksumProgress tracks the bytes hashed by an operation and reports them.
+type checksumProgress struct {
+	service  *ChecksumService
+	state    ChecksumProgress
+	lastEmit time.Time
+}
+
+// hashFile computes the digests of file in one read, reporting progress as it goes.
+func (p *checksumProgress) hashFile(ctx context.Context, file checksumFile, algorithms []string) (map[string]string, error) {
+	defer func() { p.state.FilesDone++ }()
+	p.state.File = file.path
+
+	f, err := os.Open(file.path)
+	if err != nil {
+		p.state.BytesDone += file.size
+		return nil, err
+	}
+	defer f.Close()
+
+	hashes := make([]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, len(algorithms))
+	for i, algorithm := range algorithms {
+		hashes[i] = checksumAlgorithms[algorithm]()
+		writers[i] = hashes[i]
+	}
+	writer := io.MultiWriter(writers...)
+
+	buf := make([]byte, 1<<20)
+	for {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		n, err := f.Read(buf)
+		if n > 0 {
+			writer.Write(buf[:n])
+			p.state.BytesDone += int64(n)
+			if time.Since(p.lastEmit) >= checksumProgressInterval {
+				p.lastEmit = time.Now()
+				p.service.emit(EventChecksumProgress, p.state)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sums := map[string]string{}
+	for i, algorithm := range algorithms {
+		sums[algorithm] = hex.EncodeToString(hashes[i].Sum(nil))
+	}
+	return sums, nil
+}
+
+type checksumEntry struct {
+	digest string
+	path   string
+}
+
+// readChecksumFile parses a checksum file and returns its entries and algorithm.
+func readChecksumFile(path string) ([]checksumEntry, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	algorithm := checksumFileNames[strings.ToUpper(filepath.Base(path))]
+	if algorithm == "" {
+		algorithm = checksumFileNames[strings.ToLower(filepath.Ext(path))]
+	}
+
+	var entries []checksumEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		var entry checksumEntry
+		if name, rest, ok := strings.Cut(line, " ("); ok && !strings.Contains(name, " ") && strings.Contains(rest, ") = ") {
+			// BSD: SHA256 (path) = digest
+			i := strings.LastIndex(rest, ") = ")
+			entry = checksumEntry{path: rest[:i], digest: rest[i+4:]}
+			if bsd := strings.ToLower(strings.ReplaceAll(name, "-", "")); checksumAlgorithms[bsd] != nil && algorithm == "" {
+				algorithm = bsd
+			}
+		} else if algorithm == ChecksumCRC32 {
+			// SFV: path digest
+			i := strings.LastIndex(line, " ")
+			if i < 0 {
+				continue
+			}
+			entry = checksumEntry{path: line[:i], digest: line[i+1:]}
+		} else {
+			// coreutils: digest, then two spaces (text) or space and star (binary), then path
+			digest, rest, ok := strings.Cut(line, " ")
+			if !ok || len(rest) < 2 {
+				continue
+			}
+			entry = checksumEntry{digest: digest, path: rest[1:]}
+		}
+		entry.digest = strings.ToLower(strings.TrimSpace(entry.digest))
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, "", err
+	}
+	if len(entries) == 0 {
+		return nil, "", errors.New("no checksums found")
+	}
+
+	if algorithm == "" {
+		algorithm = checksumAlgorithmForLength(len(entries[0].digest))
+		if algorithm == "" {
+			return nil, "", fmt.Errorf("unknown checksum format %q", entries[0].digest)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	return entries, algorithm, nil
+}
+
+func checksumAlgorithmForLength(length int) string {
+	switch length {
+	case 8:
+		return ChecksumCRC32
+	case 32:
+		return ChecksumMD5
+	case 40:
+		return ChecksumSHA1
+	case 64:
+		return ChecksumSHA256
+	case 128:
+		return ChecksumSHA512
+	}
+	return ""
+}
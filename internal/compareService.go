@@ -0,0 +1,593 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// CompareService compares the directories shown in the split panes, and files.
+type CompareService struct{}
+
+type CompareOptions struct {
+	CompareContent bool `json:"compareContent"` // files of the same size are also compared byte for byte
+	TimeTolerance  int  `json:"timeTolerance"`  // seconds, closer modification times are equal (FAT stores 2s)
+}
+
+// DirectoryComparison holds paths relative to Left and Right. A directory found
+// on one side only is listed without its content.
+type DirectoryComparison struct {
+	Left       string   `json:"left"`
+	Right      string   `json:"right"`
+	OnlyLeft   []string `json:"onlyLeft"`
+	OnlyRight  []string `json:"onlyRight"`
+	NewerLeft  []string `json:"newerLeft"`
+	NewerRight []string `json:"newerRight"`
+	Different  []string `json:"different"` // differ without one being newer, or a file against a directory
+	Identical  []string `json:"identical"`
+}
+
+// CompareDirectories recursively compares two trees by name, size, modification
+// time and optionally content.
+func (c *CompareService) CompareDirectories(left string, right string, options CompareOptions) Result[DirectoryComparison] {
+	leftResult := canonicalPath(left)
+	if leftResult.Error != nil {
+		return Result[DirectoryComparison]{Error: leftResult.Error}
+	}
+	rightResult := canonicalPath(right)
+	if rightResult.Error != nil {
+		return Result[DirectoryComparison]{Error: rightResult.Error}
+	}
+
+	comparison, err := compareDirectories(*leftResult.Data, *rightResult.Data, options)
+	if err != nil {
+		return Result[DirectoryComparison]{Error: &AppError{
+			Code:       CompareError,
+			Message:    fmt.Sprintf("failed to compare %s and %s: %v", *leftResult.Data, *rightResult.Data, err),
+			InnerError: err,
+		}}
+	}
+	return Result[DirectoryComparison]{Data: comparison}
+}
+
+func compareDirectories(left, right string, options CompareOptions) (*DirectoryComparison, error) {
+	for _, dir := range []string{left, right} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+	if left == right {
+		return nil, fmt.Errorf("cannot compare a directory with itself")
+	}
+
+	comparison := &DirectoryComparison{
+		Left: left, Right: right,
+		OnlyLeft: []string{}, OnlyRight: []string{}, NewerLeft: []string{}, NewerRight: []string{},
+		Different: []string{}, Identical: []string{},
+	}
+	tolerance := time.Duration(options.TimeTolerance) * time.Second
+	if err := compareTree(left, right, "", options.CompareContent, tolerance, comparison); err != nil {
+		return nil, err
+	}
+	return comparison, nil
+}
+
+// compareTree compares the directory rel of both sides and recurses into the
+// directories they share.
+func compareTree(left, right, rel string, content bool, tolerance time.Duration, comparison *DirectoryComparison) error {
+	leftEntries, err := readDirInfo(filepath.Join(left, rel))
+	if err != nil {
+		return err
+	}
+	rightEntries, err := readDirInfo(filepath.Join(right, rel))
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(leftEntries)+len(rightEntries))
+	for name := range leftEntries {
+		names = append(names, name)
+	}
+	for name := range rightEntries {
+		if _, ok := leftEntries[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(rel, name)
+		leftInfo, inLeft := leftEntries[name]
+		rightInfo, inRight := rightEntries[name]
+		switch {
+		case !inRight:
+			comparison.OnlyLeft = append(comparison.OnlyLeft, path)
+		case !inLeft:
+			comparison.OnlyRight = append(comparison.OnlyRight, path)
+		case leftInfo.IsDir() && rightInfo.IsDir():
+			if err := compareTree(left, right, path, content, tolerance, comparison); err != nil {
+				return err
+			}
+		default:
+			switch compareEntries(filepath.Join(left, path), leftInfo, filepath.Join(right, path), rightInfo, content, tolerance) {
+			case compareIdentical:
+				comparison.Identical = append(comparison.Identical, path)
+			case compareNewerLeft:
+				comparison.NewerLeft = append(comparison.NewerLeft, path)
+			case compareNewerRight:
+				comparison.NewerRight = append(comparison.NewerRight, path)
+			default:
+				comparison.Different = append(comparison.Different, path)
+			}
+		}
+	}
+	return nil
+}
+
+type compareResult int
+
+const (
+	compareIdentical compareResult = iota
+	compareNewerLeft
+	compareNewerRight
+	compareDifferent
+)
+
+// compareEntries compares two entries of the same name, at least one not a directory.
+func compareEntries(leftPath string, left fs.FileInfo, rightPath string, right fs.FileInfo, content bool, tolerance time.Duration) compareResult {
+	leftType, rightType := left.Mode().Type(), right.Mode().Type()
+	if leftType != rightType {
+		return compareDifferent
+	}
+	if leftType&fs.ModeSymlink != 0 {
+		leftTarget, _ := os.Readlink(leftPath)
+		rightTarget, _ := os.Readlink(rightPath)
+		if leftTarget == rightTarget {
+			return compareIdentical
+		}
+		return newerEntry(left, right, tolerance)
+	}
+
+	sameSize := left.Size() == right.Size()
+	if content && sameSize && leftType.IsRegular() {
+		if same, err := sameFileContent(leftPath, rightPath); err == nil && same {
+			return compareIdentical
+		}
+		return newerEntry(left, right, tolerance)
+	}
+	if result := newerEntry(left, right, tolerance); result != compareDifferent || !sameSize {
+		return result
+	}
+	return compareIdentical
+}
+
+// newerEntry tells which side was modified last, compareDifferent if neither.
+func newerEntry(left, right fs.FileInfo, tolerance time.Duration) compareResult {
+	delta := left.ModTime().Sub(right.ModTime())
+	switch {
+	case delta > tolerance:
+		return compareNewerLeft
+	case -delta > tolerance:
+		return compareNewerRight
+	}
+	return compareDifferent
+}
+
+// readDirInfo returns the Lstat info of the entries of dir by name.
+func readDirInfo(dir string) (map[string]fs.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := make(map[string]fs.FileInfo, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed meanwhile
+		}
+		infos[entry.Name()] = info
+	}
+	return infos, nil
+}
+
+// FileDiff is the result of DiffFiles. Text files get hunks of numbered lines
+// (with the changed parts of modified lines) and a unified patch; other files
+// only the offset of their first difference.
+type FileDiff struct {
+	Left      string     `json:"left"`
+	Right     string     `json:"right"`
+	Identical bool       `json:"identical"` // byte for byte
+	Binary    bool       `json:"binary"`
+	DifferAt  int64      `json:"differAt"` // binary files: offset of the first different byte, -1 if identical
+	Hunks     []DiffHunk `json:"hunks"`
+	Patch     string     `json:"patch"`     // unified format
+	Truncated bool       `json:"truncated"` // the files were too large to be compared to the end, or the diff was cut
+}
+
+type DiffHunk struct {
+	OldStart int        `json:"oldStart"`
+	OldLines int        `json:"oldLines"`
+	NewStart int        `json:"newStart"`
+	NewLines int        `json:"newLines"`
+	Lines    []DiffLine `json:"lines"`
+}
+
+type DiffLineKind string
+
+const (
+	DiffContext DiffLineKind = "context"
+	DiffDelete  DiffLineKind = "delete"
+	DiffInsert  DiffLineKind = "insert"
+)
+
+type DiffLine struct {
+	Kind      DiffLineKind `json:"kind"`
+	OldNumber int          `json:"oldNumber,omitempty"`
+	NewNumber int          `json:"newNumber,omitempty"`
+	Text      string       `json:"text"`                // without the \n, a \r of a CRLF line ending is kept
+	NoNewline bool         `json:"noNewline,omitempty"` // the last line of a file that does not end with a newline
+	// Segments split Text in changed and unchanged parts, for a deleted line
+	// paired with an inserted one. Empty when the whole line changed.
+	Segments []DiffSegment `json:"segments,omitempty"`
+}
+
+type DiffSegment struct {
+	Text    string `json:"text"`
+	Changed bool   `json:"changed"`
+}
+
+const (
+	// Only the beginning of larger files is compared line by line.
+	diffMaxFileSize = 4 << 20
+	// Lines of hunks returned, the diff is cut after.
+	diffMaxLines     = 5000
+	diffContextLines = 3
+	// Modified lines longer than this are not highlighted within.
+	diffMaxIntralineLength = 1000
+)
+
+// DiffFiles compares two files line by line, or finds where they differ when
+// either is not text.
+func (c *CompareService) DiffFiles(a string, b string) Result[FileDiff] {
+	leftResult := canonicalPath(a)
+	if leftResult.Error != nil {
+		return Result[FileDiff]{Error: leftResult.Error}
+	}
+	rightResult := canonicalPath(b)
+	if rightResult.Error != nil {
+		return Result[FileDiff]{Error: rightResult.Error}
+	}
+
+	fileDiff, err := diffFiles(*leftResult.Data, *rightResult.Data)
+	if err != nil {
+		return Result[FileDiff]{Error: &AppError{
+			Code:       CompareError,
+			Message:    fmt.Sprintf("failed to compare %s and %s: %v", *leftResult.Data, *rightResult.Data, err),
+			InnerError: err,
+		}}
+	}
+	return Result[FileDiff]{Data: fileDiff}
+}
+
+func diffFiles(left, right string) (*FileDiff, error) {
+	fileDiff := &FileDiff{Left: left, Right: right, DifferAt: -1, Hunks: []DiffHunk{}}
+
+	offset, err := firstDifference(left, right)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		fileDiff.Identical = true
+		return fileDiff, nil
+	}
+
+	leftData, leftTruncated, err := readHead(left, diffMaxFileSize)
+	if err != nil {
+		return nil, err
+	}
+	rightData, rightTruncated, err := readHead(right, diffMaxFileSize)
+	if err != nil {
+		return nil, err
+	}
+	leftText, _, leftOK := decodeText(leftData, leftTruncated)
+	rightText, _, rightOK := decodeText(rightData, rightTruncated)
+	if !leftOK || !rightOK {
+		fileDiff.Binary = true
+		fileDiff.DifferAt = offset
+		return fileDiff, nil
+	}
+
+	leftLines := diffSplitLines(leftText, leftTruncated)
+	rightLines := diffSplitLines(rightText, rightTruncated)
+	lines := diffLines(leftLines, rightLines)
+	fileDiff.Hunks, fileDiff.Truncated = diffHunks(lines)
+	fileDiff.Truncated = fileDiff.Truncated || leftTruncated || rightTruncated
+	fileDiff.Patch = unifiedPatch(left, right, fileDiff.Hunks)
+	return fileDiff, nil
+}
+
+// firstDifference returns the offset of the first byte that differs, -1 if the files are the same.
+func firstDifference(left, right string) (int64, error) {
+	leftFile, err := os.Open(left)
+	if err != nil {
+		return 0, err
+	}
+	defer leftFile.Close()
+	rightFile, err := os.Open(right)
+	if err != nil {
+		return 0, err
+	}
+	defer rightFile.Close()
+	for _, file := range []*os.File{leftFile, rightFile} {
+		if info, err := file.Stat(); err != nil {
+			return 0, err
+		} else if info.IsDir() {
+			return 0, fmt.Errorf("%s is a directory", file.Name())
+		}
+	}
+
+	leftBuf := make([]byte, 1<<20)
+	rightBuf := make([]byte, 1<<20)
+	for offset := int64(0); ; {
+		n, leftErr := io.ReadFull(leftFile, leftBuf)
+		m, rightErr := io.ReadFull(rightFile, rightBuf)
+		for _, err := range []error{leftErr, rightErr} {
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return 0, err
+			}
+		}
+		for i := 0; i < min(n, m); i++ {
+			if leftBuf[i] != rightBuf[i] {
+				return offset + int64(i), nil
+			}
+		}
+		if n != m {
+			return offset + int64(min(n, m)), nil
+		}
+		if leftErr != nil {
+			return -1, nil // both ended
+		}
+		offset += int64(n)
+	}
+}
+
+// readHead reads at most limit bytes of a file, truncated tells if there was more.
+func readHead(path string, limit int64) ([]byte, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(data)) > limit {
+		return data[:limit], true, nil
+	}
+	return data, false, nil
+}
+
+// diffSplitLines splits text in lines with their line ending, so that lines
+// ending differently do not compare equal. The last line of a truncated text
+// is dropped since it is probably incomplete.
+func diffSplitLines(text string, truncated bool) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" || (truncated && len(lines) > 1) {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns every line of both sides in order, numbered, with the
+// modified lines highlighted within.
+func diffLines(left, right []string) []DiffLine {
+	// Each distinct line becomes a rune, the diff runs on those
+	lineRunes := map[string]rune{}
+	toRunes := func(lines []string) []rune {
+		runes := make([]rune, len(lines))
+		for i, line := range lines {
+			r, ok := lineRunes[line]
+			if !ok {
+				r = rune(len(lineRunes))
+				lineRunes[line] = r
+			}
+			runes[i] = r
+		}
+		return runes
+	}
+	dmp := diffmatchpatch.New()
+	diffs := dmp.DiffMainRunes(toRunes(left), toRunes(right), false)
+
+	newLine := func(kind DiffLineKind, line string) DiffLine {
+		text, ended := strings.CutSuffix(line, "\n")
+		return DiffLine{Kind: kind, Text: text, NoNewline: !ended}
+	}
+	var lines []DiffLine
+	oldNumber, newNumber := 0, 0
+	for i := 0; i < len(diffs); i++ {
+		count := utf8.RuneCountInString(diffs[i].Text)
+		switch diffs[i].Type {
+		case diffmatchpatch.DiffEqual:
+			for range count {
+				line := newLine(DiffContext, left[oldNumber])
+				oldNumber++
+				newNumber++
+				line.OldNumber, line.NewNumber = oldNumber, newNumber
+				lines = append(lines, line)
+			}
+		case diffmatchpatch.DiffDelete:
+			deleted := make([]DiffLine, count)
+			for j := range deleted {
+				deleted[j] = newLine(DiffDelete, left[oldNumber])
+				oldNumber++
+				deleted[j].OldNumber = oldNumber
+			}
+			var inserted []DiffLine
+			if i+1 < len(diffs) && diffs[i+1].Type == diffmatchpatch.DiffInsert {
+				i++
+				inserted = make([]DiffLine, utf8.RuneCountInString(diffs[i].Text))
+				for j := range inserted {
+					inserted[j] = newLine(DiffInsert, right[newNumber])
+					newNumber++
+					inserted[j].NewNumber = newNumber
+				}
+			}
+			// A deleted block followed by an inserted one is a modification, line by line
+			for j := 0; j < min(len(deleted), len(inserted)); j++ {
+				deleted[j].Segments, inserted[j].Segments = intralineSegments(dmp, deleted[j].Text, inserted[j].Text)
+			}
+			lines = append(lines, deleted...)
+			lines = append(lines, inserted...)
+		case diffmatchpatch.DiffInsert:
+			for range count {
+				line := newLine(DiffInsert, right[newNumber])
+				newNumber++
+				line.NewNumber = newNumber
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+// intralineSegments returns the parts of two versions of a line, nil when they
+// have too little in common for highlights to help.
+func intralineSegments(dmp *diffmatchpatch.DiffMatchPatch, oldText, newText string) ([]DiffSegment, []DiffSegment) {
+	if len(oldText) > diffMaxIntralineLength || len(newText) > diffMaxIntralineLength {
+		return nil, nil
+	}
+	diffs := dmp.DiffCleanupSemantic(dmp.DiffMain(oldText, newText, false))
+
+	var oldSegments, newSegments []DiffSegment
+	common := 0
+	for _, d := range diffs {
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			common += len(d.Text)
+			oldSegments = append(oldSegments, DiffSegment{Text: d.Text})
+			newSegments = append(newSegments, DiffSegment{Text: d.Text})
+		case diffmatchpatch.DiffDelete:
+			oldSegments = append(oldSegments, DiffSegment{Text: d.Text, Changed: true})
+		case diffmatchpatch.DiffInsert:
+			newSegments = append(newSegments, DiffSegment{Text: d.Text, Changed: true})
+		}
+	}
+	if common == 0 {
+		return nil, nil
+	}
+	return oldSegments, newSegments
+}
+
+// diffHunks groups the changed lines with diffContextLines of context around
+// them, cutting after diffMaxLines lines.
+func diffHunks(lines []DiffLine) ([]DiffHunk, bool) {
+	hunks := []DiffHunk{}
+	total := 0
+	for i := 0; i < len(lines); {
+		if lines[i].Kind == DiffContext {
+			i++
+			continue
+		}
+		// Extend the hunk while the next change is close enough to share context
+		start := max(0, i-diffContextLines)
+		end := i
+		for end < len(lines) {
+			if lines[end].Kind != DiffContext {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].Kind == DiffContext {
+				next++
+			}
+			if next == len(lines) || next-end > 2*diffContextLines {
+				end = min(len(lines), end+diffContextLines)
+				break
+			}
+			end = next
+		}
+
+		if total+end-start > diffMaxLines {
+			end = start + diffMaxLines - total
+			if end <= start {
+				return hunks, true
+			}
+			hunks = append(hunks, newDiffHunk(lines[start:end]))
+			return hunks, true
+		}
+		total += end - start
+		hunks = append(hunks, newDiffHunk(lines[start:end]))
+		i = end
+	}
+	return hunks, false
+}
+
+func newDiffHunk(lines []DiffLine) DiffHunk {
+	hunk := DiffHunk{Lines: lines}
+	for _, line := range lines {
+		if line.Kind != DiffInsert {
+			if hunk.OldLines == 0 {
+				hunk.OldStart = line.OldNumber
+			}
+			hunk.OldLines++
+		}
+		if line.Kind != DiffDelete {
+			if hunk.NewLines == 0 {
+				hunk.NewStart = line.NewNumber
+			}
+			hunk.NewLines++
+		}
+	}
+	// A side without lines is an empty file, it starts at 0 as in diff -u
+	return hunk
+}
+
+// unifiedPatch formats hunks like diff -u.
+func unifiedPatch(left, right string, hunks []DiffHunk) string {
+	if len(hunks) == 0 {
+		return ""
+	}
+	var patch strings.Builder
+	fmt.Fprintf(&patch, "--- %s\n+++ %s\n", left, right)
+	for _, hunk := range hunks {
+		fmt.Fprintf(&patch, "@@ -%s +%s @@\n", unifiedRange(hunk.OldStart, hunk.OldLines), unifiedRange(hunk.NewStart, hunk.NewLines))
+		for _, line := range hunk.Lines {
+			prefix := " "
+			switch line.Kind {
+			case DiffDelete:
+				prefix = "-"
+			case DiffInsert:
+				prefix = "+"
+			}
+			patch.WriteString(prefix + line.Text + "\n")
+			if line.NoNewline {
+				patch.WriteString("\\ No newline at end of file\n")
+			}
+		}
+	}
+	return patch.String()
+}
+
+func unifiedRange(start, count int) string {
+	if count == 1 {
+		return strconv.Itoa(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffFilesLineEndings(t *testing.T) {
+	tests := []struct {
+		name  string
+		left  string
+		right string
+		want  string // patch without the file names
+	}{
+		{"line endings", "a\nb\n", "a\r\nb\n", "@@ -1,2 +1,2 @@\n-a\n+a\r\n b\n"},
+		{"newline added", "a\nb", "a\nb\n", "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n"},
+		{"newline removed", "a\n", "a", "@@ -1 +1 @@\n-a\n+a\n\\ No newline at end of file\n"},
+		{"both without newline", "a\nb\nc", "a\nx\nc", "@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n\\ No newline at end of file\n"},
+		{"empty file", "", "a", "@@ -0,0 +1 @@\n+a\n\\ No newline at end of file\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			left, right := filepath.Join(dir, "left"), filepath.Join(dir, "right")
+			os.WriteFile(left, []byte(test.left), 0o644)
+			os.WriteFile(right, []byte(test.right), 0o644)
+
+			fileDiff, err := diffFiles(left, right)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "--- " + left + "\n+++ " + right + "\n" + test.want
+			if fileDiff.Identical || len(fileDiff.Hunks) == 0 || fileDiff.Patch != want {
+				t.Fatalf("diffFiles patch = %q, want %q", fileDiff.Patch, want)
+			}
+		})
+	}
+}
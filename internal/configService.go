@@ -0,0 +1,355 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// ConfigService owns lazydir's settings file (~/.config/lazydir/config.json).
+// The file is validated against the Config schema, missing keys fall back to
+// defaults, and external edits are picked up while the app is running.
+type ConfigService struct {
+	App *application.App // used to notify the frontend of changes, may be nil
+
+	mu      sync.Mutex
+	config  Config
+	loadErr *AppError // error of the last load, the previous valid config stays in use
+	loaded  bool
+	modTime time.Time
+	size    int64
+}
+
+// Config is the schema of config.json.
+type Config struct {
+	Version        int               `json:"version"`
+	ShowHidden     bool              `json:"showHidden"`
+	DefaultView    string            `json:"defaultView"`    // "list" or "grid"
+	ConfirmDelete  bool              `json:"confirmDelete"`  // ask before deleting files
+	ConflictPolicy ConflictPolicy    `json:"conflictPolicy"` // what to do when a pasted item already exists
+	Keybindings    map[string]string `json:"keybindings"`    // action -> shortcut, e.g. "newTab": "Ctrl+T"
+	Theme          string            `json:"theme"`
+	CustomActions  []CustomAction    `json:"customActions"` // user commands shown in the context menu
+	Terminal       string            `json:"terminal"`      // terminal emulator command, auto-detected if empty
+	GitStatus      bool              `json:"gitStatus"`     // decorate listings inside git repositories
+	MediaColumns   bool              `json:"mediaColumns"`  // read image/audio metadata in listings for the optional columns
+	Preserve       PreserveOptions   `json:"preserve"`      // metadata kept when copying, and moving across devices
+	VerifyCopies   bool              `json:"verifyCopies"`  // compare hashes of every copied file with its source
+}
+
+// ConflictPolicy decides what happens when a copy/move destination already exists.
+type ConflictPolicy string
+
+const (
+	ConflictAsk       ConflictPolicy = "ask"       // fail so the frontend can ask the user
+	ConflictSkip      ConflictPolicy = "skip"      // leave the existing item, skip the source
+	ConflictOverwrite ConflictPolicy = "overwrite" // replace the existing item
+	ConflictRename    ConflictPolicy = "rename"    // keep both, the copy gets a "(copy N)" suffix
+)
+
+const (
+	configFileName    = "config.json"
+	configFileVersion = 1
+
+	// configPollInterval is how often the config file is checked for external changes.
+	configPollInterval = time.Second
+
+	EventConfigChanged = "config:changed"
+	EventConfigError   = "config:error"
+)
+
+var (
+	configViews            = []string{"list", "grid"}
+	configConflictPolicies = []ConflictPolicy{ConflictAsk, ConflictSkip, ConflictOverwrite, ConflictRename}
+	// Themes defined in frontend/src/index.css, "default" is the :root palette.
+	configThemes = []string{
+		"default", "serious", "cozy", "light", "dracula", "coral", "nature", "ocean", "sunset",
+		"cyberpunk", "mars", "desert", "arctic", "candy", "vintage", "matrix", "professional", "slate",
+	}
+	configKeyModifiers = []string{"Ctrl", "Shift", "Alt", "Meta"}
+)
+
+// DefaultConfig returns the settings used when there is no config file.
+func DefaultConfig() Config {
+	return Config{
+		Version:        configFileVersion,
+		ShowHidden:     false,
+		DefaultView:    "list",
+		ConfirmDelete:  true,
+		ConflictPolicy: ConflictAsk,
+		Keybindings: map[string]string{
+			"newTab":       "Ctrl+T",
+			"closeTab":     "Ctrl+W",
+			"previousTab":  "Ctrl+PageUp",
+			"nextTab":      "Ctrl+PageDown",
+			"refresh":      "F5",
+			"focusPathBar": "Ctrl+L",
+		},
+		Theme:         "default",
+		CustomActions: []CustomAction{},
+		GitStatus:     true,
+		Preserve:      defaultPreserveOptions(),
+	}
+}
+
+// ServiceStartup loads the config and starts watching the file for changes.
+func (c *ConfigService) ServiceStartup(ctx context.Context, options application.ServiceOptions) error {
+	c.mu.Lock()
+	c.reload()
+	c.mu.Unlock()
+
+	go c.watch(ctx)
+	return nil
+}
+
+// GetConfig returns the current settings.
+// If config.json is invalid the validation error is returned instead.
+func (c *ConfigService) GetConfig() Result[Config] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.loaded {
+		c.reload()
+	}
+	if c.loadErr != nil {
+		return Result[Config]{Error: c.loadErr}
+	}
+	config := c.config.clone()
+	return Result[Config]{Data: &config}
+}
+
+// UpdateConfig validates and saves new settings.
+func (c *ConfigService) UpdateConfig(config Config) Result[Config] {
+	config.Version = configFileVersion
+	if config.Keybindings == nil {
+		config.Keybindings = map[string]string{}
+	}
+	if config.CustomActions == nil {
+		config.CustomActions = []CustomAction{}
+	}
+	if appErr := config.validate(); appErr != nil {
+		return Result[Config]{Error: appErr}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path, err := configFilePath(configFileName)
+	if err != nil {
+		return Result[Config]{Error: &AppError{Code: ConfigReadError, Message: fmt.Sprintf("failed to resolve config file: %v", err), InnerError: err}}
+	}
+	if err := writeJSONFileAtomic(path, config); err != nil {
+		return Result[Config]{Error: &AppError{Code: ConfigWriteError, Message: fmt.Sprintf("failed to save config: %v", err), InnerError: err}}
+	}
+
+	c.config = config.clone()
+	c.loadErr = nil
+	c.loaded = true
+	if info, err := os.Stat(path); err == nil {
+		c.modTime, c.size = info.ModTime(), info.Size()
+	}
+	c.emit(EventConfigChanged, c.config.clone())
+
+	return Result[Config]{Data: &config}
+}
+
+// current returns the settings other services should use: the last valid config, or the defaults.
+func (c *ConfigService) current() Config {
+	if c == nil {
+		return DefaultConfig()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.loaded {
+		c.reload()
+	}
+	return c.config.clone()
+}
+
+// watch polls the config file and reloads it when it changes on disk.
+// Polling keeps this portable and works with editors that replace the file on save.
+func (c *ConfigService) watch(ctx context.Context) {
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		path, err := configFilePath(configFileName)
+		if err != nil {
+			continue
+		}
+		var modTime time.Time
+		var size int64
+		if info, err := os.Stat(path); err == nil {
+			modTime, size = info.ModTime(), info.Size()
+		}
+
+		c.mu.Lock()
+		if modTime.Equal(c.modTime) && size == c.size {
+			c.mu.Unlock()
+			continue
+		}
+		previous := c.config
+		c.reload()
+		if c.loadErr != nil {
+			Log(fmt.Sprintf("ConfigService: %v", c.loadErr))
+			c.emit(EventConfigError, *c.loadErr)
+		} else if !reflect.DeepEqual(previous, c.config) {
+			c.emit(EventConfigChanged, c.config.clone())
+		}
+		c.mu.Unlock()
+	}
+}
+
+// reload reads config.json. On error the previous config is kept and loadErr is set.
+// Must be called with c.mu held.
+func (c *ConfigService) reload() {
+	if !c.loaded {
+		c.config = DefaultConfig()
+		c.loaded = true
+	}
+
+	path, err := configFilePath(configFileName)
+	if err != nil {
+		c.loadErr = &AppError{Code: ConfigReadError, Message: fmt.Sprintf("failed to resolve config file: %v", err), InnerError: err}
+		return
+	}
+
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		c.config, c.loadErr = DefaultConfig(), nil
+		c.modTime, c.size = time.Time{}, 0
+		return
+	}
+	if err != nil {
+		c.loadErr = &AppError{Code: ConfigReadError, Message: fmt.Sprintf("failed to read config: %v", err), InnerError: err}
+		return
+	}
+	c.modTime, c.size = info.ModTime(), info.Size()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.loadErr = &AppError{Code: ConfigReadError, Message: fmt.Sprintf("failed to read config: %v", err), InnerError: err}
+		return
+	}
+
+	config, appErr := parseConfig(data)
+	if appErr != nil {
+		c.loadErr = appErr
+		return
+	}
+	c.config, c.loadErr = config, nil
+}
+
+func (c *ConfigService) emit(name string, data any) {
+	if c.App != nil {
+		c.App.Event.Emit(name, data)
+	}
+}
+
+// parseConfig decodes config.json on top of the defaults and validates the result.
+// Unknown keys are rejected so typos don't go unnoticed.
+func parseConfig(data []byte) (Config, *AppError) {
+	config := DefaultConfig()
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return Config{}, &AppError{Code: ConfigValidationError, Message: fmt.Sprintf("invalid %s: %v", configFileName, err), InnerError: err}
+	}
+	if config.Version > configFileVersion {
+		return Config{}, &AppError{
+			Code:    ConfigValidationError,
+			Message: fmt.Sprintf("%s was written by a newer lazydir (version %d, supported %d)", configFileName, config.Version, configFileVersion),
+		}
+	}
+	config.Version = configFileVersion
+
+	if appErr := config.validate(); appErr != nil {
+		return Config{}, appErr
+	}
+	return config, nil
+}
+
+// validate checks every field and reports all problems at once.
+func (c Config) validate() *AppError {
+	var problems []string
+
+	if !slices.Contains(configViews, c.DefaultView) {
+		problems = append(problems, fmt.Sprintf("defaultView must be one of %s, got %q", strings.Join(configViews, ", "), c.DefaultView))
+	}
+	if !slices.Contains(configConflictPolicies, c.ConflictPolicy) {
+		problems = append(problems, fmt.Sprintf("conflictPolicy must be one of ask, skip, overwrite, rename, got %q", c.ConflictPolicy))
+	}
+	if !slices.Contains(configThemes, c.Theme) {
+		problems = append(problems, fmt.Sprintf("unknown theme %q", c.Theme))
+	}
+
+	known := DefaultConfig().Keybindings
+	for _, action := range slices.Sorted(maps.Keys(c.Keybindings)) {
+		if _, ok := known[action]; !ok {
+			problems = append(problems, fmt.Sprintf("keybindings: unknown action %q", action))
+			continue
+		}
+		if err := validateShortcut(c.Keybindings[action]); err != nil {
+			problems = append(problems, fmt.Sprintf("keybindings.%s: %v", action, err))
+		}
+	}
+
+	problems = append(problems, validateCustomActions(c.CustomActions)...)
+
+	if len(problems) > 0 {
+		return &AppError{
+			Code:    ConfigValidationError,
+			Message: fmt.Sprintf("invalid %s:\n- %s", configFileName, strings.Join(problems, "\n- ")),
+		}
+	}
+	return nil
+}
+
+// validateShortcut accepts "<Modifier>+...+<Key>", e.g. "Ctrl+Shift+N" or "F5".
+func validateShortcut(shortcut string) error {
+	parts := strings.Split(shortcut, "+")
+	key := parts[len(parts)-1]
+	if strings.TrimSpace(key) == "" {
+		return fmt.Errorf("missing key in %q", shortcut)
+	}
+	for _, modifier := range parts[:len(parts)-1] {
+		if !slices.Contains(configKeyModifiers, modifier) {
+			return fmt.Errorf("unknown modifier %q in %q", modifier, shortcut)
+		}
+	}
+	return nil
+}
+
+// copyOptions returns the options of copies that don't choose their own.
+func (c Config) copyOptions() CopyOptions {
+	return CopyOptions{Preserve: c.Preserve, Verify: c.VerifyCopies}
+}
+
+func (c Config) clone() Config {
+	c.Keybindings = maps.Clone(c.Keybindings)
+	c.CustomActions = slices.Clone(c.CustomActions)
+	for i := range c.CustomActions {
+		c.CustomActions[i].Patterns = slices.Clone(c.CustomActions[i].Patterns)
+		c.CustomActions[i].MimeTypes = slices.Clone(c.CustomActions[i].MimeTypes)
+	}
+	return c
+}
@@ -0,0 +1,84 @@
+//go:build linux
+
+package internal
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyFileData copies the content of src to dst, a new empty file, in the
+// cheapest way the file systems allow:
+//   - a reflink (FICLONE) shares the data on btrfs, XFS, ... until either copy changes,
+//   - copy_file_range copies inside the kernel, or on the server for NFS and SMB,
+//   - buffered reads and writes otherwise.
+//
+// Sparse files stay sparse: only the data segments found with SEEK_DATA and
+// SEEK_HOLE are copied, the holes are left unwritten.
+//
+// A size of 0 is not trusted: procfs, sysfs and some FUSE files report it but
+// have content, which only reading returns.
+func copyFileData(dst, src *os.File, size int64) error {
+	if size == 0 {
+		_, err := io.Copy(io.NewOffsetWriter(dst, 0), src)
+		return err
+	}
+	srcFd, dstFd := int(src.Fd()), int(dst.Fd())
+	if err := unix.IoctlFileClone(dstFd, srcFd); err == nil {
+		return nil
+	}
+
+	copyRange := true
+	for offset := int64(0); offset < size; {
+		start, err := unix.Seek(srcFd, offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			break // only a hole left
+		}
+		end := size
+		if err != nil {
+			start = offset // no SEEK_DATA on this file system, it is all data
+		} else if hole, err := unix.Seek(srcFd, start, unix.SEEK_HOLE); err == nil {
+			end = min(hole, size)
+		}
+		if start >= size {
+			break
+		}
+
+		if err := copySegment(dst, src, start, end, &copyRange); err != nil {
+			return err
+		}
+		offset = end
+	}
+
+	// Extends dst over a trailing hole
+	return dst.Truncate(size)
+}
+
+// copySegment copies the bytes [start, end) of src at the same offset in dst,
+// with copy_file_range while the file systems support it.
+func copySegment(dst, src *os.File, start, end int64, copyRange *bool) error {
+	for *copyRange && start < end {
+		readOffset, writeOffset := start, start
+		n, err := unix.CopyFileRange(int(src.Fd()), &readOffset, int(dst.Fd()), &writeOffset, int(min(end-start, 1<<30)), 0)
+		switch {
+		case err == nil && n == 0:
+			return nil // src shrank
+		case err == nil:
+			start += int64(n)
+		case errors.Is(err, unix.EINTR):
+		case errors.Is(err, unix.EXDEV), errors.Is(err, unix.ENOSYS), errors.Is(err, unix.EOPNOTSUPP),
+			errors.Is(err, unix.EINVAL), errors.Is(err, unix.EPERM), errors.Is(err, unix.EIO):
+			*copyRange = false // not between these files, copy the rest by hand
+		default:
+			return err
+		}
+	}
+	if start >= end {
+		return nil
+	}
+	_, err := io.Copy(io.NewOffsetWriter(dst, start), io.NewSectionReader(src, start, end-start))
+	return err
+}
@@ -0,0 +1,167 @@
+//go:build linux
+
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+const (
+	testDenseSize  = 32 << 20
+	testSparseSize = 256 << 20
+)
+
+// createTestFile makes a file of size bytes with random data at each offset in
+// data (1 MiB each), or everywhere when data is nil. The rest is holes.
+func createTestFile(tb testing.TB, path string, size int64, data []int64) {
+	tb.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer file.Close()
+
+	chunk := make([]byte, 1<<20)
+	if data == nil {
+		for offset := int64(0); offset < size; offset += int64(len(chunk)) {
+			data = append(data, offset)
+		}
+	}
+	for _, offset := range data {
+		rand.Read(chunk)
+		if _, err := file.WriteAt(chunk, offset); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := file.Truncate(size); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// copyTestFile copies src to a new dst with copy.
+func copyTestFile(tb testing.TB, src, dst string, copy func(dst, src *os.File, size int64) error) {
+	tb.Helper()
+	source, err := os.Open(src)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	dest, err := os.Create(dst)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer dest.Close()
+	if err := copy(dest, source, info.Size()); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func bufferedCopy(dst, src *os.File, size int64) error {
+	_, err := io.Copy(dst, src)
+	return err
+}
+
+func allocatedBlocks(tb testing.TB, path string) int64 {
+	tb.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return info.Sys().(*syscall.Stat_t).Blocks
+}
+
+func sameContent(tb testing.TB, a, b string) bool {
+	tb.Helper()
+	dataA, err := os.ReadFile(a)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	dataB, err := os.ReadFile(b)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return bytes.Equal(dataA, dataB)
+}
+
+func TestCopyFileDataDense(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	createTestFile(t, src, 3<<20+123, nil)
+
+	copyTestFile(t, src, dst, copyFileData)
+	if !sameContent(t, src, dst) {
+		t.Fatal("the copy differs from the source")
+	}
+}
+
+func TestCopyFileDataWithoutSize(t *testing.T) {
+	// procfs files have content but a size of 0
+	src := "/proc/self/status"
+	if info, err := os.Stat(src); err != nil || info.Size() != 0 {
+		t.Skip("no procfs")
+	}
+	dst := filepath.Join(t.TempDir(), "dst")
+	copyTestFile(t, src, dst, copyFileData)
+	if content, _ := os.ReadFile(dst); !bytes.Contains(content, []byte("Name:")) {
+		t.Fatalf("the copy of %s contains %q", src, content)
+	}
+}
+
+func TestCopyFileDataKeepsHoles(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	createTestFile(t, src, 64<<20, []int64{0, 32 << 20})
+
+	srcBlocks := allocatedBlocks(t, src)
+	if srcBlocks*512 >= 64<<20 {
+		t.Skip("the file system of the temporary directory does not support sparse files")
+	}
+
+	copyTestFile(t, src, dst, copyFileData)
+	if !sameContent(t, src, dst) {
+		t.Fatal("the copy differs from the source")
+	}
+	// Reflinks and some file systems may allocate a little differently, but never the holes
+	if dstBlocks := allocatedBlocks(t, dst); dstBlocks > 2*srcBlocks {
+		t.Fatalf("the copy uses %d blocks, the source %d: the holes were filled", dstBlocks, srcBlocks)
+	}
+}
+
+func BenchmarkCopyFileData(b *testing.B) {
+	dir := b.TempDir()
+	dense, sparse := filepath.Join(dir, "dense"), filepath.Join(dir, "sparse")
+	createTestFile(b, dense, testDenseSize, nil)
+	createTestFile(b, sparse, testSparseSize, []int64{0, testSparseSize / 2})
+
+	for _, file := range []struct {
+		name string
+		path string
+		size int64
+	}{{"dense", dense, testDenseSize}, {"sparse", sparse, testSparseSize}} {
+		for _, engine := range []struct {
+			name string
+			copy func(dst, src *os.File, size int64) error
+		}{{"copyFileData", copyFileData}, {"io.Copy", bufferedCopy}} {
+			b.Run(file.name+"/"+engine.name, func(b *testing.B) {
+				b.SetBytes(file.size)
+				dst := filepath.Join(dir, "dst")
+				for i := 0; i < b.N; i++ {
+					copyTestFile(b, file.path, dst, engine.copy)
+					b.StopTimer()
+					os.Remove(dst)
+					b.StartTimer()
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package internal
+
+import (
+	"io"
+	"os"
+)
+
+// copyFileData copies the content of src to dst with buffered reads and writes.
+func copyFileData(dst, src *os.File, size int64) error {
+	_, err := io.Copy(dst, src)
+	return err
+}
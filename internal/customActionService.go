@@ -0,0 +1,338 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CustomActionService runs the user-defined commands of the config file
+// ("customActions"), in the spirit of Thunar's custom actions.
+type CustomActionService struct {
+	Config  *ConfigService
+	Dialogs *DialogService // optional, shows captured output
+}
+
+// CustomAction is a user command offered in the context menu.
+//
+// The command is run by the shell (sh -c, cmd /C on Windows) after expanding
+// these placeholders, every path is quoted for the shell (on Windows it is
+// passed in an environment variable, which cmd does not expand again):
+//
+//	%f  path of the first selected item     %F  paths of all selected items
+//	%d  directory of the first item         %D  directories of all items
+//	%n  name of the first item              %N  names of all items
+//	%%  a literal %
+type CustomAction struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Description   string   `json:"description,omitempty"`
+	Icon          string   `json:"icon,omitempty"`
+	Command       string   `json:"command"`
+	Patterns      []string `json:"patterns,omitempty"`      // file name globs, e.g. "*.jpg", any name if empty
+	MimeTypes     []string `json:"mimeTypes,omitempty"`     // e.g. "image/*", any type if empty
+	AppliesTo     string   `json:"appliesTo,omitempty"`     // "files", "directories" or "" for both
+	MultiSelect   bool     `json:"multiSelect,omitempty"`   // offered when several items are selected
+	CaptureOutput bool     `json:"captureOutput,omitempty"` // wait for the command and show its output
+	Timeout       int      `json:"timeout,omitempty"`       // seconds CaptureOutput waits before stopping the command, 0 for the default
+}
+
+// CustomActionOutput is the result of an action run with CaptureOutput.
+type CustomActionOutput struct {
+	Command   string `json:"command"` // as given to the shell
+	Output    string `json:"output"`  // combined stdout and stderr
+	ExitCode  int    `json:"exitCode"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+const (
+	customActionFiles       = "files"
+	customActionDirectories = "directories"
+
+	// Captured output is cut beyond this size so a chatty command can't flood the dialog.
+	customActionMaxOutput = 64 * 1024
+	// A command run with CaptureOutput is stopped after this long unless the action sets a timeout.
+	customActionTimeout = 5 * time.Minute
+)
+
+// GetActionsFor returns the custom actions matching every given path, in config order.
+// The frontend calls it to build the context menu of a selection (or of the
+// current directory when nothing is selected).
+func (c *CustomActionService) GetActionsFor(paths []string) Result[[]CustomAction] {
+	actions := []CustomAction{}
+	if len(paths) == 0 {
+		return Result[[]CustomAction]{Data: &actions}
+	}
+
+	items := make([]customActionItem, 0, len(paths))
+	for _, p := range paths {
+		item, appErr := newCustomActionItem(p)
+		if appErr != nil {
+			return Result[[]CustomAction]{Error: appErr}
+		}
+		items = append(items, item)
+	}
+
+	for _, action := range c.Config.current().CustomActions {
+		if action.matches(items) {
+			actions = append(actions, action)
+		}
+	}
+	return Result[[]CustomAction]{Data: &actions}
+}
+
+// RunAction runs the custom action actionID on the given paths.
+// Actions with CaptureOutput wait for the command and return (and show) its output,
+// the others are started in the background.
+func (c *CustomActionService) RunAction(actionID string, paths []string) Result[CustomActionOutput] {
+	actions := c.Config.current().CustomActions
+	i := slices.IndexFunc(actions, func(a CustomAction) bool { return a.ID == actionID })
+	if i < 0 {
+		return Result[CustomActionOutput]{Error: &AppError{Code: CustomActionNotFoundError, Message: fmt.Sprintf("no custom action %q", actionID)}}
+	}
+	action := actions[i]
+
+	if len(paths) == 0 {
+		return Result[CustomActionOutput]{Error: &AppError{Code: CustomActionError, Message: fmt.Sprintf("%s needs a selection", action.Name)}}
+	}
+	items := make([]customActionItem, 0, len(paths))
+	for _, p := range paths {
+		item, appErr := newCustomActionItem(p)
+		if appErr != nil {
+			return Result[CustomActionOutput]{Error: appErr}
+		}
+		items = append(items, item)
+	}
+	if !action.matches(items) {
+		return Result[CustomActionOutput]{Error: &AppError{Code: CustomActionError, Message: fmt.Sprintf("%s does not apply to this selection", action.Name)}}
+	}
+
+	command, env, err := expandActionCommand(action.Command, items)
+	if err != nil {
+		return Result[CustomActionOutput]{Error: &AppError{Code: CustomActionError, Message: fmt.Sprintf("%s: %v", action.Name, err), InnerError: err}}
+	}
+
+	if !action.CaptureOutput {
+		cmd := shellCommand(context.Background(), command)
+		cmd.Dir = filepath.Dir(items[0].path)
+		if len(env) > 0 {
+			cmd.Env = append(os.Environ(), env...)
+		}
+		if err := cmd.Start(); err != nil {
+			return Result[CustomActionOutput]{Error: &AppError{Code: CustomActionError, Message: fmt.Sprintf("failed to run %s: %v", action.Name, err), InnerError: err}}
+		}
+		go cmd.Wait()
+		return Result[CustomActionOutput]{Data: &CustomActionOutput{Command: command}}
+	}
+
+	timeout := customActionTimeout
+	if action.Timeout > 0 {
+		timeout = time.Duration(action.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, command)
+	cmd.Dir = filepath.Dir(items[0].path)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	output := &limitedBuffer{limit: customActionMaxOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// Stop what the command started too, and don't wait for them to close the output
+	startInProcessGroup(cmd)
+	cmd.Cancel = func() error { return killProcessTree(cmd) }
+	cmd.WaitDelay = time.Second
+	err = cmd.Run()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return Result[CustomActionOutput]{Error: &AppError{Code: CustomActionError, Message: fmt.Sprintf("%s did not finish within %s and was stopped", action.Name, timeout), InnerError: ctx.Err()}}
+	}
+	result := CustomActionOutput{Command: command, Output: output.String(), Truncated: output.truncated}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		return Result[CustomActionOutput]{Error: &AppError{Code: CustomActionError, Message: fmt.Sprintf("failed to run %s: %v", action.Name, err), InnerError: err}}
+	}
+
+	if c.Dialogs != nil {
+		message := result.Output
+		if message == "" {
+			message = "(no output)"
+		}
+		if result.ExitCode != 0 {
+			c.Dialogs.ShowErrorDialog(fmt.Sprintf("%s failed (exit code %d)", action.Name, result.ExitCode), message)
+		} else {
+			c.Dialogs.ShowInfoDialog(action.Name, message)
+		}
+	}
+	return Result[CustomActionOutput]{Data: &result}
+}
+
+// customActionItem is a selected path with what the conditions need to know about it.
+type customActionItem struct {
+	path     string
+	isDir    bool
+	mimeType string
+}
+
+func newCustomActionItem(p string) (customActionItem, *AppError) {
+	pathResult := canonicalPath(p)
+	if pathResult.Error != nil {
+		return customActionItem{}, pathResult.Error
+	}
+	absPath := *pathResult.Data
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return customActionItem{}, &AppError{Code: FileInfoError, Message: fmt.Sprintf("cannot access %s: %v", absPath, err), InnerError: err}
+	}
+	return customActionItem{path: absPath, isDir: info.IsDir(), mimeType: detectMimeType(absPath)}, nil
+}
+
+// matches reports whether every item satisfies the action's conditions.
+func (a CustomAction) matches(items []customActionItem) bool {
+	if len(items) > 1 && !a.MultiSelect {
+		return false
+	}
+	for _, item := range items {
+		switch {
+		case a.AppliesTo == customActionFiles && item.isDir,
+			a.AppliesTo == customActionDirectories && !item.isDir:
+			return false
+		}
+		if len(a.Patterns) > 0 && !slices.ContainsFunc(a.Patterns, func(pattern string) bool {
+			ok, _ := filepath.Match(strings.ToLower(pattern), strings.ToLower(filepath.Base(item.path)))
+			return ok
+		}) {
+			return false
+		}
+		if len(a.MimeTypes) > 0 && !slices.ContainsFunc(a.MimeTypes, func(pattern string) bool {
+			ok, _ := path.Match(pattern, item.mimeType)
+			return ok
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// expandActionCommand replaces the placeholders of a custom action command.
+// It also returns the environment variables the command refers to (see shellArgument).
+func expandActionCommand(command string, items []customActionItem) (string, []string, error) {
+	var env []string
+	quote := func(value string) string { return shellArgument(value, &env) }
+	quoteAll := func(values func(customActionItem) string) string {
+		quoted := make([]string, len(items))
+		for i, item := range items {
+			quoted[i] = quote(values(item))
+		}
+		return strings.Join(quoted, " ")
+	}
+	itemPath := func(item customActionItem) string { return item.path }
+	itemDir := func(item customActionItem) string { return filepath.Dir(item.path) }
+	itemName := func(item customActionItem) string { return filepath.Base(item.path) }
+
+	var sb strings.Builder
+	for i := 0; i < len(command); i++ {
+		if command[i] != '%' || i+1 == len(command) {
+			sb.WriteByte(command[i])
+			continue
+		}
+		i++
+		switch command[i] {
+		case 'f':
+			sb.WriteString(quote(itemPath(items[0])))
+		case 'F':
+			sb.WriteString(quoteAll(itemPath))
+		case 'd':
+			sb.WriteString(quote(itemDir(items[0])))
+		case 'D':
+			sb.WriteString(quoteAll(itemDir))
+		case 'n':
+			sb.WriteString(quote(itemName(items[0])))
+		case 'N':
+			sb.WriteString(quoteAll(itemName))
+		case '%':
+			sb.WriteByte('%')
+		default:
+			return "", nil, fmt.Errorf("unknown placeholder %%%c", command[i])
+		}
+	}
+	return sb.String(), env, nil
+}
+
+// shellQuote quotes s as a single argument for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// validateCustomActions checks the customActions of the config file.
+func validateCustomActions(actions []CustomAction) []string {
+	var problems []string
+	seen := map[string]bool{}
+	for i, action := range actions {
+		where := fmt.Sprintf("customActions[%d]", i)
+		if action.ID == "" {
+			problems = append(problems, where+": id is required")
+		} else if seen[action.ID] {
+			problems = append(problems, fmt.Sprintf("%s: duplicate id %q", where, action.ID))
+		}
+		seen[action.ID] = true
+
+		if strings.TrimSpace(action.Name) == "" {
+			problems = append(problems, where+": name is required")
+		}
+		if strings.TrimSpace(action.Command) == "" {
+			problems = append(problems, where+": command is required")
+		} else if _, _, err := expandActionCommand(action.Command, []customActionItem{{path: "/"}}); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", where, err))
+		}
+		if action.Timeout < 0 {
+			problems = append(problems, fmt.Sprintf("%s: timeout must not be negative, got %d", where, action.Timeout))
+		}
+		if action.AppliesTo != "" && action.AppliesTo != customActionFiles && action.AppliesTo != customActionDirectories {
+			problems = append(problems, fmt.Sprintf("%s: appliesTo must be files, directories or empty, got %q", where, action.AppliesTo))
+		}
+		for _, pattern := range action.Patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid pattern %q", where, pattern))
+			}
+		}
+		for _, mimeType := range action.MimeTypes {
+			if _, err := path.Match(mimeType, ""); err != nil || !strings.Contains(mimeType, "/") {
+				problems = append(problems, fmt.Sprintf("%s: invalid MIME type %q", where, mimeType))
+			}
+		}
+	}
+	return problems
+}
+
+// limitedBuffer keeps the first limit bytes written to it and drops the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,341 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// desktopEntry is the [Desktop Entry] group of a .desktop file.
+// See https://specifications.freedesktop.org/desktop-entry-spec/latest/
+type desktopEntry struct {
+	ID          string // desktop file ID, e.g. "org.gnome.gedit.desktop"
+	Path        string // location of the .desktop file
+	Name        string
+	GenericName string
+	Comment     string
+	Icon        string
+	Exec        string
+	TryExec     string
+	WorkDir     string // the "Path" key
+	Terminal    bool
+	NoDisplay   bool
+	Hidden      bool
+	MimeTypes   []string
+}
+
+// parseDesktopEntry reads the [Desktop Entry] group of a .desktop file.
+// Localized keys (Name[fr]) are used when they match the user's locale.
+func parseDesktopEntry(path string, id string) (*desktopEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entry := &desktopEntry{ID: id, Path: path}
+	locales := userLocales()
+	// Rank of the locale each localized key was taken from, lower is better
+	localeRank := map[string]int{}
+
+	isApplication := false
+	inEntry := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			inEntry = line == "[Desktop Entry]"
+			continue
+		}
+		if !inEntry {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		// Localized key: Name[de_DE]=...
+		rank := len(locales)
+		if base, locale, found := strings.Cut(key, "["); found {
+			locale = strings.TrimSuffix(locale, "]")
+			rank = -1
+			for i, l := range locales {
+				if l == locale {
+					rank = i
+					break
+				}
+			}
+			if rank < 0 {
+				continue
+			}
+			key = base
+		}
+		if previous, seen := localeRank[key]; seen && previous <= rank {
+			continue
+		}
+		localeRank[key] = rank
+
+		switch key {
+		case "Type":
+			isApplication = value == "Application"
+		case "Name":
+			entry.Name = unescapeDesktopValue(value)
+		case "GenericName":
+			entry.GenericName = unescapeDesktopValue(value)
+		case "Comment":
+			entry.Comment = unescapeDesktopValue(value)
+		case "Icon":
+			entry.Icon = unescapeDesktopValue(value)
+		case "Exec":
+			entry.Exec = unescapeDesktopValue(value)
+		case "TryExec":
+			entry.TryExec = unescapeDesktopValue(value)
+		case "Path":
+			entry.WorkDir = unescapeDesktopValue(value)
+		case "Terminal":
+			entry.Terminal = value == "true"
+		case "NoDisplay":
+			entry.NoDisplay = value == "true"
+		case "Hidden":
+			entry.Hidden = value == "true"
+		case "MimeType":
+			for _, mimeType := range strings.Split(value, ";") {
+				if mimeType = strings.TrimSpace(mimeType); mimeType != "" {
+					entry.MimeTypes = append(entry.MimeTypes, mimeType)
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !isApplication {
+		return nil, fmt.Errorf("%s is not an application", path)
+	}
+	return entry, nil
+}
+
+// userLocales returns the locale variants to try for localized keys, best first,
+// e.g. LANG=fr_FR.UTF-8@euro gives fr_FR@euro, fr_FR, fr@euro, fr.
+func userLocales() []string {
+	lang := ""
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if lang = os.Getenv(env); lang != "" {
+			break
+		}
+	}
+	if lang == "" || lang == "C" || lang == "POSIX" {
+		return nil
+	}
+
+	lang, modifier, _ := strings.Cut(lang, "@")
+	lang, _, _ = strings.Cut(lang, ".")
+	language, country, _ := strings.Cut(lang, "_")
+
+	var locales []string
+	if country != "" && modifier != "" {
+		locales = append(locales, language+"_"+country+"@"+modifier)
+	}
+	if country != "" {
+		locales = append(locales, language+"_"+country)
+	}
+	if modifier != "" {
+		locales = append(locales, language+"@"+modifier)
+	}
+	return append(locales, language)
+}
+
+// unescapeDesktopValue handles the string escapes of the desktop entry format (\s \n \t \r \\).
+func unescapeDesktopValue(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			sb.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 's':
+			sb.WriteByte(' ')
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'r':
+			sb.WriteByte('\r')
+		case '\\':
+			sb.WriteByte('\\')
+		default:
+			// Not a string escape, keep it for the Exec quoting rules
+			sb.WriteByte('\\')
+			sb.WriteByte(value[i])
+		}
+	}
+	return sb.String()
+}
+
+// splitExec tokenizes an Exec value: arguments are separated by spaces and may be
+// double-quoted, inside quotes \" \` \$ and \\ are escaped.
+func splitExec(exec string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		inQuote bool
+		hasArg  bool
+	)
+	for i := 0; i < len(exec); i++ {
+		c := exec[i]
+		switch {
+		case inQuote && c == '\\' && i+1 < len(exec) && strings.IndexByte("\"`$\\", exec[i+1]) >= 0:
+			i++
+			current.WriteByte(exec[i])
+		case c == '"':
+			inQuote = !inQuote
+			hasArg = true
+		case !inQuote && (c == ' ' || c == '\t'):
+			if hasArg {
+				args = append(args, current.String())
+				current.Reset()
+				hasArg = false
+			}
+		default:
+			current.WriteByte(c)
+			hasArg = true
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote in Exec %q", exec)
+	}
+	if hasArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// expandExec turns the Exec line into one or more command lines for the given files.
+// %F and %U pass every file to a single process. With %f or %u only one file is
+// accepted, so one process is started per file. Without any file field code the
+// application is started once without files.
+func (e *desktopEntry) expandExec(paths []string) ([][]string, error) {
+	tokens, err := splitExec(e.Exec)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s has an empty Exec", e.ID)
+	}
+
+	single, multiple := false, false
+	for _, token := range tokens {
+		single = single || strings.Contains(token, "%f") || strings.Contains(token, "%u")
+		multiple = multiple || token == "%F" || token == "%U"
+	}
+
+	if single && !multiple && len(paths) > 1 {
+		var commands [][]string
+		for _, p := range paths {
+			command, err := e.expandTokens(tokens, []string{p})
+			if err != nil {
+				return nil, err
+			}
+			commands = append(commands, command)
+		}
+		return commands, nil
+	}
+
+	command, err := e.expandTokens(tokens, paths)
+	if err != nil {
+		return nil, err
+	}
+	return [][]string{command}, nil
+}
+
+func (e *desktopEntry) expandTokens(tokens []string, paths []string) ([]string, error) {
+	var args []string
+	for _, token := range tokens {
+		// List field codes must stand alone as an argument
+		switch token {
+		case "%F":
+			args = append(args, paths...)
+			continue
+		case "%U":
+			for _, p := range paths {
+				args = append(args, fileURI(p))
+			}
+			continue
+		case "%i":
+			if e.Icon != "" {
+				args = append(args, "--icon", e.Icon)
+			}
+			continue
+		}
+
+		var sb strings.Builder
+		for i := 0; i < len(token); i++ {
+			if token[i] != '%' || i+1 == len(token) {
+				sb.WriteByte(token[i])
+				continue
+			}
+			i++
+			switch token[i] {
+			case '%':
+				sb.WriteByte('%')
+			case 'f':
+				if len(paths) > 0 {
+					sb.WriteString(paths[0])
+				}
+			case 'u':
+				if len(paths) > 0 {
+					sb.WriteString(fileURI(paths[0]))
+				}
+			case 'c':
+				sb.WriteString(e.Name)
+			case 'k':
+				sb.WriteString(e.Path)
+			case 'd', 'D', 'n', 'N', 'v', 'm':
+				// Deprecated field codes are removed
+			default:
+				return nil, fmt.Errorf("invalid field code %%%c in Exec of %s", token[i], e.ID)
+			}
+		}
+		// An argument that was only a field code expanding to nothing is dropped
+		if sb.Len() > 0 || !strings.HasPrefix(token, "%") {
+			args = append(args, sb.String())
+		}
+	}
+	return args, nil
+}
+
+// fileURI converts a local path to a file:// URI, escaped exactly like GLib's
+// g_filename_to_uri: the names of shared thumbnails are MD5 sums of the URI.
+func fileURI(p string) string {
+	path := filepath.ToSlash(p)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path // C:/dir on Windows
+	}
+	var sb strings.Builder
+	sb.WriteString("file://")
+	for _, c := range []byte(path) {
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte(fileURIUnescaped, c) >= 0 {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// fileURIUnescaped are the characters besides letters and digits GLib leaves as they are in paths.
+const fileURIUnescaped = "-._~!$&'()*+,=:@/"
@@ -0,0 +1,23 @@
+package internal
+
+import "testing"
+
+func TestFileURI(t *testing.T) {
+	// Expected URIs are the output of GLib's g_filename_to_uri
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/home/u/photo (1)!'*.jpg", "file:///home/u/photo%20(1)!'*.jpg"},
+		{"/tmp/é ñ\x01\x7f", "file:///tmp/%C3%A9%20%C3%B1%01%7F"},
+		{
+			"/x/ !\"#$%&'()*+,-.0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefghijklmnopqrstuvwxyz{|}~",
+			"file:///x/%20!%22%23$%25&'()*+,-.0123456789:%3B%3C=%3E%3F@ABCDEFGHIJKLMNOPQRSTUVWXYZ%5B%5C%5D%5E_%60abcdefghijklmnopqrstuvwxyz%7B%7C%7D~",
+		},
+	}
+	for _, test := range tests {
+		if got := fileURI(test.path); got != test.want {
+			t.Errorf("fileURI(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// DuplicateService finds files with the same content under one or more roots.
+// Candidates are grouped by size, then by a hash of their first bytes, then by
+// a hash of the whole content; each group is streamed (EventDuplicateGroup) as
+// soon as it is confirmed, biggest files first.
+type DuplicateService struct {
+	App   *application.App    // used to stream results, may be nil
+	Files *FileManagerService // resolutions go through its file operations
+
+	mu    sync.Mutex
+	scans map[string]context.CancelFunc
+}
+
+// DuplicateGroup is emitted (EventDuplicateGroup) for every set of identical files.
+type DuplicateGroup struct {
+	ID    string   `json:"id"` // scan id
+	Size  int64    `json:"size"`
+	Hash  string   `json:"hash"` // SHA-256 of the content
+	Files []string `json:"files"`
+}
+
+// DuplicateProgress is emitted (EventDuplicateProgress) at most every duplicateProgressInterval.
+type DuplicateProgress struct {
+	ID           string `json:"id"`
+	Phase        string `json:"phase"` // "scan", "partial" or "full"
+	FilesScanned int    `json:"filesScanned"`
+	Candidates   int    `json:"candidates"` // files sharing their size with another
+	BytesHashed  int64  `json:"bytesHashed"`
+}
+
+// DuplicateScanDone is emitted (EventDuplicateDone) when a scan ends.
+type DuplicateScanDone struct {
+	ID        string    `json:"id"`
+	Groups    int       `json:"groups"`
+	Wasted    int64     `json:"wasted"` // bytes used by all copies but one of each group
+	Error     *AppError `json:"error,omitempty"`
+	Cancelled bool      `json:"cancelled,omitempty"`
+}
+
+type DuplicateResolution string
+
+const (
+	DuplicateTrash    DuplicateResolution = "trash"
+	DuplicateHardlink DuplicateResolution = "hardlink"
+
+	EventDuplicateProgress = "duplicates:progress"
+	EventDuplicateGroup    = "duplicates:group"
+	EventDuplicateDone     = "duplicates:done"
+
+	duplicateProgressInterval = 200 * time.Millisecond
+	// Bytes read from every candidate before hashing whole files.
+	duplicatePartialSize = 64 * 1024
+)
+
+type duplicateCandidate struct {
+	path string
+	info fs.FileInfo
+}
+
+// FindDuplicates starts a scan of roots for duplicate files of at least minSize
+// bytes (empty files are never reported) and returns its id.
+// CancelDuplicateScan stops it.
+func (d *DuplicateService) FindDuplicates(roots []string, minSize int64) Result[string] {
+	if len(roots) == 0 {
+		return Result[string]{Error: &AppError{Code: DuplicateError, Message: "no folders to scan"}}
+	}
+	var paths []string
+	for _, root := range roots {
+		rootResult := canonicalPath(root)
+		if rootResult.Error != nil {
+			return Result[string]{Error: rootResult.Error}
+		}
+		paths = append(paths, *rootResult.Data)
+	}
+	minSize = max(minSize, 1)
+
+	id := newUUID()
+	ctx, cancel := context.WithCancel(context.Background())
+	d.mu.Lock()
+	if d.scans == nil {
+		d.scans = map[string]context.CancelFunc{}
+	}
+	d.scans[id] = cancel
+	d.mu.Unlock()
+
+	go func() {
+		done := d.scan(ctx, id, paths, minSize)
+		done.ID = id
+		if ctx.Err() != nil && done.Error == nil {
+			done.Cancelled = true
+		}
+
+		d.mu.Lock()
+		delete(d.scans, id)
+		d.mu.Unlock()
+		cancel()
+
+		d.emit(EventDuplicateDone, done)
+	}()
+
+	return Result[string]{Data: &id}
+}
+
+// CancelDuplicateScan stops a running scan, the groups found so far stay valid.
+func (d *DuplicateService) CancelDuplicateScan(id string) Result[string] {
+	d.mu.Lock()
+	cancel, ok := d.scans[id]
+	d.mu.Unlock()
+	if !ok {
+		return Result[string]{Error: &AppError{Code: DuplicateError, Message: fmt.Sprintf("no running duplicate scan %s", id)}}
+	}
+	cancel()
+	return Result[string]{Data: ptrString("Duplicate scan cancelled")}
+}
+
+// DuplicateResolutionReport tells what happened to the copies given to ResolveDuplicates.
+// A failure on one copy does not stop the others: they are all reported.
+type DuplicateResolutionReport struct {
+	Resolved int                `json:"resolved"`
+	Skipped  int                `json:"skipped"` // changed since the scan, or already the kept file
+	Failed   []DuplicateFailure `json:"failed"`
+}
+
+type DuplicateFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// ResolveDuplicates keeps one file of a group and gets rid of the other copies,
+// either by moving them to the trash or by replacing them with hard links to
+// the kept file (which then share its permissions and owner).
+// Copies are compared byte for byte with the kept file first: one that changed
+// since the scan is left alone. Both resolutions go through the file manager:
+// TrashFiles, and the atomic replacement LinkFiles uses to overwrite.
+func (d *DuplicateService) ResolveDuplicates(keep string, duplicates []string, resolution DuplicateResolution) Result[DuplicateResolutionReport] {
+	if resolution != DuplicateTrash && resolution != DuplicateHardlink {
+		return Result[DuplicateResolutionReport]{Error: &AppError{Code: DuplicateError, Message: fmt.Sprintf("unknown resolution %q", resolution)}}
+	}
+	if resolution == DuplicateTrash && d.Files == nil {
+		return Result[DuplicateResolutionReport]{Error: &AppError{Code: DuplicateError, Message: "file operations are not available"}}
+	}
+
+	keepResult := canonicalPath(keep)
+	if keepResult.Error != nil {
+		return Result[DuplicateResolutionReport]{Error: keepResult.Error}
+	}
+	keepPath := *keepResult.Data
+	keepInfo, err := os.Stat(keepPath)
+	if err != nil || !keepInfo.Mode().IsRegular() {
+		return Result[DuplicateResolutionReport]{Error: &AppError{Code: DuplicateError, Message: fmt.Sprintf("cannot keep %s: not a regular file", keepPath), InnerError: err}}
+	}
+
+	report := DuplicateResolutionReport{Failed: []DuplicateFailure{}}
+	fail := func(path string, err error) {
+		report.Failed = append(report.Failed, DuplicateFailure{Path: path, Error: err.Error()})
+	}
+
+	for _, duplicate := range duplicates {
+		duplicateResult := canonicalPath(duplicate)
+		if duplicateResult.Error != nil {
+			fail(duplicate, duplicateResult.Error)
+			continue
+		}
+		duplicatePath := *duplicateResult.Data
+		info, err := os.Lstat(duplicatePath)
+		if err != nil || !info.Mode().IsRegular() || os.SameFile(info, keepInfo) {
+			report.Skipped++
+			continue
+		}
+		same, err := sameFileContent(keepPath, duplicatePath)
+		if err != nil || !same {
+			report.Skipped++
+			continue
+		}
+
+		if resolution == DuplicateTrash {
+			if trashResult := d.Files.TrashFiles([]string{duplicatePath}); trashResult.Error != nil {
+				fail(duplicatePath, trashResult.Error)
+				continue
+			}
+		} else {
+			err := replaceWith(duplicatePath, func(path string) error { return os.Link(keepPath, path) })
+			if err != nil {
+				fail(duplicatePath, err)
+				continue
+			}
+		}
+		report.Resolved++
+	}
+	return Result[DuplicateResolutionReport]{Data: &report}
+}
+
+// ServiceShutdown stops the running scans.
+func (d *DuplicateService) ServiceShutdown() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, cancel := range d.scans {
+		cancel()
+	}
+	return nil
+}
+
+func (d *DuplicateService) emit(name string, data any) {
+	if d.App != nil {
+		d.App.Event.Emit(name, data)
+	}
+}
+
+func (d *DuplicateService) scan(ctx context.Context, id string, roots []string, minSize int64) DuplicateScanDone {
+	progress := DuplicateProgress{ID: id, Phase: "scan"}
+	var lastEmit time.Time
+	report := func(force bool) {
+		if force || time.Since(lastEmit) >= duplicateProgressInterval {
+			lastEmit = time.Now()
+			d.emit(EventDuplicateProgress, progress)
+		}
+	}
+
+	// Group by size; overlapping roots must not make a file its own duplicate
+	bySize := map[int64][]duplicateCandidate{}
+	seen := map[string]bool{}
+	for _, root := range roots {
+		filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if ctx.Err() != nil {
+				return filepath.SkipAll
+			}
+			if err != nil || !entry.Type().IsRegular() || seen[path] {
+				return nil
+			}
+			seen[path] = true
+			info, err := entry.Info()
+			if err != nil || info.Size() < minSize {
+				return nil
+			}
+			progress.FilesScanned++
+			bySize[info.Size()] = append(bySize[info.Size()], duplicateCandidate{path: path, info: info})
+			report(false)
+			return nil
+		})
+	}
+	if ctx.Err() != nil {
+		return DuplicateScanDone{}
+	}
+
+	sizes := make([]int64, 0, len(bySize))
+	for size, candidates := range bySize {
+		// Hard links to one file are not duplicates
+		candidates = withoutSameFiles(candidates)
+		if len(candidates) < 2 {
+			delete(bySize, size)
+			continue
+		}
+		bySize[size] = candidates
+		sizes = append(sizes, size)
+		progress.Candidates += len(candidates)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] > sizes[j] })
+	report(true)
+
+	done := DuplicateScanDone{}
+	hash := func(path string, limit int64) string {
+		sum, n, err := hashFilePrefix(ctx, path, limit)
+		progress.BytesHashed += n
+		report(false)
+		if err != nil {
+			return ""
+		}
+		return sum
+	}
+
+	for _, size := range sizes {
+		paths := make([]string, len(bySize[size]))
+		for i, candidate := range bySize[size] {
+			paths[i] = candidate.path
+		}
+
+		progress.Phase = "partial"
+		for partialSum, partial := range groupByHash(paths, func(path string) string { return hash(path, duplicatePartialSize) }) {
+			if ctx.Err() != nil {
+				return done
+			}
+			// Small files were hashed whole already
+			groups := map[string][]string{partialSum: partial}
+			if size > duplicatePartialSize {
+				progress.Phase = "full"
+				groups = groupByHash(partial, func(path string) string { return hash(path, size) })
+			}
+			for sum, files := range groups {
+				if ctx.Err() != nil {
+					return done
+				}
+				sort.Strings(files)
+				done.Groups++
+				done.Wasted += size * int64(len(files)-1)
+				d.emit(EventDuplicateGroup, DuplicateGroup{ID: id, Size: size, Hash: sum, Files: files})
+			}
+		}
+	}
+	return done
+}
+
+// groupByHash splits paths by hash, dropping files that cannot be read (empty hash)
+// and groups of a single file.
+func groupByHash(paths []string, hash func(path string) string) map[string][]string {
+	groups := map[string][]string{}
+	for _, path := range paths {
+		if sum := hash(path); sum != "" {
+			groups[sum] = append(groups[sum], path)
+		}
+	}
+	for sum, files := range groups {
+		if len(files) < 2 {
+			delete(groups, sum)
+		}
+	}
+	return groups
+}
+
+// withoutSameFiles keeps one path of each set of hard links.
+func withoutSameFiles(candidates []duplicateCandidate) []duplicateCandidate {
+	unique := candidates[:0]
+	for _, candidate := range candidates {
+		linked := false
+		for _, kept := range unique {
+			if os.SameFile(kept.info, candidate.info) {
+				linked = true
+				break
+			}
+		}
+		if !linked {
+			unique = append(unique, candidate)
+		}
+	}
+	return unique
+}
+
+// hashFilePrefix returns the SHA-256 of the first limit bytes of path and the number of bytes read.
+func hashFilePrefix(ctx context.Context, path string, limit int64) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	buf := make([]byte, 1<<20)
+	var total int64
+	reader := io.LimitReader(file, limit)
+	for {
+		if ctx.Err() != nil {
+			return "", total, ctx.Err()
+		}
+		n, err := reader.Read(buf)
+		h.Write(buf[:n])
+		total += int64(n)
+		if err == io.EOF {
+			return hex.EncodeToString(h.Sum(nil)), total, nil
+		}
+		if err != nil {
+			return "", total, err
+		}
+	}
+}
+
+// sameFileContent compares two files byte for byte.
+func sameFileContent(a, b string) (bool, error) {
+	fileA, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fileA.Close()
+	fileB, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fileB.Close()
+
+	infoA, err := fileA.Stat()
+	if err != nil {
+		return false, err
+	}
+	infoB, err := fileB.Stat()
+	if err != nil {
+		return false, err
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+
+	bufA := make([]byte, 1<<20)
+	bufB := make([]byte, 1<<20)
+	for {
+		n, errA := io.ReadFull(fileA, bufA)
+		m, errB := io.ReadFull(fileB, bufB)
+		if n != m || !bytes.Equal(bufA[:n], bufB[:m]) {
+			return false, nil
+		}
+		endA := errors.Is(errA, io.EOF) || errors.Is(errA, io.ErrUnexpectedEOF)
+		endB := errors.Is(errB, io.EOF) || errors.Is(errB, io.ErrUnexpectedEOF)
+		if errA != nil && !endA {
+			return false, errA
+		}
+		if errB != nil && !endB {
+			return false, errB
+		}
+		if endA || endB {
+			return endA == endB, nil
+		}
+	}
+}
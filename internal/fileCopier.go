@@ -0,0 +1,294 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// CopyOptions are the per-operation options of copies.
+type CopyOptions struct {
+	Preserve PreserveOptions `json:"preserve"`
+	Verify   bool            `json:"verify"` // hash both files after copying, a mismatch fails the copy
+}
+
+// PreserveOptions tells which metadata copies keep besides the content.
+type PreserveOptions struct {
+	Timestamps bool `json:"timestamps"` // modification and access times
+	Mode       bool `json:"mode"`       // permissions, with the setuid/setgid/sticky bits
+	Ownership  bool `json:"ownership"`  // owner and group, only possible when running as root
+	Xattrs     bool `json:"xattrs"`     // extended attributes: user.xdg.tags, SELinux labels, ...
+	ACLs       bool `json:"acls"`       // POSIX ACLs
+}
+
+func defaultPreserveOptions() PreserveOptions {
+	return PreserveOptions{Timestamps: true, Mode: true, Ownership: true, Xattrs: true, ACLs: true}
+}
+
+// fileCopier copies files and directories with the metadata its options ask for.
+// Metadata that cannot be preserved does not fail the copy, warnings() reports it.
+//
+// Files are written under a temporary name next to their destination, synced
+// to disk and renamed: a crash never leaves a truncated file under the final name.
+type fileCopier struct {
+	preserve PreserveOptions
+	verify   bool
+
+	// For journaled operations: done is told about every completed file,
+	// skip about the ones a previous run completed already.
+	done func(dst string)
+	skip func(dst string) bool
+
+	problems map[string]*copyProblem // by kind of metadata and error
+	order    []string
+}
+
+// copyProblem is one kind of metadata that could not be preserved, on count items.
+type copyProblem struct {
+	what    string
+	err     error
+	example string
+	count   int
+}
+
+func newFileCopier(options CopyOptions) *fileCopier {
+	return &fileCopier{preserve: options.Preserve, verify: options.Verify, problems: map[string]*copyProblem{}}
+}
+
+func (c *fileCopier) options() CopyOptions {
+	return CopyOptions{Preserve: c.preserve, Verify: c.verify}
+}
+
+// copyItem copies a file or a directory.
+func (c *fileCopier) copyItem(src, dst string, info fs.FileInfo) error {
+	if info.IsDir() {
+		return c.copyDir(src, dst)
+	}
+	return c.copyFile(src, dst)
+}
+
+// copyFile copies a single file
+func (c *fileCopier) copyFile(src, dst string) error {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	info, err := sourceFile.Stat()
+	if err != nil {
+		return err
+	}
+	if c.skip != nil && c.skip(dst) {
+		return nil
+	}
+
+	// The default permissions apply (O_CREATE with 0666 and the umask) when the mode is not preserved
+	temp := partialCopyPath(dst)
+	destFile, err := os.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	err = copyFileData(destFile, sourceFile, info.Size())
+	if err == nil {
+		err = destFile.Sync()
+	}
+	if closeErr := destFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && c.verify {
+		err = verifyCopy(src, temp)
+	}
+	if err != nil {
+		// Cleanup partial file
+		os.Remove(temp)
+		return err
+	}
+
+	c.preserveMetadata(src, info, temp)
+	if err := os.Rename(temp, dst); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	syncDir(filepath.Dir(dst))
+	if c.done != nil {
+		c.done(dst)
+	}
+	return nil
+}
+
+// partialCopyPath returns a free hidden name next to dst for the copy in progress.
+// Its length does not depend on dst's name, which may already be as long as the
+// file system allows: it is made of a hash of the name, to find the leftovers of
+// copies to dst, and a random id.
+func partialCopyPath(dst string) string {
+	name := partialCopyPrefix + partialCopyTag(filepath.Base(dst)) + "-" + newUUID()[:8] + partialCopySuffix
+	return filepath.Join(filepath.Dir(dst), name)
+}
+
+func partialCopyTag(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:4])
+}
+
+const (
+	partialCopyPrefix = ".lazydir-"
+	partialCopySuffix = ".part"
+)
+
+// verifyCopy compares the SHA-256 of a file and its copy.
+func verifyCopy(src, dst string) error {
+	srcSum, _, err := hashFilePrefix(context.Background(), src, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	dstSum, _, err := hashFilePrefix(context.Background(), dst, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	if srcSum != dstSum {
+		return fmt.Errorf("verification failed: the copy of %s has SHA-256 %s instead of %s", src, dstSum, srcSum)
+	}
+	return nil
+}
+
+// syncDir flushes a directory entry change (a rename) to disk, where directories can be synced.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// copyDir recursively copies a directory
+// WARNING: THIS doesnt handle symlinks or special files
+// to debug: node_modules from a pnpm project is a good test case
+func (c *fileCopier) copyDir(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("source %s is not a directory", src)
+	}
+
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	// Writable until its content is copied, the permissions come with the other metadata
+	if err := os.MkdirAll(dst, 0o777); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		srcPath := filepath.Join(src, entry.Name())
+		dstPath := filepath.Join(dst, entry.Name())
+
+		if entry.IsDir() {
+			if err := c.copyDir(srcPath, dstPath); err != nil {
+				return err
+			}
+		} else {
+			if err := c.copyFile(srcPath, dstPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Last, adding the entries changed the modification time
+	c.preserveMetadata(src, info, dst)
+	return nil
+}
+
+// preserveMetadata applies the metadata of src (whose info is known) to dst.
+// Ownership goes first since chown clears the setuid/setgid bits, times last.
+func (c *fileCopier) preserveMetadata(src string, info fs.FileInfo, dst string) {
+	if c.preserve.Ownership && os.Geteuid() == 0 {
+		if uid, gid, ok := ownerOf(info); ok {
+			if err := os.Lchown(dst, uid, gid); err != nil {
+				c.warn("the owner", dst, err)
+			}
+		}
+	}
+
+	if c.preserve.Xattrs || c.preserve.ACLs {
+		names, err := listXattrs(src)
+		if err != nil {
+			c.warn("the extended attributes", dst, err)
+		}
+		for _, name := range names {
+			what := "the extended attribute " + name
+			if slices.Contains(aclXattrs, name) {
+				if !c.preserve.ACLs {
+					continue
+				}
+				what = "the ACLs"
+			} else if !c.preserve.Xattrs {
+				continue
+			}
+
+			value, err := getXattr(src, name)
+			if err == nil {
+				err = setXattr(dst, name, value)
+			}
+			if err != nil {
+				c.warn(what, dst, err)
+			}
+		}
+	}
+
+	if c.preserve.Mode {
+		if err := os.Chmod(dst, fileModeFromUnix(unixModeBits(info.Mode()))); err != nil {
+			c.warn("the permissions", dst, err)
+		}
+	}
+
+	if c.preserve.Timestamps {
+		if err := os.Chtimes(dst, accessTime(info), info.ModTime()); err != nil {
+			c.warn("the timestamps", dst, err)
+		}
+	}
+}
+
+// warn records metadata that could not be preserved. Problems are grouped by
+// kind and error, so copying a tree to a file system without support for
+// something makes one warning, not one per file.
+func (c *fileCopier) warn(what string, path string, err error) {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	key := what + "\x00" + err.Error()
+	if problem, ok := c.problems[key]; ok {
+		problem.count++
+		return
+	}
+	c.problems[key] = &copyProblem{what: what, err: err, example: path, count: 1}
+	c.order = append(c.order, key)
+}
+
+// warnings describes the metadata that could not be preserved.
+func (c *fileCopier) warnings() []string {
+	warnings := make([]string, 0, len(c.order))
+	for _, key := range c.order {
+		problem := c.problems[key]
+		if problem.count == 1 {
+			warnings = append(warnings, fmt.Sprintf("could not preserve %s of %s: %v", problem.what, problem.example, problem.err))
+		} else {
+			warnings = append(warnings, fmt.Sprintf("could not preserve %s of %d items (e.g. %s): %v", problem.what, problem.count, problem.example, problem.err))
+		}
+	}
+	return warnings
+}
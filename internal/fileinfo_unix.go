@@ -0,0 +1,24 @@
+//go:build !windows
+
+package internal
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// deviceOf returns the device holding a file, 0 if unknown.
+func deviceOf(info fs.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Dev)
+	}
+	return 0
+}
+
+// ownerOf returns the user and group owning a file.
+func ownerOf(info fs.FileInfo) (uid int, gid int, ok bool) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid), true
+	}
+	return 0, 0, false
+}
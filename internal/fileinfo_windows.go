@@ -0,0 +1,16 @@
+//go:build windows
+
+package internal
+
+import "io/fs"
+
+// deviceOf returns the device holding a file, 0 if unknown: FileInfo does not
+// carry the volume on Windows.
+func deviceOf(info fs.FileInfo) uint64 {
+	return 0
+}
+
+// ownerOf is not available on Windows, files are owned through ACLs.
+func ownerOf(info fs.FileInfo) (uid int, gid int, ok bool) {
+	return 0, 0, false
+}
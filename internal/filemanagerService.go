@@ -1,13 +1,18 @@
 package internal
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/adrg/xdg"
@@ -15,6 +20,13 @@ import (
 
 // FileManagerService is a service for managing files
 type FileManagerService struct {
+	Bookmarks *BookmarkService  // optional, user bookmarks are appended to the sidebar shortcuts
+	History   *HistoryService   // optional, records every listed directory for frecency ranking
+	Config    *ConfigService    // optional, provides the default conflict policy
+	Git       *GitStatusService // optional, decorates listings inside git repositories
+
+	mu      sync.Mutex
+	running map[string]bool // ids of the journaled operations in progress
 }
 
 // ListDirectory lists the contents of a directory.
@@ -37,6 +49,9 @@ func (f *FileManagerService) ListDirectory(dirPath string) Result[DirectoryConte
 		fileCount       int
 		directSizeBytes int64 // important for big files
 	)
+	// Reading file contents is only affordable for reasonably sized directories
+	sniff := len(entries) <= mimeSniffMaxEntries
+	mediaColumns := f.Config.current().MediaColumns
 
 	for _, entry := range entries {
 		info, err := entry.Info()
@@ -48,15 +63,11 @@ func (f *FileManagerService) ListDirectory(dirPath string) Result[DirectoryConte
 		path := filepath.Join(absPath, entry.Name())
 		path = filepath.Clean(path)
 
-		files = append(files, FileInfo{
-			Name:      entry.Name(),
-			Path:      path,
-			Size:      info.Size(),
-			IsDir:     entry.IsDir(),
-			Mode:      info.Mode().String(),
-			Modified:  info.ModTime(),
-			Extension: filepath.Ext(entry.Name()),
-		})
+		fileInfo := newFileInfo(path, info, sniff)
+		if mediaColumns && sniff && info.Mode().IsRegular() && hasMediaMetadata(fileInfo.MimeType) {
+			fileInfo.Metadata = mediaMetadataOf(path, info, fileInfo.MimeType)
+		}
+		files = append(files, fileInfo)
 
 		if entry.IsDir() {
 			dirCount++
@@ -66,6 +77,17 @@ func (f *FileManagerService) ListDirectory(dirPath string) Result[DirectoryConte
 		}
 	}
 
+	var gitInfo *GitRepoInfo
+	if f.Git != nil && f.Config.current().GitStatus {
+		gitInfo = f.Git.annotate(absPath, files)
+	}
+
+	if f.History != nil {
+		if historyResult := f.History.RecordVisit(absPath); historyResult.Error != nil {
+			Log(fmt.Sprintf("ListDirectory: %v", historyResult.Error))
+		}
+	}
+
 	return Result[DirectoryContents]{
 		Data: &DirectoryContents{
 			Path:            absPath,
@@ -73,10 +95,69 @@ func (f *FileManagerService) ListDirectory(dirPath string) Result[DirectoryConte
 			DirCount:        dirCount,
 			FileCount:       fileCount,
 			DirectSizeBytes: directSizeBytes,
+			Git:             gitInfo,
 		},
 	}
 }
 
+// GetProperties returns the details shown in the properties dialog, media metadata included.
+func (f *FileManagerService) GetProperties(filePath string) Result[FileProperties] {
+	pathResult := canonicalPath(filePath)
+	if pathResult.Error != nil {
+		return Result[FileProperties]{Error: pathResult.Error}
+	}
+	absPath := *pathResult.Data
+
+	info, err := os.Lstat(absPath)
+	if err != nil {
+		return Result[FileProperties]{Error: &AppError{Code: FileInfoError, Message: fmt.Sprintf("get info for %q: %v", absPath, err), InnerError: err}}
+	}
+	properties := FileProperties{File: newFileInfo(absPath, info, true)}
+	if info.Mode()&os.ModeSymlink != 0 {
+		properties.LinkTarget, _ = os.Readlink(absPath)
+		if target, err := os.Stat(absPath); err == nil {
+			info = target
+		}
+	}
+	if info.Mode().IsRegular() {
+		properties.File.Metadata = mediaMetadataOf(absPath, info, properties.File.MimeType)
+	}
+	return Result[FileProperties]{Data: &properties}
+}
+
+// GetMediaMetadata returns the media metadata of each path, in order (nil if there is none).
+// Used for the visible rows of directories too large to get it in ListDirectory.
+func (f *FileManagerService) GetMediaMetadata(paths []string) Result[[]*MediaMetadata] {
+	metadata := make([]*MediaMetadata, 0, len(paths))
+	for _, p := range paths {
+		pathResult := canonicalPath(p)
+		if pathResult.Error != nil {
+			return Result[[]*MediaMetadata]{Error: pathResult.Error}
+		}
+		var item *MediaMetadata
+		if info, err := os.Stat(*pathResult.Data); err == nil && info.Mode().IsRegular() {
+			item = mediaMetadataOf(*pathResult.Data, info, detectMimeType(*pathResult.Data))
+		}
+		metadata = append(metadata, item)
+	}
+	return Result[[]*MediaMetadata]{Data: &metadata}
+}
+
+// newFileInfo describes path from its Lstat info. Without sniff, the MIME type
+// only comes from the name unless cached.
+func newFileInfo(path string, info fs.FileInfo, sniff bool) FileInfo {
+	return FileInfo{
+		Name:      info.Name(),
+		Path:      path,
+		Size:      info.Size(),
+		IsDir:     info.IsDir(),
+		Mode:      info.Mode().String(),
+		Modified:  info.ModTime(),
+		Extension: filepath.Ext(info.Name()),
+		MimeType:  mimeTypeOf(path, info, sniff),
+	}
+}
+
 func canonicalPath(p string) Result[string] {
 	if p == "" {
 		return Result[string]{Error: &AppError{Code: ResolvePathError, Message: "empty path"}}
@@ -252,9 +333,10 @@ func (f *FileManagerService) GetShortcuts() Result[[]Shortcut] {
 	// Prepare shortcuts with best-effort cross-platform paths
 	shortcuts := []Shortcut{
 		{
-			Name: "Home",
-			Path: home,
-			Logo: ShortcutLogoHome,
+			Name:   "Home",
+			Path:   home,
+			Logo:   ShortcutLogoHome,
+			Source: ShortcutSourcePlace,
 		},
 	}
 
@@ -266,9 +348,10 @@ func (f *FileManagerService) GetShortcuts() Result[[]Shortcut] {
 		// Optional: check existence
 		if _, err := os.Stat(path); err == nil {
 			shortcuts = append(shortcuts, Shortcut{
-				Name: name,
-				Path: path,
-				Logo: logo,
+				Name:   name,
+				Path:   path,
+				Logo:   logo,
+				Source: ShortcutSourcePlace,
 			})
 		}
 	}
@@ -281,6 +364,16 @@ func (f *FileManagerService) GetShortcuts() Result[[]Shortcut] {
 	addIfExists("Pictures", xdg.UserDirs.Pictures, ShortcutLogoPics)
 	addIfExists("Videos", xdg.UserDirs.Videos, ShortcutLogoVideos)
 
+	// User bookmarks come after the places, a broken bookmarks file shouldn't hide the sidebar
+	if f.Bookmarks != nil {
+		bookmarksResult := f.Bookmarks.GetBookmarks()
+		if bookmarksResult.Error != nil {
+			Log(fmt.Sprintf("GetShortcuts: %v", bookmarksResult.Error))
+		} else {
+			shortcuts = append(shortcuts, *bookmarksResult.Data...)
+		}
+	}
+
 	return Result[[]Shortcut]{Data: &shortcuts}
 }
 
@@ -291,6 +384,23 @@ func (f *FileManagerService) PasteFiles(targetDir string, files []string, cutMod
 	return f.CopyFiles(targetDir, files)
 }
 
+// PasteFilesWithOptions pastes like PasteFiles, with the metadata to preserve
+// and the verification chosen for this operation instead of taken from the settings.
+// Metadata that could not be preserved is reported in the warnings.
+func (f *FileManagerService) PasteFilesWithOptions(targetDir string, files []string, cutMode bool, options CopyOptions) Result[FileOperationReport] {
+	copier := newFileCopier(options)
+	var result Result[string]
+	if cutMode {
+		result = f.moveFiles(targetDir, files, copier)
+	} else {
+		result = f.copyFiles(targetDir, files, copier)
+	}
+	if result.Error != nil {
+		return Result[FileOperationReport]{Error: result.Error}
+	}
+	return Result[FileOperationReport]{Data: &FileOperationReport{Message: *result.Data, Warnings: copier.warnings()}}
+}
+
 /*
 *
 
@@ -300,65 +410,414 @@ func (f *FileManagerService) PasteFiles(targetDir string, files []string, cutMod
 *
 */
 func (f *FileManagerService) CopyFiles(targetDir string, files []string) Result[string] {
+	copier := newFileCopier(f.Config.current().copyOptions())
+	return withCopyWarnings(f.copyFiles(targetDir, files, copier), copier)
+}
+
+func (f *FileManagerService) copyFiles(targetDir string, files []string, copier *fileCopier) Result[string] {
 	targetResult := canonicalPath(targetDir)
 	if targetResult.Error != nil {
 		return Result[string]{Error: targetResult.Error}
 	}
-	target := *targetResult.Data
+	journal := newOperationJournal(OperationCopy, *targetResult.Data, files, copier.options(), f.Config.current().ConflictPolicy)
+	f.setRunning(journal.op.ID, true)
+	defer f.setRunning(journal.op.ID, false)
+	return f.runOperation(journal, copier)
+}
 
-	for _, source := range files {
-		sourceResult := canonicalPath(source)
+// copyOperationItem copies an item of a journaled copy, from where an interrupted run left it.
+func (f *FileManagerService) copyOperationItem(journal *operationJournal, i int, copier *fileCopier) *AppError {
+	item := journal.op.Items[i]
+	if item.State == ItemDone || item.State == ItemSkipped {
+		return nil
+	}
+	sourcePath, dest := item.Source, item.Dest
+	if item.State == ItemPending {
+		sourceResult := canonicalPath(item.Source)
 		if sourceResult.Error != nil {
-			return Result[string]{Error: sourceResult.Error}
+			return sourceResult.Error
+		}
+		sourcePath = *sourceResult.Data
+		dest = filepath.Join(journal.op.Target, filepath.Base(sourcePath))
+	}
+
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return &AppError{
+			Code:       FileCopyError,
+			Message:    fmt.Sprintf("cannot access %s: %v", sourcePath, err),
+			InnerError: err,
+		}
+	}
+
+	if item.State == ItemPending {
+		// Check if the dest already exists, the configured policy decides what to do
+		var skip bool
+		var appErr *AppError
+		dest, skip, appErr = resolveConflict(sourcePath, dest, info.IsDir(), journal.op.Policy, FileCopyError)
+		if appErr != nil {
+			return appErr
+		}
+		if skip {
+			journal.update(i, sourcePath, dest, ItemSkipped)
+			return nil
+		}
+		journal.op.Items[i].Replaces = exists(dest)
+		journal.update(i, sourcePath, dest, ItemCopying)
+	} else {
+		removePartialCopies(dest)
+	}
+
+	if info.IsDir() {
+		if err := copier.copyDir(sourcePath, dest); err != nil {
+			return &AppError{
+				Code:       FileCopyError,
+				Message:    fmt.Sprintf("failed to copy directory %s: \n%v", info.Name(), err),
+				InnerError: err,
+			}
+		}
+	} else {
+		if err := copier.copyFile(sourcePath, dest); err != nil {
+			return &AppError{
+				Code:       FileCopyError,
+				Message:    fmt.Sprintf("failed to copy file %s: \n%v", info.Name(), err),
+				InnerError: err,
+			}
+		}
+	}
+
+	journal.update(i, sourcePath, dest, ItemDone)
+	return nil
+}
+
+// MoveFiles renames files into targetDir, or copies and removes them across devices.
+func (f *FileManagerService) MoveFiles(targetDir string, files []string) Result[string] {
+	copier := newFileCopier(f.Config.current().copyOptions())
+	return withCopyWarnings(f.moveFiles(targetDir, files, copier), copier)
+}
+
+func (f *FileManagerService) moveFiles(targetDir string, files []string, copier *fileCopier) Result[string] {
+	targetResult := canonicalPath(targetDir)
+	if targetResult.Error != nil {
+		return Result[string]{Error: targetResult.Error}
+	}
+	journal := newOperationJournal(OperationMove, *targetResult.Data, files, copier.options(), f.Config.current().ConflictPolicy)
+	f.setRunning(journal.op.ID, true)
+	defer f.setRunning(journal.op.ID, false)
+	return f.runOperation(journal, copier)
+}
+
+// moveOperationItem moves an item of a journaled move, from where an interrupted run left it.
+func (f *FileManagerService) moveOperationItem(journal *operationJournal, i int, copier *fileCopier) *AppError {
+	item := journal.op.Items[i]
+	if item.State == ItemDone || item.State == ItemSkipped {
+		return nil
+	}
+	sourcePath, dest := item.Source, item.Dest
+
+	switch item.State {
+	case ItemPending:
+		sourceResult := canonicalPath(item.Source)
+		if sourceResult.Error != nil {
+			return sourceResult.Error
+		}
+		sourcePath = *sourceResult.Data
+		dest = filepath.Join(journal.op.Target, filepath.Base(sourcePath))
+
+		// Moving an item onto itself is a no-op
+		if dest == sourcePath {
+			journal.update(i, sourcePath, dest, ItemSkipped)
+			return nil
 		}
-		sourcePath := *sourceResult.Data
-		dest := filepath.Join(target, filepath.Base(sourcePath))
 
 		info, err := os.Stat(sourcePath)
 		if err != nil {
-			return Result[string]{Error: &AppError{
-				Code:       FileCopyError,
+			return &AppError{
+				Code:       FileMoveError,
 				Message:    fmt.Sprintf("cannot access %s: %v", sourcePath, err),
 				InnerError: err,
-			}}
+			}
 		}
 
-		// Check if the dest already exists (for now, don't overwrite, maybe we could create a "Copy of" file instead)
-		if _, err := os.Stat(dest); err == nil {
-			return Result[string]{Error: &AppError{
-				Code:    FileCopyError,
-				Message: fmt.Sprintf("destination %s already exists", dest),
-			}}
+		var skip bool
+		var appErr *AppError
+		dest, skip, appErr = resolveConflict(sourcePath, dest, info.IsDir(), journal.op.Policy, FileMoveError)
+		if appErr != nil {
+			return appErr
+		}
+		if skip {
+			journal.update(i, sourcePath, dest, ItemSkipped)
+			return nil
+		}
+		journal.op.Items[i].Replaces = exists(dest)
+		journal.update(i, sourcePath, dest, ItemCopying)
+
+	case ItemCopying:
+		// Renamed before the journal could record it
+		if _, err := os.Lstat(sourcePath); errors.Is(err, fs.ErrNotExist) {
+			if _, err := os.Lstat(dest); err == nil {
+				journal.update(i, sourcePath, dest, ItemDone)
+				return nil
+			}
+		}
+		removePartialCopies(dest)
+	}
+
+	if item.State != ItemRemoving {
+		if err := os.Rename(sourcePath, dest); err == nil {
+			journal.update(i, sourcePath, dest, ItemDone)
+			return nil
+		}
+
+		// Cross-device fallback: copy + remove
+		info, statErr := os.Stat(sourcePath)
+		if statErr != nil {
+			return &AppError{
+				Code:       FileMoveError,
+				Message:    fmt.Sprintf("cannot access %s: %v", sourcePath, statErr),
+				InnerError: statErr,
+			}
 		}
 
 		if info.IsDir() {
-			if err := copyDir(sourcePath, dest); err != nil {
-				return Result[string]{Error: &AppError{
-					Code:       FileCopyError,
-					Message:    fmt.Sprintf("failed to copy directory %s: \n%v", info.Name(), err),
+			if err := copier.copyDir(sourcePath, dest); err != nil {
+				return &AppError{
+					Code:       FileMoveError,
+					Message:    fmt.Sprintf("failed to move directory %s: %v", sourcePath, err),
 					InnerError: err,
-				}}
+				}
 			}
 		} else {
-			if err := copyFile(sourcePath, dest); err != nil {
-				return Result[string]{Error: &AppError{
-					Code:       FileCopyError,
-					Message:    fmt.Sprintf("failed to copy file %s: \n%v", info.Name(), err),
+			if err := copier.copyFile(sourcePath, dest); err != nil {
+				return &AppError{
+					Code:       FileMoveError,
+					Message:    fmt.Sprintf("failed to move file %s: %v", sourcePath, err),
 					InnerError: err,
-				}}
+				}
 			}
 		}
+		journal.update(i, sourcePath, dest, ItemRemoving)
+		// A rollback must know the copy is complete once the source starts to go
+		journal.sync()
 	}
 
-	return Result[string]{Data: ptrString(fmt.Sprintf("Copied %d item(s) to %s", len(files), target))}
+	if err := os.RemoveAll(sourcePath); err != nil {
+		return &AppError{
+			Code:       FileCleanupError,
+			Message:    fmt.Sprintf("failed to remove original %s after move: %v", sourcePath, err),
+			InnerError: err,
+		}
+	}
+	journal.update(i, sourcePath, dest, ItemDone)
+	return nil
 }
 
-func (f *FileManagerService) MoveFiles(targetDir string, files []string) Result[string] {
+// runOperation performs the items of a journaled copy or move that are not done yet.
+// The journal is removed once all are, and kept when an item fails.
+func (f *FileManagerService) runOperation(journal *operationJournal, copier *fileCopier) (result Result[string]) {
+	if journal.op.Error != "" {
+		journal.setError("")
+	}
+	defer func() { journal.finish(result.Error) }()
+	copier.done = journal.fileCopied
+	copier.skip = journal.wasCopied
+
+	for i := range journal.op.Items {
+		var appErr *AppError
+		if journal.op.Kind == OperationMove {
+			appErr = f.moveOperationItem(journal, i, copier)
+		} else {
+			appErr = f.copyOperationItem(journal, i, copier)
+		}
+		if appErr != nil {
+			return Result[string]{Error: appErr}
+		}
+	}
+
+	skipped := 0
+	for _, item := range journal.op.Items {
+		if item.State == ItemSkipped {
+			skipped++
+		}
+	}
+	verb := "Copied"
+	if journal.op.Kind == OperationMove {
+		verb = "Moved"
+	}
+	return Result[string]{Data: ptrString(fmt.Sprintf("%s %d item(s) to %s%s", verb, len(journal.op.Items)-skipped, journal.op.Target, skippedSuffix(skipped)))}
+}
+
+// ListIncompleteOperations returns the copies and moves that were interrupted
+// (crash, shutdown, error) and can be resumed or rolled back, oldest first.
+// The frontend asks on startup.
+func (f *FileManagerService) ListIncompleteOperations() Result[[]Operation] {
+	operations, err := loadOperations()
+	if err != nil {
+		return Result[[]Operation]{Error: &AppError{Code: OperationJournalError, Message: "failed to read the operation journal", InnerError: err}}
+	}
+
+	f.mu.Lock()
+	operations = slices.DeleteFunc(operations, func(op Operation) bool { return f.running[op.ID] })
+	f.mu.Unlock()
+	return Result[[]Operation]{Data: &operations}
+}
+
+// ResumeOperation completes an incomplete operation. Files the journal records
+// as completed (and verified, when asked) by the interrupted run are kept,
+// pending items go through the conflict policy the operation started with.
+func (f *FileManagerService) ResumeOperation(id string) Result[FileOperationReport] {
+	journal, appErr := f.claimOperation(id)
+	if appErr != nil {
+		return Result[FileOperationReport]{Error: appErr}
+	}
+	defer f.setRunning(id, false)
+
+	copier := newFileCopier(journal.op.Options)
+	result := f.runOperation(journal, copier)
+	if result.Error != nil {
+		return Result[FileOperationReport]{Error: result.Error}
+	}
+	return Result[FileOperationReport]{Data: &FileOperationReport{Message: *result.Data, Warnings: copier.warnings()}}
+}
+
+// RollbackOperation undoes an incomplete operation: copies are removed and moved
+// items go back to their source. Items an overwrite replaced cannot be restored.
+func (f *FileManagerService) RollbackOperation(id string) Result[string] {
+	journal, appErr := f.claimOperation(id)
+	if appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+	defer f.setRunning(id, false)
+	defer journal.close()
+
+	copier := newFileCopier(journal.op.Options)
+	// Files still at the source of a move are the originals: it removes them only once copied
+	copier.skip = exists
+	rolledBack := 0
+	for i := len(journal.op.Items) - 1; i >= 0; i-- {
+		item := journal.op.Items[i]
+		if item.State == ItemPending || item.State == ItemSkipped {
+			continue
+		}
+
+		var err error
+		if journal.op.Kind == OperationMove {
+			err = restoreMovedItem(item, copier)
+		} else {
+			removePartialCopies(item.Dest)
+			if !item.Replaces || item.State != ItemCopying {
+				err = os.RemoveAll(item.Dest)
+			}
+		}
+		if err != nil {
+			appErr := &AppError{
+				Code:       OperationJournalError,
+				Message:    fmt.Sprintf("failed to roll back %s: %v", item.Source, err),
+				InnerError: err,
+			}
+			journal.setError(appErr.Error())
+			return Result[string]{Error: appErr}
+		}
+		// Rolled back items start over if the operation is resumed after all
+		journal.op.Items[i].Replaces = false
+		journal.update(i, item.Source, "", ItemPending)
+		rolledBack++
+	}
+
+	journal.discard()
+	return withCopyWarnings(Result[string]{Data: ptrString(fmt.Sprintf("Rolled back %d item(s)", rolledBack))}, copier)
+}
+
+// restoreMovedItem puts an item of a move back at its source.
+func restoreMovedItem(item OperationItem, copier *fileCopier) error {
+	_, sourceErr := os.Lstat(item.Source)
+	if sourceErr == nil && item.State == ItemCopying {
+		// Not renamed and the source is complete, only the copy has to go.
+		// A file it replaces is either still there or already lost.
+		removePartialCopies(item.Dest)
+		if item.Replaces {
+			return nil
+		}
+		return os.RemoveAll(item.Dest)
+	}
+
+	info, err := os.Lstat(item.Dest)
+	if errors.Is(err, fs.ErrNotExist) && sourceErr == nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if sourceErr != nil {
+		if err := os.Rename(item.Dest, item.Source); err == nil {
+			return nil
+		}
+	}
+
+	// Part of the source was removed already, or it is on another device: copy back what is missing
+	if err := copier.copyItem(item.Dest, item.Source, info); err != nil {
+		return err
+	}
+	return os.RemoveAll(item.Dest)
+}
+
+// claimOperation loads the journal of an incomplete operation and marks it running.
+func (f *FileManagerService) claimOperation(id string) (*operationJournal, *AppError) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.running[id] {
+		return nil, &AppError{Code: OperationJournalError, Message: fmt.Sprintf("operation %s is still running", id)}
+	}
+
+	journal, err := loadOperationJournal(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, &AppError{Code: OperationNotFoundError, Message: fmt.Sprintf("no incomplete operation %s", id), InnerError: err}
+	}
+	if err != nil {
+		return nil, &AppError{Code: OperationJournalError, Message: fmt.Sprintf("failed to read the journal of operation %s", id), InnerError: err}
+	}
+
+	if f.running == nil {
+		f.running = map[string]bool{}
+	}
+	f.running[id] = true
+	return journal, nil
+}
+
+func (f *FileManagerService) setRunning(id string, running bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.running == nil {
+		f.running = map[string]bool{}
+	}
+	if running {
+		f.running[id] = true
+	} else {
+		delete(f.running, id)
+	}
+}
+
+// LinkFiles creates links to files in targetDir ("Create link here" on paste),
+// with the same conflict handling as CopyFiles.
+func (f *FileManagerService) LinkFiles(targetDir string, files []string, kind LinkKind) Result[string] {
+	if kind != LinkSymbolic && kind != LinkSymbolicRelative && kind != LinkHard {
+		return Result[string]{Error: &AppError{Code: FileLinkError, Message: fmt.Sprintf("unknown link kind %q", kind)}}
+	}
 	targetResult := canonicalPath(targetDir)
 	if targetResult.Error != nil {
 		return Result[string]{Error: targetResult.Error}
 	}
 	target := *targetResult.Data
+	targetInfo, err := os.Stat(target)
+	if err != nil {
+		return Result[string]{Error: &AppError{
+			Code:       FileLinkError,
+			Message:    fmt.Sprintf("cannot access %s: %v", target, err),
+			InnerError: err,
+		}}
+	}
+	policy := f.Config.current().ConflictPolicy
+	skipped := 0
 
 	for _, source := range files {
 		sourceResult := canonicalPath(source)
@@ -368,46 +827,65 @@ func (f *FileManagerService) MoveFiles(targetDir string, files []string) Result[
 		sourcePath := *sourceResult.Data
 		dest := filepath.Join(target, filepath.Base(sourcePath))
 
-		if err := os.Rename(sourcePath, dest); err != nil {
-			// Cross-device fallback: copy + remove
-			info, statErr := os.Stat(sourcePath)
-			if statErr != nil {
-				return Result[string]{Error: &AppError{
-					Code:       FileMoveError,
-					Message:    fmt.Sprintf("cannot access %s: %v", sourcePath, statErr),
-					InnerError: statErr,
-				}}
-			}
+		info, err := os.Lstat(sourcePath)
+		if err != nil {
+			return Result[string]{Error: &AppError{
+				Code:       FileLinkError,
+				Message:    fmt.Sprintf("cannot access %s: %v", sourcePath, err),
+				InnerError: err,
+			}}
+		}
 
+		if kind == LinkHard {
 			if info.IsDir() {
-				if err := copyDir(sourcePath, dest); err != nil {
-					return Result[string]{Error: &AppError{
-						Code:       FileMoveError,
-						Message:    fmt.Sprintf("failed to move directory %s: %v", sourcePath, err),
-						InnerError: err,
-					}}
-				}
-			} else {
-				if err := copyFile(sourcePath, dest); err != nil {
-					return Result[string]{Error: &AppError{
-						Code:       FileMoveError,
-						Message:    fmt.Sprintf("failed to move file %s: %v", sourcePath, err),
-						InnerError: err,
-					}}
-				}
+				return Result[string]{Error: &AppError{
+					Code:    FileLinkError,
+					Message: fmt.Sprintf("cannot hard link directory %s", info.Name()),
+				}}
 			}
-
-			if err := os.RemoveAll(sourcePath); err != nil {
+			// Hard links cannot cross devices, check before touching any conflicting item
+			if device := deviceOf(info); device != 0 && device != deviceOf(targetInfo) {
 				return Result[string]{Error: &AppError{
-					Code:       FileCleanupError,
-					Message:    fmt.Sprintf("failed to remove original %s after move: %v", sourcePath, err),
-					InnerError: err,
+					Code:    FileLinkError,
+					Message: fmt.Sprintf("cannot hard link %s: %s is on another device", info.Name(), target),
 				}}
 			}
 		}
+
+		// A link is never a directory, whatever it points to
+		dest, skip, appErr := resolveConflict(sourcePath, dest, false, policy, FileLinkError)
+		if appErr != nil {
+			return Result[string]{Error: appErr}
+		}
+		if skip {
+			skipped++
+			continue
+		}
+
+		err = replaceWith(dest, func(path string) error {
+			switch kind {
+			case LinkHard:
+				return os.Link(sourcePath, path)
+			case LinkSymbolicRelative:
+				linkTarget, relErr := filepath.Rel(filepath.Dir(dest), sourcePath)
+				if relErr != nil {
+					linkTarget = sourcePath // e.g. another drive on Windows
+				}
+				return os.Symlink(linkTarget, path)
+			default:
+				return os.Symlink(sourcePath, path)
+			}
+		})
+		if err != nil {
+			return Result[string]{Error: &AppError{
+				Code:       FileLinkError,
+				Message:    fmt.Sprintf("failed to link %s: \n%v", info.Name(), err),
+				InnerError: err,
+			}}
+		}
 	}
 
-	return Result[string]{Data: ptrString(fmt.Sprintf("Moved %d item(s) to %s", len(files), target))}
+	return Result[string]{Data: ptrString(fmt.Sprintf("Linked %d item(s) in %s%s", len(files)-skipped, target, skippedSuffix(skipped)))}
 }
 
 func (f *FileManagerService) DeleteFiles(files []string) Result[string] {
@@ -430,6 +908,27 @@ func (f *FileManagerService) DeleteFiles(files []string) Result[string] {
 	return Result[string]{Data: ptrString(fmt.Sprintf("Deleted %d item(s)", len(files)))}
 }
 
+// TrashFiles moves files to the trash (Recycle Bin on Windows) instead of deleting them.
+func (f *FileManagerService) TrashFiles(files []string) Result[string] {
+	for _, source := range files {
+		sourceResult := canonicalPath(source)
+		if sourceResult.Error != nil {
+			return Result[string]{Error: sourceResult.Error}
+		}
+		sourcePath := *sourceResult.Data
+
+		if err := moveToTrash(sourcePath); err != nil {
+			return Result[string]{Error: &AppError{
+				Code:       FileTrashError,
+				Message:    fmt.Sprintf("failed to move %s to the trash: %v", sourcePath, err),
+				InnerError: err,
+			}}
+		}
+	}
+
+	return Result[string]{Data: ptrString(fmt.Sprintf("Moved %d item(s) to the trash", len(files)))}
+}
+
 // GetParentFolder returns the parent directory of a given path
 func (f *FileManagerService) GetParentFolder(filePath string) Result[string] {
 	pathResult := canonicalPath(filePath)
@@ -452,83 +951,137 @@ func (f *FileManagerService) GetParentFolder(filePath string) Result[string] {
 	return Result[string]{Data: &parentDir}
 }
 
-// Helper: copy a single file
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
+// Helper: apply a conflict policy when dest may already exist.
+// Returns the destination to use, or skip=true if the item should be left alone.
+// With overwrite, an existing file stays until the new item is renamed over it,
+// only a directory (or any item a directory replaces) is removed first.
+func resolveConflict(sourcePath string, dest string, sourceIsDir bool, policy ConflictPolicy, code ErrorCode) (string, bool, *AppError) {
+	destInfo, err := os.Lstat(dest)
 	if err != nil {
-		return err
+		return dest, false, nil
 	}
-	defer sourceFile.Close()
 
-	destFile, err := os.Create(dst)
-	if err != nil {
-		return err
+	// Pasting an item in its own directory can only make a copy next to it
+	if dest == sourcePath && policy != ConflictSkip {
+		policy = ConflictRename
 	}
-	defer destFile.Close()
 
-	if _, err := io.Copy(destFile, sourceFile); err != nil {
-		// Cleanup partial file
-		destFile.Close()
-		os.Remove(dst)
-		return err
+	switch policy {
+	case ConflictSkip:
+		return dest, true, nil
+	case ConflictOverwrite:
+		// Removing dest would remove the source with it
+		if destInfo.IsDir() && containsPath(destInfo, sourcePath) {
+			return "", false, &AppError{
+				Code:    code,
+				Message: fmt.Sprintf("cannot replace %s: it contains %s", dest, sourcePath),
+			}
+		}
+		if !sourceIsDir && !destInfo.IsDir() {
+			return dest, false, nil
+		}
+		if err := os.RemoveAll(dest); err != nil {
+			return "", false, &AppError{
+				Code:       code,
+				Message:    fmt.Sprintf("failed to replace %s: %v", dest, err),
+				InnerError: err,
+			}
+		}
+		return dest, false, nil
+	case ConflictRename:
+		return uniqueDestination(dest), false, nil
+	default:
+		return "", false, &AppError{
+			Code:    code,
+			Message: fmt.Sprintf("destination %s already exists", dest),
+		}
 	}
+}
 
-	info, err := sourceFile.Stat()
-	if err != nil {
-		return err
+// Helper: whether path is the directory dir (whose info is known) or inside it.
+// Compares files rather than names, so symbolic links and case-insensitive file systems are handled.
+func containsPath(dir fs.FileInfo, path string) bool {
+	for {
+		if info, err := os.Stat(path); err == nil && os.SameFile(info, dir) {
+			return true
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return false
+		}
+		path = parent
 	}
+}
 
-	// keep timestamps
-	if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+// Helper: create an item at dest, renaming it over the existing one if any,
+// so the old item stays whole until the new one is ready.
+func replaceWith(dest string, create func(path string) error) error {
+	if !exists(dest) {
+		return create(dest)
+	}
+	temp := partialCopyPath(dest)
+	if err := create(temp); err != nil {
 		return err
 	}
-
-	// keep permissions
-	return os.Chmod(dst, info.Mode())
+	err := os.Rename(temp, dest)
+	// Also when dest is a hard link to the same file: rename then does nothing
+	os.Remove(temp)
+	return err
 }
 
-// Helper: recursively copy a directory
-// WARNING: THIS doesnt handle symlinks or special files
-// to debug: node_modules from a pnpm project is a good test case
-func copyDir(src, dst string) error {
-	info, err := os.Stat(src)
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
 
-	if err != nil {
-		return err
+// Helper: find a free "name (copy N).ext" next to dest
+func uniqueDestination(dest string) string {
+	dir, name := filepath.Split(dest)
+	ext := filepath.Ext(name)
+	// Directories and dotfiles keep their whole name as stem
+	if info, err := os.Stat(dest); (err == nil && info.IsDir()) || ext == name {
+		ext = ""
 	}
+	stem := strings.TrimSuffix(name, ext)
 
-	if !info.IsDir() {
-		return fmt.Errorf("source %s is not a directory", src)
+	for i := 1; ; i++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (copy %d)%s", stem, i, ext))
+		if _, err := os.Lstat(candidate); err != nil {
+			return candidate
+		}
 	}
+}
 
-	entries, err := os.ReadDir(src)
-	if err != nil {
-		return err
+// Helper: append the metadata warnings of a copy to its message
+func withCopyWarnings(result Result[string], copier *fileCopier) Result[string] {
+	if result.Data == nil {
+		return result
 	}
-
-	// Keep permissions + create destination dir (this could go further maybe info mode everything inside?)
-	if err := os.MkdirAll(dst, info.Mode().Perm()); err != nil {
-		return err
+	message := *result.Data
+	for _, warning := range copier.warnings() {
+		message += "\nWarning: " + warning
 	}
+	return Result[string]{Data: &message}
+}
 
-	for _, entry := range entries {
-		srcPath := filepath.Join(src, entry.Name())
-		dstPath := filepath.Join(dst, entry.Name())
-
-		if entry.IsDir() {
-			if err := copyDir(srcPath, dstPath); err != nil {
-				return err
-			}
-		} else {
-			if err := copyFile(srcPath, dstPath); err != nil {
-				return err
-			}
-		}
+func skippedSuffix(skipped int) string {
+	if skipped == 0 {
+		return ""
 	}
-	return nil
+	return fmt.Sprintf(" (%d skipped)", skipped)
 }
 
 // Helper: pointer to string
 func ptrString(s string) *string {
 	return &s
 }
+
+// Helper: random UUID v4, the format the frontend uses for its ids
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
@@ -0,0 +1,653 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// GitService runs basic git operations on the repository containing the given paths.
+// Paths may be files or directories; a directory stands for the changed files below it.
+type GitService struct {
+	Status *GitStatusService // optional, its cache is dropped after every change
+}
+
+// GitCommit is one entry of a file log.
+type GitCommit struct {
+	Hash      string    `json:"hash"`
+	ShortHash string    `json:"shortHash"`
+	Author    string    `json:"author"`
+	Email     string    `json:"email"`
+	When      time.Time `json:"when"`
+	Summary   string    `json:"summary"` // first line of the message
+	Message   string    `json:"message"`
+}
+
+// GitBlame summarizes who last changed the lines of a file, as of HEAD.
+type GitBlame struct {
+	Path    string           `json:"path"`
+	Authors []GitBlameAuthor `json:"authors"` // most lines first
+	Lines   []GitBlameLine   `json:"lines"`   // one per line of the committed file
+}
+
+type GitBlameAuthor struct {
+	Name       string    `json:"name"`
+	Email      string    `json:"email"`
+	Lines      int       `json:"lines"`
+	LastChange time.Time `json:"lastChange"`
+}
+
+type GitBlameLine struct {
+	ShortHash string    `json:"shortHash"`
+	Author    string    `json:"author"`
+	When      time.Time `json:"when"`
+	Text      string    `json:"text"`
+}
+
+// GitDiff is the difference between HEAD and the working copy.
+type GitDiff struct {
+	Root  string        `json:"root"`
+	Files []GitFileDiff `json:"files"`
+	Patch string        `json:"patch"` // unified diff of all files
+}
+
+type GitFileDiff struct {
+	Path    string `json:"path"`   // relative to the root, slash separated
+	Status  string `json:"status"` // "added", "deleted" or "modified"
+	Binary  bool   `json:"binary"`
+	Added   int    `json:"added"`   // lines
+	Deleted int    `json:"deleted"` // lines
+}
+
+const (
+	// Default and maximum number of entries returned by GetFileLog.
+	gitLogDefaultLimit = 100
+	gitLogMaxLimit     = 10000
+	// Files are treated as binary if a NUL byte shows up in this many first bytes, like git does.
+	gitBinaryProbeSize = 8000
+)
+
+// StageFiles adds the changes of paths to the index, deletions included.
+func (g *GitService) StageFiles(paths []string) Result[string] {
+	count := 0
+	appErr := g.forEachRepository(paths, func(repo *git.Repository, worktree *git.Worktree, rels []string) error {
+		status, err := worktree.Status()
+		if err != nil {
+			return err
+		}
+		for _, file := range changedFiles(status, rels, func(s *git.FileStatus) bool { return s.Worktree != git.Unmodified }) {
+			if _, err := worktree.Add(file); err != nil {
+				return fmt.Errorf("stage %s: %w", file, err)
+			}
+			count++
+		}
+		return nil
+	})
+	if appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+	return Result[string]{Data: ptrString(fmt.Sprintf("Staged %d files", count))}
+}
+
+// UnstageFiles resets the index entries of paths to HEAD, keeping the working copy.
+func (g *GitService) UnstageFiles(paths []string) Result[string] {
+	count := 0
+	appErr := g.forEachRepository(paths, func(repo *git.Repository, worktree *git.Worktree, rels []string) error {
+		status, err := worktree.Status()
+		if err != nil {
+			return err
+		}
+		files := changedFiles(status, rels, func(s *git.FileStatus) bool {
+			return s.Staging != git.Unmodified && s.Staging != git.Untracked
+		})
+		if len(files) == 0 {
+			return nil
+		}
+
+		if _, err := repo.Head(); errors.Is(err, plumbing.ErrReferenceNotFound) {
+			// Nothing committed yet, unstaging is dropping the entries
+			idx, err := repo.Storer.Index()
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				idx.Remove(file)
+			}
+			if err := repo.Storer.SetIndex(idx); err != nil {
+				return err
+			}
+		} else if err := worktree.Restore(&git.RestoreOptions{Staged: true, Files: files}); err != nil {
+			return err
+		}
+		count += len(files)
+		return nil
+	})
+	if appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+	return Result[string]{Data: ptrString(fmt.Sprintf("Unstaged %d files", count))}
+}
+
+// DiscardChanges restores the working copy of paths from the index, like git restore.
+// Staged changes are kept and untracked files are left alone.
+func (g *GitService) DiscardChanges(paths []string) Result[string] {
+	count := 0
+	appErr := g.forEachRepository(paths, func(repo *git.Repository, worktree *git.Worktree, rels []string) error {
+		status, err := worktree.Status()
+		if err != nil {
+			return err
+		}
+		idx, err := repo.Storer.Index()
+		if err != nil {
+			return err
+		}
+		root := worktree.Filesystem.Root()
+		for _, file := range changedFiles(status, rels, func(s *git.FileStatus) bool {
+			return s.Worktree != git.Unmodified && s.Worktree != git.Untracked
+		}) {
+			entry, err := idx.Entry(file)
+			if err != nil {
+				return fmt.Errorf("%s is not in the index: %w", file, err)
+			}
+			if err := restoreIndexEntry(repo, entry, filepath.Join(root, filepath.FromSlash(file))); err != nil {
+				return fmt.Errorf("restore %s: %w", file, err)
+			}
+			count++
+		}
+		return nil
+	})
+	if appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+	return Result[string]{Data: ptrString(fmt.Sprintf("Discarded changes of %d files", count))}
+}
+
+// Commit records the staged changes of the repository containing dirPath.
+// Author and committer come from the git config (user.name, user.email).
+func (g *GitService) Commit(dirPath string, message string) Result[GitCommit] {
+	if strings.TrimSpace(message) == "" {
+		return Result[GitCommit]{Error: &AppError{Code: GitOperationError, Message: "empty commit message"}}
+	}
+	repo, root, _, appErr := openRepository(dirPath)
+	if appErr != nil {
+		return Result[GitCommit]{Error: appErr}
+	}
+	defer g.Status.invalidate(root)
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return Result[GitCommit]{Error: gitError(err)}
+	}
+	hash, err := worktree.Commit(message, &git.CommitOptions{})
+	if errors.Is(err, git.ErrEmptyCommit) {
+		return Result[GitCommit]{Error: &AppError{Code: GitOperationError, Message: "nothing staged to commit", InnerError: err}}
+	}
+	if errors.Is(err, git.ErrMissingAuthor) {
+		return Result[GitCommit]{Error: &AppError{Code: GitOperationError, Message: "set user.name and user.email in the git config to commit", InnerError: err}}
+	}
+	if err != nil {
+		return Result[GitCommit]{Error: gitError(err)}
+	}
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return Result[GitCommit]{Error: gitError(err)}
+	}
+	return Result[GitCommit]{Data: gitCommitOf(commit)}
+}
+
+// GetFileLog returns the commits that changed filePath (a file or a directory), newest first.
+func (g *GitService) GetFileLog(filePath string, limit int) Result[[]GitCommit] {
+	repo, _, rel, appErr := openRepository(filePath)
+	if appErr != nil {
+		return Result[[]GitCommit]{Error: appErr}
+	}
+	if limit <= 0 {
+		limit = gitLogDefaultLimit
+	}
+	limit = min(limit, gitLogMaxLimit)
+
+	options := &git.LogOptions{}
+	if rel != "" {
+		options.PathFilter = func(p string) bool { return p == rel || strings.HasPrefix(p, rel+"/") }
+	}
+	commits := []GitCommit{}
+	iter, err := repo.Log(options)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return Result[[]GitCommit]{Data: &commits}
+	}
+	if err != nil {
+		return Result[[]GitCommit]{Error: gitError(err)}
+	}
+	defer iter.Close()
+
+	err = iter.ForEach(func(c *object.Commit) error {
+		commits = append(commits, *gitCommitOf(c))
+		if len(commits) >= limit {
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return Result[[]GitCommit]{Error: gitError(err)}
+	}
+	return Result[[]GitCommit]{Data: &commits}
+}
+
+// GetBlame returns the last change of every line of filePath as committed in HEAD,
+// and the number of lines per author. Uncommitted changes are not attributed.
+func (g *GitService) GetBlame(filePath string) Result[GitBlame] {
+	repo, _, rel, appErr := openRepository(filePath)
+	if appErr != nil {
+		return Result[GitBlame]{Error: appErr}
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return Result[GitBlame]{Error: gitError(err)}
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return Result[GitBlame]{Error: gitError(err)}
+	}
+	result, err := git.Blame(commit, rel)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return Result[GitBlame]{Error: &AppError{Code: GitOperationError, Message: fmt.Sprintf("%s is not committed", rel), InnerError: err}}
+	}
+	if err != nil {
+		return Result[GitBlame]{Error: gitError(err)}
+	}
+
+	blame := GitBlame{Path: rel, Authors: []GitBlameAuthor{}, Lines: make([]GitBlameLine, 0, len(result.Lines))}
+	authors := map[string]*GitBlameAuthor{}
+	for _, line := range result.Lines {
+		blame.Lines = append(blame.Lines, GitBlameLine{
+			ShortHash: line.Hash.String()[:7],
+			Author:    line.AuthorName,
+			When:      line.Date,
+			Text:      line.Text,
+		})
+		author, ok := authors[line.Author]
+		if !ok {
+			author = &GitBlameAuthor{Name: line.AuthorName, Email: line.Author}
+			authors[line.Author] = author
+		}
+		author.Lines++
+		if line.Date.After(author.LastChange) {
+			author.LastChange = line.Date
+		}
+	}
+	for _, author := range authors {
+		blame.Authors = append(blame.Authors, *author)
+	}
+	slices.SortFunc(blame.Authors, func(a, b GitBlameAuthor) int {
+		if a.Lines != b.Lines {
+			return b.Lines - a.Lines
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return Result[GitBlame]{Data: &blame}
+}
+
+// GetDiff compares the working copy of filePath (a file or a directory) with HEAD,
+// staged and unstaged changes together. Untracked files are not included.
+func (g *GitService) GetDiff(filePath string) Result[GitDiff] {
+	repo, root, rel, appErr := openRepository(filePath)
+	if appErr != nil {
+		return Result[GitDiff]{Error: appErr}
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return Result[GitDiff]{Error: gitError(err)}
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return Result[GitDiff]{Error: gitError(err)}
+	}
+
+	var headTree *object.Tree
+	if head, err := repo.Head(); err == nil {
+		commit, err := repo.CommitObject(head.Hash())
+		if err != nil {
+			return Result[GitDiff]{Error: gitError(err)}
+		}
+		if headTree, err = commit.Tree(); err != nil {
+			return Result[GitDiff]{Error: gitError(err)}
+		}
+	}
+
+	result := GitDiff{Root: root, Files: []GitFileDiff{}}
+	var patch gitPatch
+	files := changedFiles(status, []string{rel}, func(s *git.FileStatus) bool { return s.Worktree != git.Untracked })
+	for _, file := range files {
+		filePatch, err := worktreeFilePatch(headTree, root, file)
+		if err != nil {
+			return Result[GitDiff]{Error: gitError(err)}
+		}
+		if filePatch == nil {
+			continue // staged then reverted in the working copy
+		}
+		patch.files = append(patch.files, filePatch)
+		result.Files = append(result.Files, filePatch.summary())
+	}
+
+	var buf bytes.Buffer
+	if err := fdiff.NewUnifiedEncoder(&buf, fdiff.DefaultContextLines).Encode(patch); err != nil {
+		return Result[GitDiff]{Error: gitError(err)}
+	}
+	result.Patch = buf.String()
+	return Result[GitDiff]{Data: &result}
+}
+
+// forEachRepository groups paths by repository and calls fn with the worktree
+// relative paths of each group. The status cache of every touched repository is dropped.
+func (g *GitService) forEachRepository(paths []string, fn func(repo *git.Repository, worktree *git.Worktree, rels []string) error) *AppError {
+	if len(paths) == 0 {
+		return &AppError{Code: GitOperationError, Message: "no files selected"}
+	}
+
+	type group struct {
+		repo *git.Repository
+		rels []string
+	}
+	groups := map[string]*group{}
+	var roots []string
+	for _, p := range paths {
+		repo, root, rel, appErr := openRepository(p)
+		if appErr != nil {
+			return appErr
+		}
+		if groups[root] == nil {
+			groups[root] = &group{repo: repo}
+			roots = append(roots, root)
+		}
+		groups[root].rels = append(groups[root].rels, rel)
+	}
+
+	for _, root := range roots {
+		group := groups[root]
+		worktree, err := group.repo.Worktree()
+		if err != nil {
+			return gitError(err)
+		}
+		err = fn(group.repo, worktree, group.rels)
+		g.Status.invalidate(root)
+		if err != nil {
+			return gitError(err)
+		}
+	}
+	return nil
+}
+
+// openRepository opens the repository containing p and returns its root and the
+// slash separated path of p relative to it ("" for the root itself).
+func openRepository(p string) (*git.Repository, string, string, *AppError) {
+	pathResult := canonicalPath(p)
+	if pathResult.Error != nil {
+		return nil, "", "", pathResult.Error
+	}
+	abs := *pathResult.Data
+
+	root, ok := findGitRoot(abs)
+	if !ok {
+		return nil, "", "", &AppError{Code: GitRepositoryNotFoundError, Message: fmt.Sprintf("%s is not inside a git repository", abs)}
+	}
+	repo, err := git.PlainOpen(root)
+	if err != nil {
+		return nil, "", "", gitError(err)
+	}
+
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return nil, "", "", gitError(err)
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." {
+		rel = ""
+	}
+	return repo, root, rel, nil
+}
+
+// changedFiles returns the files of status matching keep that are one of rels or below one of them.
+func changedFiles(status git.Status, rels []string, keep func(*git.FileStatus) bool) []string {
+	var files []string
+	for file, fileStatus := range status {
+		if !keep(fileStatus) {
+			continue
+		}
+		for _, rel := range rels {
+			if rel == "" || file == rel || strings.HasPrefix(file, rel+"/") {
+				files = append(files, file)
+				break
+			}
+		}
+	}
+	slices.Sort(files)
+	return files
+}
+
+// restoreIndexEntry writes the content of an index entry to path.
+func restoreIndexEntry(repo *git.Repository, entry *index.Entry, path string) error {
+	blob, err := repo.BlobObject(entry.Hash)
+	if err != nil {
+		return err
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	switch entry.Mode {
+	case filemode.Symlink:
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return os.Symlink(string(content), path)
+	case filemode.Regular, filemode.Deprecated, filemode.Executable:
+		perm, err := entry.Mode.ToOSFileMode()
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(path, content, perm.Perm())
+	default:
+		return fmt.Errorf("cannot restore an entry of mode %s", entry.Mode)
+	}
+}
+
+func gitCommitOf(c *object.Commit) *GitCommit {
+	summary, _, _ := strings.Cut(c.Message, "\n")
+	return &GitCommit{
+		Hash:      c.Hash.String(),
+		ShortHash: c.Hash.String()[:7],
+		Author:    c.Author.Name,
+		Email:     c.Author.Email,
+		When:      c.Author.When,
+		Summary:   summary,
+		Message:   c.Message,
+	}
+}
+
+func gitError(err error) *AppError {
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return &AppError{Code: GitOperationError, Message: fmt.Sprintf("git error: %v", err), InnerError: err}
+}
+
+// gitPatch implements fdiff.Patch for HEAD against the working copy, so the
+// go-git unified encoder can print it.
+type gitPatch struct {
+	files []*gitFilePatch
+}
+
+type gitFilePatch struct {
+	from, to *gitPatchFile // nil when added or deleted
+	binary   bool
+	chunks   []fdiff.Chunk
+}
+
+type gitPatchFile struct {
+	hash plumbing.Hash
+	mode filemode.FileMode
+	path string
+}
+
+type gitChunk struct {
+	content string
+	op      fdiff.Operation
+}
+
+func (p gitPatch) FilePatches() []fdiff.FilePatch {
+	patches := make([]fdiff.FilePatch, len(p.files))
+	for i, file := range p.files {
+		patches[i] = file
+	}
+	return patches
+}
+
+func (p gitPatch) Message() string { return "" }
+
+func (p *gitFilePatch) IsBinary() bool { return p.binary }
+
+func (p *gitFilePatch) Files() (fdiff.File, fdiff.File) {
+	// Typed nil pointers must not leak into the interfaces
+	var from, to fdiff.File
+	if p.from != nil {
+		from = p.from
+	}
+	if p.to != nil {
+		to = p.to
+	}
+	return from, to
+}
+
+func (p *gitFilePatch) Chunks() []fdiff.Chunk { return p.chunks }
+
+func (f *gitPatchFile) Hash() plumbing.Hash     { return f.hash }
+func (f *gitPatchFile) Mode() filemode.FileMode { return f.mode }
+func (f *gitPatchFile) Path() string            { return f.path }
+
+func (c *gitChunk) Content() string       { return c.content }
+func (c *gitChunk) Type() fdiff.Operation { return c.op }
+
+func (p *gitFilePatch) summary() GitFileDiff {
+	summary := GitFileDiff{Binary: p.binary, Status: "modified"}
+	switch {
+	case p.from == nil:
+		summary.Status, summary.Path = "added", p.to.path
+	case p.to == nil:
+		summary.Status, summary.Path = "deleted", p.from.path
+	default:
+		summary.Path = p.to.path
+	}
+	for _, chunk := range p.chunks {
+		lines := strings.Count(chunk.Content(), "\n")
+		if !strings.HasSuffix(chunk.Content(), "\n") {
+			lines++
+		}
+		switch chunk.Type() {
+		case fdiff.Add:
+			summary.Added += lines
+		case fdiff.Delete:
+			summary.Deleted += lines
+		}
+	}
+	return summary
+}
+
+// worktreeFilePatch diffs file as committed in headTree (nil before the first
+// commit) against the working copy. It returns nil if both are identical.
+func worktreeFilePatch(headTree *object.Tree, root string, file string) (*gitFilePatch, error) {
+	patch := &gitFilePatch{}
+
+	var fromContent []byte
+	if headTree != nil {
+		headFile, err := headTree.File(file)
+		if err != nil && !errors.Is(err, object.ErrFileNotFound) {
+			return nil, err
+		}
+		if headFile != nil {
+			content, err := headFile.Contents()
+			if err != nil {
+				return nil, err
+			}
+			fromContent = []byte(content)
+			patch.from = &gitPatchFile{hash: headFile.Hash, mode: headFile.Mode, path: file}
+		}
+	}
+
+	var toContent []byte
+	fullPath := filepath.Join(root, filepath.FromSlash(file))
+	info, err := os.Lstat(fullPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if info != nil {
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(fullPath)
+			if err != nil {
+				return nil, err
+			}
+			toContent = []byte(target)
+		} else if toContent, err = os.ReadFile(fullPath); err != nil {
+			return nil, err
+		}
+		mode, err := filemode.NewFromOSFileMode(info.Mode())
+		if err != nil {
+			return nil, err
+		}
+		patch.to = &gitPatchFile{hash: plumbing.ComputeHash(plumbing.BlobObject, toContent), mode: mode, path: file}
+	}
+
+	if patch.from == nil && patch.to == nil {
+		return nil, nil
+	}
+	if patch.from != nil && patch.to != nil && patch.from.hash == patch.to.hash && patch.from.mode == patch.to.mode {
+		return nil, nil
+	}
+
+	if isBinaryContent(fromContent) || isBinaryContent(toContent) {
+		patch.binary = true
+		return patch, nil
+	}
+	for _, d := range diff.Do(string(fromContent), string(toContent)) {
+		chunk := &gitChunk{content: d.Text}
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			chunk.op = fdiff.Equal
+		case diffmatchpatch.DiffDelete:
+			chunk.op = fdiff.Delete
+		case diffmatchpatch.DiffInsert:
+			chunk.op = fdiff.Add
+		}
+		patch.chunks = append(patch.chunks, chunk)
+	}
+	return patch, nil
+}
+
+func isBinaryContent(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), gitBinaryProbeSize)], 0) >= 0
+}
@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// newTestRepository creates a repository with a committer in t.TempDir().
+func newTestRepository(t *testing.T) (string, *git.Repository) {
+	t.Helper()
+	root := t.TempDir()
+	repo, err := git.PlainInit(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.User.Name = "Test Author"
+	cfg.User.Email = "author@example.com"
+	if err := repo.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return root, repo
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func fileStatus(t *testing.T, repo *git.Repository, file string) git.FileStatus {
+	t.Helper()
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *status.File(file)
+}
+
+func TestGitServiceStageAndUnstage(t *testing.T) {
+	root, repo := newTestRepository(t)
+	g := &GitService{}
+	file := filepath.Join(root, "a.txt")
+	writeTestFile(t, file, "one\n")
+
+	if result := g.StageFiles([]string{root}); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if status := fileStatus(t, repo, "a.txt"); status.Staging != git.Added {
+		t.Fatalf("staging status after StageFiles = %q, want added", status.Staging)
+	}
+
+	// Nothing committed yet: unstaging drops the index entry
+	if result := g.UnstageFiles([]string{file}); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if status := fileStatus(t, repo, "a.txt"); status.Worktree != git.Untracked {
+		t.Fatalf("worktree status after UnstageFiles = %q, want untracked", status.Worktree)
+	}
+
+	g.StageFiles([]string{file})
+	if result := g.Commit(root, "first"); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+
+	// Committed: unstaging restores the index from HEAD and keeps the working copy
+	writeTestFile(t, file, "one\ntwo\n")
+	g.StageFiles([]string{file})
+	if result := g.UnstageFiles([]string{file}); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	status := fileStatus(t, repo, "a.txt")
+	if status.Staging != git.Unmodified || status.Worktree != git.Modified {
+		t.Fatalf("status after UnstageFiles = %q%q, want unstaged modification", status.Staging, status.Worktree)
+	}
+	if content, _ := os.ReadFile(file); string(content) != "one\ntwo\n" {
+		t.Fatalf("UnstageFiles changed the working copy to %q", content)
+	}
+}
+
+func TestGitServiceCommitAndLog(t *testing.T) {
+	root, _ := newTestRepository(t)
+	g := &GitService{}
+	file := filepath.Join(root, "a.txt")
+
+	if result := g.Commit(root, "nothing"); result.Error == nil {
+		t.Fatal("Commit without staged changes succeeded")
+	}
+	if result := g.Commit(root, "  "); result.Error == nil {
+		t.Fatal("Commit with an empty message succeeded")
+	}
+
+	writeTestFile(t, file, "one\n")
+	writeTestFile(t, filepath.Join(root, "b.txt"), "other\n")
+	g.StageFiles([]string{root})
+	first := g.Commit(root, "first\n\nWith a body.")
+	if first.Error != nil {
+		t.Fatal(first.Error)
+	}
+	if first.Data.Summary != "first" || first.Data.Author != "Test Author" || first.Data.Email != "author@example.com" {
+		t.Fatalf("Commit returned %+v", *first.Data)
+	}
+
+	writeTestFile(t, file, "one\ntwo\n")
+	g.StageFiles([]string{file})
+	second := g.Commit(root, "second")
+	if second.Error != nil {
+		t.Fatal(second.Error)
+	}
+
+	log := g.GetFileLog(file, 0)
+	if log.Error != nil {
+		t.Fatal(log.Error)
+	}
+	if got := len(*log.Data); got != 2 {
+		t.Fatalf("GetFileLog returned %d commits, want 2", got)
+	}
+	if (*log.Data)[0].Hash != second.Data.Hash || (*log.Data)[1].Hash != first.Data.Hash {
+		t.Fatal("GetFileLog is not newest first")
+	}
+
+	other := g.GetFileLog(filepath.Join(root, "b.txt"), 0)
+	if other.Error != nil || len(*other.Data) != 1 {
+		t.Fatalf("GetFileLog of b.txt = %v, want only the first commit", other)
+	}
+	if limited := g.GetFileLog(root, 1); limited.Error != nil || len(*limited.Data) != 1 {
+		t.Fatal("GetFileLog ignored the limit")
+	}
+}
+
+func TestGitServiceBlame(t *testing.T) {
+	root, repo := newTestRepository(t)
+	g := &GitService{}
+	file := filepath.Join(root, "a.txt")
+
+	writeTestFile(t, file, "one\ntwo\n")
+	g.StageFiles([]string{file})
+	g.Commit(root, "first")
+
+	// A second author changes one line
+	cfg, _ := repo.Config()
+	cfg.User.Name = "Other Author"
+	cfg.User.Email = "other@example.com"
+	repo.SetConfig(cfg)
+	writeTestFile(t, file, "one\n2\nthree\n")
+	g.StageFiles([]string{file})
+	g.Commit(root, "second")
+
+	blame := g.GetBlame(file)
+	if blame.Error != nil {
+		t.Fatal(blame.Error)
+	}
+	var authors []string
+	for _, line := range blame.Data.Lines {
+		authors = append(authors, line.Author+": "+line.Text)
+	}
+	want := []string{"Test Author: one", "Other Author: 2", "Other Author: three"}
+	if strings.Join(authors, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("GetBlame lines = %q, want %q", authors, want)
+	}
+	if len(blame.Data.Authors) != 2 || blame.Data.Authors[0].Name != "Other Author" || blame.Data.Authors[0].Lines != 2 {
+		t.Fatalf("GetBlame authors = %+v, want most lines first", blame.Data.Authors)
+	}
+
+	writeTestFile(t, filepath.Join(root, "new.txt"), "new\n")
+	if result := g.GetBlame(filepath.Join(root, "new.txt")); result.Error == nil {
+		t.Fatal("GetBlame of an uncommitted file succeeded")
+	}
+}
+
+func TestGitServiceDiff(t *testing.T) {
+	root, _ := newTestRepository(t)
+	g := &GitService{}
+	file := filepath.Join(root, "a.txt")
+	writeTestFile(t, file, "one\ntwo\nthree\n")
+	writeTestFile(t, filepath.Join(root, "gone.txt"), "bye\n")
+	g.StageFiles([]string{root})
+	g.Commit(root, "first")
+
+	writeTestFile(t, file, "one\n2\nthree\nfour\n")
+	os.Remove(filepath.Join(root, "gone.txt"))
+	writeTestFile(t, filepath.Join(root, "untracked.txt"), "not in the diff\n")
+
+	diff := g.GetDiff(root)
+	if diff.Error != nil {
+		t.Fatal(diff.Error)
+	}
+	want := []GitFileDiff{
+		{Path: "a.txt", Status: "modified", Added: 2, Deleted: 1},
+		{Path: "gone.txt", Status: "deleted", Deleted: 1},
+	}
+	if len(diff.Data.Files) != len(want) {
+		t.Fatalf("GetDiff files = %+v, want %+v", diff.Data.Files, want)
+	}
+	for i := range want {
+		if diff.Data.Files[i] != want[i] {
+			t.Fatalf("GetDiff file %d = %+v, want %+v", i, diff.Data.Files[i], want[i])
+		}
+	}
+	for _, line := range []string{"--- a/a.txt", "+++ b/a.txt", "-two", "+2", "+four", "-bye"} {
+		if !strings.Contains(diff.Data.Patch, line+"\n") {
+			t.Fatalf("GetDiff patch has no %q line:\n%s", line, diff.Data.Patch)
+		}
+	}
+
+	// A single file only diffs that file
+	single := g.GetDiff(file)
+	if single.Error != nil || len(single.Data.Files) != 1 || single.Data.Files[0].Path != "a.txt" {
+		t.Fatalf("GetDiff of a.txt = %+v", single)
+	}
+}
@@ -0,0 +1,438 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// GitStatusService decorates directory listings with git status.
+// Computing a repository status can take a while, so ListDirectory only uses the
+// cached status; a stale or missing cache is refreshed in the background and the
+// result is pushed to the frontend with EventGitStatus.
+type GitStatusService struct {
+	App *application.App // used to push refreshed statuses, may be nil
+
+	mu         sync.Mutex
+	snapshots  map[string]*gitSnapshot // by repository root
+	refreshing map[string][]string     // repository root -> directories waiting for the refresh
+}
+
+// GitFileStatus is the git state of a file, or the most important state of the files in a directory.
+type GitFileStatus string
+
+const (
+	GitStatusClean      GitFileStatus = ""
+	GitStatusIgnored    GitFileStatus = "ignored"
+	GitStatusUntracked  GitFileStatus = "untracked"
+	GitStatusStaged     GitFileStatus = "staged"
+	GitStatusModified   GitFileStatus = "modified"
+	GitStatusConflicted GitFileStatus = "conflicted"
+)
+
+// GitRepoInfo describes the repository containing a listed directory.
+type GitRepoInfo struct {
+	Root        string `json:"root"`
+	Branch      string `json:"branch"` // branch name, or short commit hash when detached
+	Detached    bool   `json:"detached,omitempty"`
+	HasUpstream bool   `json:"hasUpstream"`
+	Ahead       int    `json:"ahead"`             // commits not pushed to the upstream branch
+	Behind      int    `json:"behind"`            // upstream commits not merged yet
+	Pending     bool   `json:"pending,omitempty"` // status is being computed, EventGitStatus follows
+}
+
+// GitDirectoryStatus is the git status of the entries of one directory.
+type GitDirectoryStatus struct {
+	Path  string                   `json:"path"`
+	Repo  GitRepoInfo              `json:"repo"`
+	Files map[string]GitFileStatus `json:"files"` // by entry name, clean entries are omitted
+}
+
+// gitSnapshot is the cached status of a whole repository.
+type gitSnapshot struct {
+	info     GitRepoInfo
+	statuses map[string]GitFileStatus // slash separated path relative to the root -> status, directories aggregated
+	computed time.Time
+}
+
+const (
+	EventGitStatus = "git:status"
+
+	// A cached status older than this is refreshed in the background.
+	gitStatusTTL = 3 * time.Second
+	// Ahead/behind counting stops after this many commits on each side.
+	gitAheadBehindLimit = 10000
+)
+
+// gitStatusPriority orders statuses when aggregating a directory.
+var gitStatusPriority = map[GitFileStatus]int{
+	GitStatusClean:      0,
+	GitStatusIgnored:    1,
+	GitStatusUntracked:  2,
+	GitStatusStaged:     3,
+	GitStatusModified:   4,
+	GitStatusConflicted: 5,
+}
+
+// GetGitStatus computes (or returns the fresh cached) git status of a directory's entries.
+func (g *GitStatusService) GetGitStatus(dirPath string) Result[GitDirectoryStatus] {
+	pathResult := canonicalPath(dirPath)
+	if pathResult.Error != nil {
+		return Result[GitDirectoryStatus]{Error: pathResult.Error}
+	}
+	dir := *pathResult.Data
+
+	root, ok := findGitRoot(dir)
+	if !ok {
+		return Result[GitDirectoryStatus]{Error: &AppError{Code: GitRepositoryNotFoundError, Message: fmt.Sprintf("%s is not inside a git repository", dir)}}
+	}
+
+	g.mu.Lock()
+	snapshot := g.snapshots[root]
+	g.mu.Unlock()
+
+	if snapshot == nil || time.Since(snapshot.computed) > gitStatusTTL {
+		var err error
+		if snapshot, err = computeGitSnapshot(root); err != nil {
+			return Result[GitDirectoryStatus]{Error: &AppError{Code: GitStatusError, Message: fmt.Sprintf("git status of %s: %v", root, err), InnerError: err}}
+		}
+		g.store(root, snapshot)
+	}
+
+	status, err := snapshot.directoryStatus(dir)
+	if err != nil {
+		return Result[GitDirectoryStatus]{Error: &AppError{Code: ReadDirectoryError, Message: fmt.Sprintf("read directory error: %v", err), InnerError: err}}
+	}
+	return Result[GitDirectoryStatus]{Data: status}
+}
+
+// annotate sets the GitStatus of the listed files from the cache and returns the
+// repository info, or nil outside a repository. A missing or stale cache schedules
+// a background refresh.
+func (g *GitStatusService) annotate(dir string, files []FileInfo) *GitRepoInfo {
+	root, ok := findGitRoot(dir)
+	if !ok {
+		return nil
+	}
+
+	g.mu.Lock()
+	snapshot := g.snapshots[root]
+	g.mu.Unlock()
+
+	if snapshot == nil || time.Since(snapshot.computed) > gitStatusTTL {
+		g.scheduleRefresh(root, dir)
+	}
+	if snapshot == nil {
+		return &GitRepoInfo{Root: root, Pending: true}
+	}
+
+	statusOf := snapshot.entryStatusFunc(dir)
+	for i := range files {
+		files[i].GitStatus = statusOf(files[i].Name, files[i].IsDir)
+	}
+	info := snapshot.info
+	return &info
+}
+
+// scheduleRefresh recomputes the status of root in the background, once for all
+// the directories listed meanwhile, and emits EventGitStatus for each of them.
+func (g *GitStatusService) scheduleRefresh(root string, dir string) {
+	g.mu.Lock()
+	if g.refreshing == nil {
+		g.refreshing = map[string][]string{}
+	}
+	waiting, running := g.refreshing[root]
+	if !slices.Contains(waiting, dir) {
+		g.refreshing[root] = append(waiting, dir)
+	}
+	g.mu.Unlock()
+	if running {
+		return
+	}
+
+	go func() {
+		snapshot, err := computeGitSnapshot(root)
+
+		g.mu.Lock()
+		dirs := g.refreshing[root]
+		delete(g.refreshing, root)
+		g.mu.Unlock()
+
+		if err != nil {
+			Log(fmt.Sprintf("GitStatusService: git status of %s: %v", root, err))
+			return
+		}
+		g.store(root, snapshot)
+
+		for _, dir := range dirs {
+			if status, err := snapshot.directoryStatus(dir); err == nil && g.App != nil {
+				g.App.Event.Emit(EventGitStatus, *status)
+			}
+		}
+	}()
+}
+
+// invalidate drops the cached status of the repository containing p,
+// after an operation changed it.
+func (g *GitStatusService) invalidate(p string) {
+	if g == nil {
+		return
+	}
+	if root, ok := findGitRoot(p); ok {
+		g.mu.Lock()
+		delete(g.snapshots, root)
+		g.mu.Unlock()
+	}
+}
+
+func (g *GitStatusService) store(root string, snapshot *gitSnapshot) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.snapshots == nil {
+		g.snapshots = map[string]*gitSnapshot{}
+	}
+	g.snapshots[root] = snapshot
+}
+
+// findGitRoot walks up from dir to the first directory containing .git.
+func findGitRoot(dir string) (string, bool) {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, git.GitDirName)); err == nil {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// computeGitSnapshot runs git status on the repository at root.
+func computeGitSnapshot(root string) (*gitSnapshot, error) {
+	repo, err := git.PlainOpen(root)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := gitRepoInfo(repo, root)
+	if err != nil {
+		return nil, err
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := map[string]GitFileStatus{}
+	for file, fileStatus := range status {
+		s := gitFileStatusOf(fileStatus)
+		if s == GitStatusClean {
+			continue
+		}
+		statuses[file] = s
+		// Directories show the most important status of their content
+		for dir := path.Dir(file); dir != "."; dir = path.Dir(dir) {
+			if gitStatusPriority[s] <= gitStatusPriority[statuses[dir]] {
+				break
+			}
+			statuses[dir] = s
+		}
+	}
+
+	return &gitSnapshot{info: *info, statuses: statuses, computed: time.Now()}, nil
+}
+
+func gitFileStatusOf(s *git.FileStatus) GitFileStatus {
+	switch {
+	case s.Staging == git.UpdatedButUnmerged || s.Worktree == git.UpdatedButUnmerged:
+		return GitStatusConflicted
+	case s.Worktree == git.Untracked:
+		return GitStatusUntracked
+	case s.Worktree != git.Unmodified:
+		return GitStatusModified
+	case s.Staging != git.Unmodified:
+		return GitStatusStaged
+	default:
+		return GitStatusClean
+	}
+}
+
+// gitRepoInfo reads the current branch and how it compares to its upstream.
+func gitRepoInfo(repo *git.Repository, root string) (*GitRepoInfo, error) {
+	info := &GitRepoInfo{Root: root}
+
+	head, err := repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		// Fresh repository without commits: HEAD points to an unborn branch
+		if ref, err := repo.Storer.Reference(plumbing.HEAD); err == nil {
+			info.Branch = ref.Target().Short()
+		}
+		return info, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !head.Name().IsBranch() {
+		info.Detached = true
+		info.Branch = head.Hash().String()[:7]
+		return info, nil
+	}
+	info.Branch = head.Name().Short()
+
+	config, err := repo.Config()
+	if err != nil {
+		return info, nil
+	}
+	branch, ok := config.Branches[info.Branch]
+	if !ok || branch.Remote == "" || branch.Merge == "" {
+		return info, nil
+	}
+	upstreamName := plumbing.NewRemoteReferenceName(branch.Remote, branch.Merge.Short())
+	if branch.Remote == "." {
+		upstreamName = branch.Merge
+	}
+	upstream, err := repo.Reference(upstreamName, true)
+	if err != nil {
+		return info, nil
+	}
+
+	info.HasUpstream = true
+	info.Ahead, info.Behind = aheadBehind(repo, head.Hash(), upstream.Hash())
+	return info, nil
+}
+
+// aheadBehind counts the commits reachable from local but not upstream, and the reverse.
+func aheadBehind(repo *git.Repository, local plumbing.Hash, upstream plumbing.Hash) (int, int) {
+	if local == upstream {
+		return 0, 0
+	}
+	localCommits := reachableCommits(repo, local)
+	upstreamCommits := reachableCommits(repo, upstream)
+
+	ahead, behind := 0, 0
+	for hash := range localCommits {
+		if !upstreamCommits[hash] {
+			ahead++
+		}
+	}
+	for hash := range upstreamCommits {
+		if !localCommits[hash] {
+			behind++
+		}
+	}
+	return ahead, behind
+}
+
+func reachableCommits(repo *git.Repository, from plumbing.Hash) map[plumbing.Hash]bool {
+	commits := map[plumbing.Hash]bool{}
+	iter, err := repo.Log(&git.LogOptions{From: from})
+	if err != nil {
+		return commits
+	}
+	defer iter.Close()
+	iter.ForEach(func(c *object.Commit) error {
+		commits[c.Hash] = true
+		if len(commits) >= gitAheadBehindLimit {
+			return io.EOF
+		}
+		return nil
+	})
+	return commits
+}
+
+// entryStatusFunc returns the status lookup for the entries of dir.
+// Ignored entries are not part of git status, they are matched against the
+// .gitignore files from the root down to dir.
+func (s *gitSnapshot) entryStatusFunc(dir string) func(name string, isDir bool) GitFileStatus {
+	rel, err := filepath.Rel(s.info.Root, dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return func(string, bool) GitFileStatus { return GitStatusClean }
+	}
+	rel = filepath.ToSlash(rel)
+	var relParts []string
+	if rel != "." {
+		relParts = strings.Split(rel, "/")
+	}
+	matcher := gitignoreMatcher(s.info.Root, relParts)
+
+	return func(name string, isDir bool) GitFileStatus {
+		if name == git.GitDirName && len(relParts) == 0 {
+			return GitStatusClean
+		}
+		if status, ok := s.statuses[path.Join(append(relParts, name)...)]; ok {
+			return status
+		}
+		if matcher.Match(append(relParts[:len(relParts):len(relParts)], name), isDir) {
+			return GitStatusIgnored
+		}
+		return GitStatusClean
+	}
+}
+
+// directoryStatus lists dir and returns the status of its entries.
+func (s *gitSnapshot) directoryStatus(dir string) (*GitDirectoryStatus, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	statusOf := s.entryStatusFunc(dir)
+	files := map[string]GitFileStatus{}
+	for _, entry := range entries {
+		if status := statusOf(entry.Name(), entry.IsDir()); status != GitStatusClean {
+			files[entry.Name()] = status
+		}
+	}
+	return &GitDirectoryStatus{Path: dir, Repo: s.info, Files: files}, nil
+}
+
+// gitignoreMatcher loads .git/info/exclude and the .gitignore files of the root
+// and of each directory down to relParts.
+func gitignoreMatcher(root string, relParts []string) gitignore.Matcher {
+	patterns := readIgnorePatterns(filepath.Join(root, git.GitDirName, "info", "exclude"), nil)
+	for i := 0; i <= len(relParts); i++ {
+		domain := relParts[:i:i]
+		file := filepath.Join(append([]string{root}, append(domain, ".gitignore")...)...)
+		patterns = append(patterns, readIgnorePatterns(file, domain)...)
+	}
+	return gitignore.NewMatcher(patterns)
+}
+
+func readIgnorePatterns(file string, domain []string) []gitignore.Pattern {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var patterns []gitignore.Pattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		patterns = append(patterns, gitignore.ParsePattern(line, domain))
+	}
+	return patterns
+}
@@ -0,0 +1,259 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HistoryService records visited directories and ranks them by frecency
+// (frequency + recency, the same idea as zoxide) for the "jump to" palette.
+type HistoryService struct {
+	mu      sync.Mutex
+	loaded  bool
+	entries map[string]*historyEntry
+}
+
+// RecentDir is a visited directory returned by QueryRecentDirs.
+type RecentDir struct {
+	Path       string    `json:"path"`
+	Name       string    `json:"name"`
+	Score      float64   `json:"score"`      // frecency, higher is better
+	Visits     float64   `json:"visits"`     // aged visit count
+	LastAccess time.Time `json:"lastAccess"` // last time the directory was listed
+}
+
+type historyEntry struct {
+	Path       string    `json:"path"`
+	Rank       float64   `json:"rank"`
+	LastAccess time.Time `json:"lastAccess"`
+}
+
+// historyFile is the on-disk format of history.json
+type historyFile struct {
+	Version int             `json:"version"`
+	Entries []*historyEntry `json:"entries"`
+}
+
+const (
+	historyFileName    = "history.json"
+	historyFileVersion = 1
+
+	// Like zoxide, once the ranks add up to historyMaxAge every rank is scaled down
+	// and entries that fall below 1 are forgotten.
+	historyMaxAge = 10000
+	// Listing the same directory again within this window (refresh, split pane) is not a new visit.
+	historyRevisitWindow = time.Minute
+	historyMaxResults    = 50
+)
+
+// RecordVisit bumps the rank of a directory. It is called by ListDirectory on
+// every successful navigation.
+func (h *HistoryService) RecordVisit(dirPath string) Result[string] {
+	pathResult := canonicalPath(dirPath)
+	if pathResult.Error != nil {
+		return Result[string]{Error: pathResult.Error}
+	}
+	absPath := *pathResult.Data
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if appErr := h.load(); appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+
+	now := time.Now()
+	entry, ok := h.entries[absPath]
+	if !ok {
+		entry = &historyEntry{Path: absPath}
+		h.entries[absPath] = entry
+	} else if now.Sub(entry.LastAccess) < historyRevisitWindow {
+		entry.LastAccess = now
+		return Result[string]{Data: &absPath}
+	}
+	entry.Rank++
+	entry.LastAccess = now
+	h.age()
+
+	if appErr := h.save(); appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+	return Result[string]{Data: &absPath}
+}
+
+// QueryRecentDirs returns visited directories matching query, best frecency first.
+// The query is split on spaces, every keyword must appear in the path in order
+// (case-insensitive) and the last one must match the directory name, e.g. "pro laz"
+// matches ~/projects/lazydir. An empty query returns the top directories.
+// Directories that no longer exist are pruned from the history.
+func (h *HistoryService) QueryRecentDirs(query string) Result[[]RecentDir] {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if appErr := h.load(); appErr != nil {
+		return Result[[]RecentDir]{Error: appErr}
+	}
+
+	keywords := strings.Fields(strings.ToLower(query))
+	now := time.Now()
+
+	var (
+		results []RecentDir
+		pruned  bool
+	)
+	for path, entry := range h.entries {
+		if !matchesKeywords(path, keywords) {
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			delete(h.entries, path)
+			pruned = true
+			continue
+		}
+		results = append(results, RecentDir{
+			Path:       path,
+			Name:       filepath.Base(path),
+			Score:      frecency(entry, now),
+			Visits:     entry.Rank,
+			LastAccess: entry.LastAccess,
+		})
+	}
+
+	if pruned {
+		if appErr := h.save(); appErr != nil {
+			return Result[[]RecentDir]{Error: appErr}
+		}
+	}
+
+	slices.SortFunc(results, func(a, b RecentDir) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	if len(results) > historyMaxResults {
+		results = results[:historyMaxResults]
+	}
+	if results == nil {
+		results = []RecentDir{}
+	}
+	return Result[[]RecentDir]{Data: &results}
+}
+
+// RemoveRecentDir forgets a directory.
+func (h *HistoryService) RemoveRecentDir(dirPath string) Result[string] {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if appErr := h.load(); appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+	delete(h.entries, dirPath)
+	if appErr := h.save(); appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+	return Result[string]{Data: ptrString(fmt.Sprintf("Removed %s from history", dirPath))}
+}
+
+// frecency weights the visit count by how recently the directory was used.
+func frecency(entry *historyEntry, now time.Time) float64 {
+	elapsed := now.Sub(entry.LastAccess)
+	switch {
+	case elapsed < time.Hour:
+		return entry.Rank * 4
+	case elapsed < 24*time.Hour:
+		return entry.Rank * 2
+	case elapsed < 7*24*time.Hour:
+		return entry.Rank * 0.5
+	default:
+		return entry.Rank * 0.25
+	}
+}
+
+// age scales all ranks down once their total exceeds historyMaxAge, so old
+// habits fade out and the history stays small.
+func (h *HistoryService) age() {
+	var total float64
+	for _, entry := range h.entries {
+		total += entry.Rank
+	}
+	if total <= historyMaxAge {
+		return
+	}
+	factor := 0.9 * historyMaxAge / total
+	for path, entry := range h.entries {
+		entry.Rank *= factor
+		if entry.Rank < 1 {
+			delete(h.entries, path)
+		}
+	}
+}
+
+func matchesKeywords(path string, keywords []string) bool {
+	if len(keywords) == 0 {
+		return true
+	}
+	lower := strings.ToLower(path)
+	rest := lower
+	for _, keyword := range keywords {
+		i := strings.Index(rest, keyword)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(keyword):]
+	}
+	return strings.Contains(strings.ToLower(filepath.Base(path)), keywords[len(keywords)-1])
+}
+
+// load reads the history file once, the in-memory copy is authoritative afterwards.
+func (h *HistoryService) load() *AppError {
+	if h.loaded {
+		return nil
+	}
+	path, err := stateFilePath(historyFileName)
+	if err != nil {
+		return &AppError{Code: HistoryStoreError, Message: fmt.Sprintf("failed to resolve history file: %v", err), InnerError: err}
+	}
+
+	var file historyFile
+	if _, err := readJSONFile(path, &file); err != nil {
+		// A corrupt history is not worth blocking navigation, start over.
+		Log(fmt.Sprintf("HistoryService: ignoring unreadable history: %v", err))
+		file = historyFile{}
+	}
+
+	h.entries = make(map[string]*historyEntry, len(file.Entries))
+	for _, entry := range file.Entries {
+		if entry != nil && entry.Path != "" {
+			h.entries[entry.Path] = entry
+		}
+	}
+	h.loaded = true
+	return nil
+}
+
+func (h *HistoryService) save() *AppError {
+	path, err := stateFilePath(historyFileName)
+	if err != nil {
+		return &AppError{Code: HistoryStoreError, Message: fmt.Sprintf("failed to resolve history file: %v", err), InnerError: err}
+	}
+
+	file := historyFile{Version: historyFileVersion, Entries: make([]*historyEntry, 0, len(h.entries))}
+	for _, entry := range h.entries {
+		file.Entries = append(file.Entries, entry)
+	}
+	slices.SortFunc(file.Entries, func(a, b *historyEntry) int { return strings.Compare(a.Path, b.Path) })
+
+	if err := writeJSONFileAtomic(path, file); err != nil {
+		return &AppError{Code: HistoryStoreError, Message: fmt.Sprintf("failed to save history: %v", err), InnerError: err}
+	}
+	return nil
+}
@@ -0,0 +1,830 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Media metadata is read with small format parsers, only the fields shown in
+// the properties dialog and the optional listing columns are extracted:
+//   - EXIF of JPEG and TIFF images (and the eXIf chunk of PNG),
+//   - dimensions of JPEG, PNG, GIF, WebP and TIFF images,
+//   - ID3v1/ID3v2 tags of MP3, Vorbis comments of FLAC and Ogg (Vorbis, Opus) audio.
+
+// MediaMetadata holds the metadata of an image or audio file. Fields are flat so
+// the listing can sort by any of them; unknown ones are left empty.
+type MediaMetadata struct {
+	Kind string `json:"kind"` // "image" or "audio"
+
+	// Images
+	Width        int        `json:"width,omitempty"`
+	Height       int        `json:"height,omitempty"`
+	Orientation  int        `json:"orientation,omitempty"` // EXIF orientation, 1 (upright) to 8
+	CameraMake   string     `json:"cameraMake,omitempty"`
+	CameraModel  string     `json:"cameraModel,omitempty"`
+	LensModel    string     `json:"lensModel,omitempty"`
+	ExposureTime string     `json:"exposureTime,omitempty"` // e.g. "1/250" or "2.5"
+	FNumber      float64    `json:"fNumber,omitempty"`
+	ISO          int        `json:"iso,omitempty"`
+	FocalLength  float64    `json:"focalLength,omitempty"` // millimeters
+	CaptureTime  *time.Time `json:"captureTime,omitempty"`
+	Latitude     *float64   `json:"latitude,omitempty"`
+	Longitude    *float64   `json:"longitude,omitempty"`
+	Altitude     *float64   `json:"altitude,omitempty"` // meters
+
+	// Audio
+	Title           string  `json:"title,omitempty"`
+	Artist          string  `json:"artist,omitempty"`
+	Album           string  `json:"album,omitempty"`
+	Genre           string  `json:"genre,omitempty"`
+	Year            int     `json:"year,omitempty"`
+	Track           int     `json:"track,omitempty"`
+	DurationSeconds float64 `json:"durationSeconds,omitempty"` // FLAC and Ogg only
+}
+
+type mediaCacheEntry struct {
+	size     int64
+	modified time.Time
+	metadata *MediaMetadata
+}
+
+const (
+	// Tags larger than this are not read (ID3v2 with big cover art only gets its text frames).
+	mediaMaxTagSize = 1 << 20
+	// Number of paths kept in the cache, it is dropped when full.
+	mediaCacheMaxEntries = 20000
+)
+
+var (
+	mediaCacheMu sync.Mutex
+	mediaCache   = map[string]mediaCacheEntry{}
+
+	errNoMetadata = errors.New("no metadata found")
+)
+
+// mediaMetadataOf returns the metadata of a regular file, or nil if it has none
+// or its format is not supported.
+func mediaMetadataOf(path string, info os.FileInfo, mimeType string) *MediaMetadata {
+	mediaCacheMu.Lock()
+	cached, ok := mediaCache[path]
+	mediaCacheMu.Unlock()
+	if ok && cached.size == info.Size() && cached.modified.Equal(info.ModTime()) {
+		return cached.metadata
+	}
+
+	metadata, err := readMediaMetadata(path, mimeType)
+	if err != nil {
+		metadata = nil
+		if !errors.Is(err, errNoMetadata) {
+			Log(fmt.Sprintf("mediaMetadataOf: %s: %v", path, err))
+		}
+	}
+
+	mediaCacheMu.Lock()
+	if len(mediaCache) >= mediaCacheMaxEntries {
+		mediaCache = map[string]mediaCacheEntry{}
+	}
+	mediaCache[path] = mediaCacheEntry{size: info.Size(), modified: info.ModTime(), metadata: metadata}
+	mediaCacheMu.Unlock()
+
+	return metadata
+}
+
+// hasMediaMetadata tells whether files of mimeType may carry metadata, so listings skip the others.
+func hasMediaMetadata(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp", "image/tiff",
+		"audio/mpeg", "audio/flac", "audio/x-flac", "audio/ogg", "audio/x-vorbis+ogg", "audio/x-opus+ogg", "audio/opus":
+		return true
+	}
+	return false
+}
+
+func readMediaMetadata(path string, mimeType string) (*MediaMetadata, error) {
+	if !hasMediaMetadata(mimeType) {
+		return nil, errNoMetadata
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	switch mimeType {
+	case "image/jpeg":
+		return readJPEGMetadata(file)
+	case "image/png":
+		return readPNGMetadata(file)
+	case "image/gif", "image/webp":
+		config, _, err := image.DecodeConfig(file)
+		if err != nil {
+			return nil, err
+		}
+		return &MediaMetadata{Kind: "image", Width: config.Width, Height: config.Height}, nil
+	case "image/tiff":
+		metadata := &MediaMetadata{Kind: "image"}
+		return metadata, readTIFF(file, info.Size(), metadata)
+	case "audio/mpeg":
+		return readID3(file, info.Size())
+	case "audio/flac", "audio/x-flac":
+		return readFLACMetadata(file)
+	default:
+		return readOggMetadata(file, info.Size())
+	}
+}
+
+// readJPEGMetadata walks the JPEG segments up to the image data, reading the
+// EXIF APP1 segment and the frame size.
+func readJPEGMetadata(r io.ReadSeeker) (*MediaMetadata, error) {
+	metadata := &MediaMetadata{Kind: "image"}
+	var marker [2]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil || marker != [2]byte{0xFF, 0xD8} {
+		return nil, errors.New("not a JPEG file")
+	}
+
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return metadata, nil
+		}
+		if header[0] != 0xFF {
+			return metadata, nil
+		}
+		kind := header[1]
+		length := int(binary.BigEndian.Uint16(header[2:])) - 2
+		if length < 0 {
+			return metadata, nil
+		}
+
+		switch {
+		case kind == 0xDA || kind == 0xD9: // start of scan, end of image
+			return metadata, nil
+		case kind == 0xE1: // APP1
+			segment := make([]byte, length)
+			if _, err := io.ReadFull(r, segment); err != nil {
+				return metadata, nil
+			}
+			if exif, ok := bytes.CutPrefix(segment, []byte("Exif\x00\x00")); ok {
+				readTIFF(bytes.NewReader(exif), int64(len(exif)), metadata)
+			}
+			continue
+		case kind >= 0xC0 && kind <= 0xCF && kind != 0xC4 && kind != 0xC8 && kind != 0xCC: // SOFn
+			var frame [5]byte
+			if _, err := io.ReadFull(r, frame[:]); err != nil {
+				return metadata, nil
+			}
+			metadata.Height = int(binary.BigEndian.Uint16(frame[1:3]))
+			metadata.Width = int(binary.BigEndian.Uint16(frame[3:5]))
+			length -= len(frame)
+		}
+		if _, err := r.Seek(int64(length), io.SeekCurrent); err != nil {
+			return metadata, nil
+		}
+	}
+}
+
+// readPNGMetadata reads the dimensions from IHDR and the EXIF of an eXIf chunk.
+func readPNGMetadata(r io.ReadSeeker) (*MediaMetadata, error) {
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, signature); err != nil || !bytes.Equal(signature, pngSignature) {
+		return nil, errors.New("not a PNG file")
+	}
+
+	metadata := &MediaMetadata{Kind: "image"}
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return metadata, nil
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		switch string(header[4:]) {
+		case "IHDR":
+			var ihdr [8]byte
+			if _, err := io.ReadFull(r, ihdr[:]); err != nil {
+				return nil, err
+			}
+			metadata.Width = int(binary.BigEndian.Uint32(ihdr[:4]))
+			metadata.Height = int(binary.BigEndian.Uint32(ihdr[4:]))
+			length -= int64(len(ihdr))
+		case "eXIf":
+			if length <= mediaMaxTagSize {
+				exif := make([]byte, length)
+				if _, err := io.ReadFull(r, exif); err != nil {
+					return metadata, nil
+				}
+				readTIFF(bytes.NewReader(exif), length, metadata)
+				length = 0
+			}
+		case "IDAT", "IEND":
+			// eXIf must come before the image data
+			return metadata, nil
+		}
+		if _, err := r.Seek(length+4, io.SeekCurrent); err != nil { // data left and CRC
+			return metadata, nil
+		}
+	}
+}
+
+// tiffReader reads the IFDs of a TIFF structure (a TIFF file or EXIF data).
+type tiffReader struct {
+	r     io.ReaderAt
+	size  int64
+	order binary.ByteOrder
+}
+
+type tiffEntry struct {
+	kind  uint16
+	count uint32
+	data  []byte
+}
+
+const (
+	tiffImageWidth   = 0x0100
+	tiffImageHeight  = 0x0101
+	tiffMake         = 0x010F
+	tiffModel        = 0x0110
+	tiffOrientation  = 0x0112
+	tiffDateTime     = 0x0132
+	tiffExifIFD      = 0x8769
+	tiffGPSIFD       = 0x8825
+	exifExposureTime = 0x829A
+	exifFNumber      = 0x829D
+	exifISO          = 0x8827
+	exifDateOriginal = 0x9003
+	exifOffsetTime   = 0x9011
+	exifFocalLength  = 0x920A
+	exifLensModel    = 0xA434
+	gpsLatitudeRef   = 0x0001
+	gpsLatitude      = 0x0002
+	gpsLongitudeRef  = 0x0003
+	gpsLongitude     = 0x0004
+	gpsAltitudeRef   = 0x0005
+	gpsAltitude      = 0x0006
+
+	// Offsets are checked, but a broken file could still chain many IFD entries.
+	tiffMaxEntries = 1000
+)
+
+// tiffTypeSizes are the byte sizes of the TIFF field types, by type number.
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// readTIFF fills metadata from the first IFD of a TIFF structure and its EXIF and GPS IFDs.
+func readTIFF(r io.ReaderAt, size int64, metadata *MediaMetadata) error {
+	var header [8]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return err
+	}
+	t := &tiffReader{r: r, size: size}
+	switch string(header[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return errors.New("invalid TIFF header")
+	}
+	if t.order.Uint16(header[2:4]) != 42 {
+		return errors.New("invalid TIFF header")
+	}
+
+	ifd0, err := t.readIFD(int64(t.order.Uint32(header[4:])))
+	if err != nil {
+		return err
+	}
+	if entry, ok := ifd0[tiffImageWidth]; ok {
+		metadata.Width = int(t.uint(entry, 0))
+	}
+	if entry, ok := ifd0[tiffImageHeight]; ok {
+		metadata.Height = int(t.uint(entry, 0))
+	}
+	metadata.CameraMake = tiffString(ifd0[tiffMake])
+	metadata.CameraModel = tiffString(ifd0[tiffModel])
+	if entry, ok := ifd0[tiffOrientation]; ok {
+		if orientation := int(t.uint(entry, 0)); orientation >= 1 && orientation <= 8 {
+			metadata.Orientation = orientation
+		}
+	}
+	captureTime := tiffString(ifd0[tiffDateTime])
+	offset := ""
+
+	if entry, ok := ifd0[tiffExifIFD]; ok {
+		if exif, err := t.readIFD(int64(t.uint(entry, 0))); err == nil {
+			if entry, ok := exif[exifExposureTime]; ok {
+				metadata.ExposureTime = formatExposureTime(t.rational(entry, 0))
+			}
+			if entry, ok := exif[exifFNumber]; ok {
+				metadata.FNumber = roundTo(ratio(t.rational(entry, 0)), 1)
+			}
+			if entry, ok := exif[exifISO]; ok {
+				metadata.ISO = int(t.uint(entry, 0))
+			}
+			if entry, ok := exif[exifFocalLength]; ok {
+				metadata.FocalLength = roundTo(ratio(t.rational(entry, 0)), 1)
+			}
+			metadata.LensModel = tiffString(exif[exifLensModel])
+			if original := tiffString(exif[exifDateOriginal]); original != "" {
+				captureTime = original
+				offset = tiffString(exif[exifOffsetTime])
+			}
+		}
+	}
+	metadata.CaptureTime = parseExifTime(captureTime, offset)
+
+	if entry, ok := ifd0[tiffGPSIFD]; ok {
+		if gps, err := t.readIFD(int64(t.uint(entry, 0))); err == nil {
+			metadata.Latitude = t.gpsCoordinate(gps[gpsLatitude], tiffString(gps[gpsLatitudeRef]), "S")
+			metadata.Longitude = t.gpsCoordinate(gps[gpsLongitude], tiffString(gps[gpsLongitudeRef]), "W")
+			if entry, ok := gps[gpsAltitude]; ok && entry.count >= 1 {
+				altitude := roundTo(ratio(t.rational(entry, 0)), 1)
+				if ref, ok := gps[gpsAltitudeRef]; ok && len(ref.data) > 0 && ref.data[0] == 1 {
+					altitude = -altitude // below sea level
+				}
+				metadata.Altitude = &altitude
+			}
+		}
+	}
+	return nil
+}
+
+func (t *tiffReader) readIFD(offset int64) (map[uint16]tiffEntry, error) {
+	var count [2]byte
+	if offset <= 0 || offset+2 > t.size {
+		return nil, errors.New("invalid IFD offset")
+	}
+	if _, err := t.r.ReadAt(count[:], offset); err != nil {
+		return nil, err
+	}
+	n := int(t.order.Uint16(count[:]))
+	if n > tiffMaxEntries {
+		return nil, errors.New("too many IFD entries")
+	}
+	raw := make([]byte, n*12)
+	if _, err := t.r.ReadAt(raw, offset+2); err != nil {
+		return nil, err
+	}
+
+	entries := map[uint16]tiffEntry{}
+	for i := 0; i < n; i++ {
+		field := raw[i*12 : (i+1)*12]
+		entry := tiffEntry{kind: t.order.Uint16(field[2:4]), count: t.order.Uint32(field[4:8])}
+		typeSize, ok := tiffTypeSizes[entry.kind]
+		if !ok {
+			continue
+		}
+		length := int64(typeSize) * int64(entry.count)
+		if length > mediaMaxTagSize {
+			continue
+		}
+		if length <= 4 {
+			entry.data = field[8 : 8+length]
+		} else {
+			valueOffset := int64(t.order.Uint32(field[8:12]))
+			if valueOffset+length > t.size {
+				continue
+			}
+			entry.data = make([]byte, length)
+			if _, err := t.r.ReadAt(entry.data, valueOffset); err != nil {
+				continue
+			}
+		}
+		entries[t.order.Uint16(field[:2])] = entry
+	}
+	return entries, nil
+}
+
+// uint returns the i-th value of a BYTE, SHORT or LONG entry.
+func (t *tiffReader) uint(entry tiffEntry, i int) uint32 {
+	switch entry.kind {
+	case 1, 7:
+		if i < len(entry.data) {
+			return uint32(entry.data[i])
+		}
+	case 3:
+		if 2*i+2 <= len(entry.data) {
+			return uint32(t.order.Uint16(entry.data[2*i:]))
+		}
+	case 4:
+		if 4*i+4 <= len(entry.data) {
+			return t.order.Uint32(entry.data[4*i:])
+		}
+	}
+	return 0
+}
+
+// rational returns the i-th value of a RATIONAL or SRATIONAL entry.
+func (t *tiffReader) rational(entry tiffEntry, i int) (int64, int64) {
+	if (entry.kind != 5 && entry.kind != 10) || 8*i+8 > len(entry.data) {
+		return 0, 0
+	}
+	numerator, denominator := t.order.Uint32(entry.data[8*i:]), t.order.Uint32(entry.data[8*i+4:])
+	if entry.kind == 10 {
+		return int64(int32(numerator)), int64(int32(denominator))
+	}
+	return int64(numerator), int64(denominator)
+}
+
+// gpsCoordinate converts degrees, minutes and seconds to signed decimal degrees.
+func (t *tiffReader) gpsCoordinate(entry tiffEntry, ref string, negativeRef string) *float64 {
+	if entry.count < 3 {
+		return nil
+	}
+	degrees := ratio(t.rational(entry, 0)) + ratio(t.rational(entry, 1))/60 + ratio(t.rational(entry, 2))/3600
+	if strings.EqualFold(ref, negativeRef) {
+		degrees = -degrees
+	}
+	degrees = roundTo(degrees, 6)
+	return &degrees
+}
+
+func tiffString(entry tiffEntry) string {
+	if entry.kind != 2 {
+		return ""
+	}
+	value, _, _ := strings.Cut(string(entry.data), "\x00")
+	return strings.TrimSpace(value)
+}
+
+// parseExifTime parses an EXIF date ("2006:01:02 15:04:05"), in the time zone of
+// offset ("+02:00") if known, else as local time like cameras record it.
+func parseExifTime(value string, offset string) *time.Time {
+	if value == "" || strings.HasPrefix(value, "0000") {
+		return nil
+	}
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", value+offset); err == nil {
+			return &t
+		}
+	}
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", value, time.Local)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+func formatExposureTime(numerator int64, denominator int64) string {
+	if numerator <= 0 || denominator <= 0 {
+		return ""
+	}
+	if numerator < denominator {
+		return fmt.Sprintf("1/%d", int64(math.Round(float64(denominator)/float64(numerator))))
+	}
+	return strconv.FormatFloat(roundTo(float64(numerator)/float64(denominator), 1), 'f', -1, 64)
+}
+
+func ratio(numerator int64, denominator int64) float64 {
+	if denominator == 0 {
+		return 0
+	}
+	return float64(numerator) / float64(denominator)
+}
+
+func roundTo(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
+
+// readID3 reads the ID3v2 tag at the start of an MP3 file, else the ID3v1 tag at its end.
+func readID3(r io.ReadSeeker, size int64) (*MediaMetadata, error) {
+	metadata := &MediaMetadata{Kind: "audio"}
+
+	var header [10]byte
+	if _, err := io.ReadFull(r, header[:]); err == nil && string(header[:3]) == "ID3" {
+		version := header[3]
+		tagSize := int64(syncsafe(header[6:10]))
+		if version >= 2 && version <= 4 && tagSize <= mediaMaxTagSize {
+			tag := make([]byte, tagSize)
+			if _, err := io.ReadFull(r, tag); err == nil {
+				if header[5]&0x40 != 0 && version >= 3 && len(tag) >= 4 {
+					// Skip the extended header
+					extended := int(binary.BigEndian.Uint32(tag[:4]))
+					if version == 4 {
+						extended = int(syncsafe(tag[:4]))
+					} else {
+						extended += 4
+					}
+					tag = tag[min(extended, len(tag)):]
+				}
+				readID3v2Frames(tag, version, metadata)
+				if metadata.Title != "" || metadata.Artist != "" || metadata.Album != "" {
+					return metadata, nil
+				}
+			}
+		}
+	}
+
+	if size < 128 {
+		return nil, errNoMetadata
+	}
+	var v1 [128]byte
+	if _, err := r.Seek(size-128, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, v1[:]); err != nil || string(v1[:3]) != "TAG" {
+		return nil, errNoMetadata
+	}
+	latin1 := func(b []byte) string {
+		value, _ := charmap.ISO8859_1.NewDecoder().Bytes(bytes.TrimRight(b, "\x00 "))
+		return strings.TrimSpace(string(value))
+	}
+	metadata.Title = latin1(v1[3:33])
+	metadata.Artist = latin1(v1[33:63])
+	metadata.Album = latin1(v1[63:93])
+	metadata.Year, _ = strconv.Atoi(latin1(v1[93:97]))
+	if v1[125] == 0 && v1[126] != 0 { // ID3v1.1 track number
+		metadata.Track = int(v1[126])
+	}
+	return metadata, nil
+}
+
+func readID3v2Frames(tag []byte, version byte, metadata *MediaMetadata) {
+	idSize, headerSize := 4, 10
+	if version == 2 {
+		idSize, headerSize = 3, 6
+	}
+	for len(tag) >= headerSize && tag[0] != 0 {
+		id := string(tag[:idSize])
+		var frameSize int
+		switch version {
+		case 2:
+			frameSize = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(tag[4:8]))
+		default:
+			frameSize = int(syncsafe(tag[4:8]))
+		}
+		if frameSize < 0 || headerSize+frameSize > len(tag) {
+			return
+		}
+		frame := tag[headerSize : headerSize+frameSize]
+		tag = tag[headerSize+frameSize:]
+
+		if !strings.HasPrefix(id, "T") || len(frame) < 1 {
+			continue
+		}
+		value := decodeID3Text(frame[0], frame[1:])
+		switch id {
+		case "TIT2", "TT2":
+			metadata.Title = value
+		case "TPE1", "TP1":
+			metadata.Artist = value
+		case "TALB", "TAL":
+			metadata.Album = value
+		case "TCON", "TCO":
+			metadata.Genre = id3Genre(value)
+		case "TYER", "TYE", "TDRC":
+			if len(value) >= 4 {
+				metadata.Year, _ = strconv.Atoi(value[:4])
+			}
+		case "TRCK", "TRK":
+			number, _, _ := strings.Cut(value, "/")
+			metadata.Track, _ = strconv.Atoi(number)
+		}
+	}
+}
+
+// decodeID3Text decodes a text frame, keeping its first value.
+func decodeID3Text(encoding byte, data []byte) string {
+	var value string
+	switch encoding {
+	case 1, 2: // UTF-16 with BOM, UTF-16BE
+		bigEndian := encoding == 2
+		if len(data) >= 2 && data[0] == 0xFE && data[1] == 0xFF {
+			bigEndian, data = true, data[2:]
+		} else if len(data) >= 2 && data[0] == 0xFF && data[1] == 0xFE {
+			bigEndian, data = false, data[2:]
+		}
+		units := make([]uint16, 0, len(data)/2)
+		for i := 0; i+1 < len(data); i += 2 {
+			unit := binary.LittleEndian.Uint16(data[i:])
+			if bigEndian {
+				unit = binary.BigEndian.Uint16(data[i:])
+			}
+			if unit == 0 {
+				break
+			}
+			units = append(units, unit)
+		}
+		value = string(utf16.Decode(units))
+	case 3: // UTF-8
+		value, _, _ = strings.Cut(string(data), "\x00")
+	default: // ISO-8859-1
+		data, _, _ = bytes.Cut(data, []byte{0})
+		decoded, _ := charmap.ISO8859_1.NewDecoder().Bytes(data)
+		value = string(decoded)
+	}
+	return strings.TrimSpace(value)
+}
+
+// id3Genre turns ID3v1 style references like "(17)" or "(17)Rock" into a name when it is given.
+func id3Genre(value string) string {
+	if strings.HasPrefix(value, "(") {
+		if end := strings.Index(value, ")"); end > 0 && end < len(value)-1 {
+			return strings.TrimSpace(value[end+1:])
+		}
+	}
+	return value
+}
+
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7F)<<21 | uint32(b[1]&0x7F)<<14 | uint32(b[2]&0x7F)<<7 | uint32(b[3]&0x7F)
+}
+
+// readFLACMetadata reads the STREAMINFO and VORBIS_COMMENT metadata blocks.
+func readFLACMetadata(r io.ReadSeeker) (*MediaMetadata, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil || string(magic[:]) != "fLaC" {
+		return nil, errors.New("not a FLAC file")
+	}
+
+	metadata := &MediaMetadata{Kind: "audio"}
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return metadata, nil
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+
+		switch {
+		case blockType == 0 && length >= 18: // STREAMINFO
+			var info [18]byte
+			if _, err := io.ReadFull(r, info[:]); err != nil {
+				return metadata, nil
+			}
+			sampleRate := int64(info[10])<<12 | int64(info[11])<<4 | int64(info[12])>>4
+			samples := int64(info[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(info[14:18]))
+			if sampleRate > 0 {
+				metadata.DurationSeconds = roundTo(float64(samples)/float64(sampleRate), 2)
+			}
+			length -= int64(len(info))
+		case blockType == 4 && length <= mediaMaxTagSize: // VORBIS_COMMENT
+			block := make([]byte, length)
+			if _, err := io.ReadFull(r, block); err != nil {
+				return metadata, nil
+			}
+			readVorbisComments(block, metadata)
+			length = 0
+		}
+		if last {
+			return metadata, nil
+		}
+		if _, err := r.Seek(length, io.SeekCurrent); err != nil {
+			return metadata, nil
+		}
+	}
+}
+
+// readOggMetadata reads the headers of an Ogg Vorbis or Opus stream, and the
+// granule position of the last page for the duration.
+func readOggMetadata(r io.ReadSeeker, size int64) (*MediaMetadata, error) {
+	packets, err := readOggPackets(r, 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(packets) < 2 {
+		return nil, errNoMetadata
+	}
+
+	metadata := &MediaMetadata{Kind: "audio"}
+	var sampleRate int64
+	var preSkip int64
+	switch {
+	case bytes.HasPrefix(packets[0], []byte("\x01vorbis")) && len(packets[0]) >= 16:
+		sampleRate = int64(binary.LittleEndian.Uint32(packets[0][12:16]))
+		if comments, ok := bytes.CutPrefix(packets[1], []byte("\x03vorbis")); ok {
+			readVorbisComments(comments, metadata)
+		}
+	case bytes.HasPrefix(packets[0], []byte("OpusHead")) && len(packets[0]) >= 12:
+		sampleRate = 48000 // Opus granule positions always count 48 kHz samples
+		preSkip = int64(binary.LittleEndian.Uint16(packets[0][10:12]))
+		if comments, ok := bytes.CutPrefix(packets[1], []byte("OpusTags")); ok {
+			readVorbisComments(comments, metadata)
+		}
+	default:
+		return nil, errNoMetadata
+	}
+
+	// The last page is within its maximum size from the end
+	tailSize := min(size, 65307)
+	tail := make([]byte, tailSize)
+	if _, err := r.Seek(size-tailSize, io.SeekStart); err == nil {
+		if _, err := io.ReadFull(r, tail); err == nil {
+			if i := bytes.LastIndex(tail, []byte("OggS")); i >= 0 && i+14 <= len(tail) && sampleRate > 0 {
+				granule := int64(binary.LittleEndian.Uint64(tail[i+6 : i+14]))
+				if granule > preSkip {
+					metadata.DurationSeconds = roundTo(float64(granule-preSkip)/float64(sampleRate), 2)
+				}
+			}
+		}
+	}
+	return metadata, nil
+}
+
+// readOggPackets reassembles the first count packets of the first logical stream.
+func readOggPackets(r io.Reader, count int) ([][]byte, error) {
+	var packets [][]byte
+	var current []byte
+	total := 0
+	for len(packets) < count {
+		var header [27]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return packets, nil
+		}
+		if string(header[:4]) != "OggS" {
+			return nil, errors.New("invalid Ogg page")
+		}
+		segments := make([]byte, header[26])
+		if _, err := io.ReadFull(r, segments); err != nil {
+			return packets, nil
+		}
+		for _, segment := range segments {
+			data := make([]byte, segment)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return packets, nil
+			}
+			total += len(data)
+			if total > mediaMaxTagSize {
+				return packets, nil
+			}
+			current = append(current, data...)
+			// A segment shorter than 255 bytes ends the packet
+			if segment < 255 {
+				packets = append(packets, current)
+				current = nil
+			}
+		}
+	}
+	return packets[:count], nil
+}
+
+// readVorbisComments reads a Vorbis comment structure (vendor, then KEY=value entries).
+func readVorbisComments(data []byte, metadata *MediaMetadata) {
+	next := func() ([]byte, bool) {
+		if len(data) < 4 {
+			return nil, false
+		}
+		length := int(binary.LittleEndian.Uint32(data[:4]))
+		if length < 0 || 4+length > len(data) {
+			return nil, false
+		}
+		value := data[4 : 4+length]
+		data = data[4+length:]
+		return value, true
+	}
+
+	if _, ok := next(); !ok { // vendor
+		return
+	}
+	if len(data) < 4 {
+		return
+	}
+	count := int(binary.LittleEndian.Uint32(data[:4]))
+	data = data[4:]
+	for i := 0; i < count; i++ {
+		comment, ok := next()
+		if !ok {
+			return
+		}
+		key, value, ok := strings.Cut(string(comment), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			metadata.Title = value
+		case "ARTIST":
+			metadata.Artist = value
+		case "ALBUM":
+			metadata.Album = value
+		case "GENRE":
+			metadata.Genre = value
+		case "DATE", "YEAR":
+			if len(value) >= 4 {
+				metadata.Year, _ = strconv.Atoi(value[:4])
+			}
+		case "TRACKNUMBER":
+			number, _, _ := strings.Cut(value, "/")
+			metadata.Track, _ = strconv.Atoi(number)
+		}
+	}
+}
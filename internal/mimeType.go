@@ -0,0 +1,331 @@
+package internal
+
+import (
+	"bufio"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/wailsapp/mimetype"
+)
+
+// MIME types are sniffed from the file content and fall back to the file name,
+// matched against the shared-mime-info globs (or Go's extension table where
+// shared-mime-info is not installed). Sniffing reads the start of the file, so
+// results are cached by path, size and modification time.
+
+const (
+	// Directories with more entries only get the name based MIME type in listings,
+	// the frontend sniffs the visible files with GetMimeTypes.
+	mimeSniffMaxEntries = 500
+	// The cache is dropped when it grows past this many paths.
+	mimeCacheMaxEntries = 20000
+
+	mimeTypeUnknown = "application/octet-stream"
+)
+
+type mimeCacheEntry struct {
+	size     int64
+	modified time.Time
+	mimeType string
+}
+
+var (
+	mimeCacheMu sync.Mutex
+	mimeCache   = map[string]mimeCacheEntry{}
+
+	mimeDatabaseOnce sync.Once
+	mimeDatabase     *sharedMimeDatabase // nil without shared-mime-info
+)
+
+// detectMimeType returns the MIME type of a local path, sniffing the content of regular files.
+func detectMimeType(path string) string {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return mimeTypeFromName(filepath.Base(path))
+	}
+	return mimeTypeOf(path, info, true)
+}
+
+// mimeTypeOf returns the MIME type of path, whose Lstat info is known.
+// Without sniff, a regular file not in the cache gets the name based type.
+func mimeTypeOf(path string, info fs.FileInfo, sniff bool) string {
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Stat(path)
+		if err != nil {
+			return "inode/symlink" // broken link
+		}
+		info = target
+	}
+
+	switch mode := info.Mode(); {
+	case mode.IsDir():
+		return "inode/directory"
+	case mode&fs.ModeNamedPipe != 0:
+		return "inode/fifo"
+	case mode&fs.ModeSocket != 0:
+		return "inode/socket"
+	case mode&fs.ModeCharDevice != 0:
+		return "inode/chardevice"
+	case mode&fs.ModeDevice != 0:
+		return "inode/blockdevice"
+	case !mode.IsRegular():
+		return mimeTypeUnknown
+	}
+
+	mimeCacheMu.Lock()
+	cached, ok := mimeCache[path]
+	mimeCacheMu.Unlock()
+	if ok && cached.size == info.Size() && cached.modified.Equal(info.ModTime()) {
+		return cached.mimeType
+	}
+
+	byName := mimeTypeFromName(info.Name())
+	if !sniff {
+		if byName == "" {
+			return mimeTypeUnknown
+		}
+		return byName
+	}
+
+	mimeType := sniffMimeType(path, info.Size(), byName)
+
+	mimeCacheMu.Lock()
+	if len(mimeCache) >= mimeCacheMaxEntries {
+		mimeCache = map[string]mimeCacheEntry{}
+	}
+	mimeCache[path] = mimeCacheEntry{size: info.Size(), modified: info.ModTime(), mimeType: mimeType}
+	mimeCacheMu.Unlock()
+
+	return mimeType
+}
+
+// sniffMimeType detects the type of a regular file from its content.
+// The name based type wins when the content only tells it is text or binary
+// data, e.g. Go source is text/plain to the sniffer and text/x-go by name.
+func sniffMimeType(path string, size int64, byName string) string {
+	if size == 0 {
+		if byName != "" {
+			return byName
+		}
+		return "application/x-zerosize"
+	}
+
+	sniffed, err := mimetype.DetectFile(path)
+	if err != nil {
+		if byName != "" {
+			return byName
+		}
+		return mimeTypeUnknown
+	}
+	mimeType, _, _ := strings.Cut(sniffed.String(), ";")
+
+	if byName == "" || byName == mimeType {
+		return mimeType
+	}
+	if mimeType == mimeTypeUnknown || isMimeSubclass(byName, mimeType) || (mimeType == "text/plain" && isTextMimeType(byName)) {
+		// Unknown binary data, or a generic format the name is more precise about:
+		// text/plain for source code, application/zip for OpenDocument, application/gzip for .tar.gz, ...
+		return byName
+	}
+	return mimeType
+}
+
+// isTextMimeType tells whether mimeType is text/plain or a subclass of it,
+// which all text/* types implicitly are.
+func isTextMimeType(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "text/") || isMimeSubclass(mimeType, "text/plain")
+}
+
+// isMimeSubclass tells whether child is a (possibly indirect) subclass of parent,
+// according to the sniffer's hierarchy or shared-mime-info.
+func isMimeSubclass(child string, parent string) bool {
+	if m := mimetype.Lookup(child); m != nil {
+		for m = m.Parent(); m != nil; m = m.Parent() {
+			if m.Is(parent) {
+				return true
+			}
+		}
+	}
+
+	db := loadedMimeDatabase()
+	if db == nil {
+		return false
+	}
+	seen := map[string]bool{}
+	pending := append([]string{}, db.parents[child]...)
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if current == parent {
+			return true
+		}
+		if !seen[current] {
+			seen[current] = true
+			pending = append(pending, db.parents[current]...)
+		}
+	}
+	return false
+}
+
+// mimeTypeFromName guesses a MIME type from a file name, "" if unknown.
+func mimeTypeFromName(name string) string {
+	if db := loadedMimeDatabase(); db != nil {
+		return db.match(name)
+	}
+	if mimeType := mime.TypeByExtension(filepath.Ext(name)); mimeType != "" {
+		mimeType, _, _ = strings.Cut(mimeType, ";")
+		return mimeType
+	}
+	return ""
+}
+
+// sharedMimeDatabase holds the shared-mime-info globs2 patterns and subclasses.
+type sharedMimeDatabase struct {
+	literals map[string]mimeGlob // whole file names, e.g. "Makefile"
+	suffixes map[string]mimeGlob // "*.ext" patterns by ".ext"
+	patterns []mimeGlob          // everything else, matched with filepath.Match
+	parents  map[string][]string // MIME type -> the types it is a subclass of
+}
+
+type mimeGlob struct {
+	pattern       string
+	mimeType      string
+	weight        int
+	caseSensitive bool
+}
+
+func loadedMimeDatabase() *sharedMimeDatabase {
+	mimeDatabaseOnce.Do(func() { mimeDatabase = loadSharedMimeDatabase() })
+	return mimeDatabase
+}
+
+// loadSharedMimeDatabase reads mime/globs2 and mime/subclasses from the XDG data
+// directories, returns nil if there are none.
+// Directories come in precedence order, the first definition of a pattern wins.
+func loadSharedMimeDatabase() *sharedMimeDatabase {
+	db := &sharedMimeDatabase{literals: map[string]mimeGlob{}, suffixes: map[string]mimeGlob{}, parents: map[string][]string{}}
+	found := false
+	seen := map[string]bool{}
+
+	for _, dir := range append([]string{xdg.DataHome}, xdg.DataDirs...) {
+		if subclasses, err := os.ReadFile(filepath.Join(dir, "mime", "subclasses")); err == nil {
+			for _, line := range strings.Split(string(subclasses), "\n") {
+				if child, parent, ok := strings.Cut(line, " "); ok {
+					db.parents[child] = append(db.parents[child], parent)
+				}
+			}
+		}
+
+		file, err := os.Open(filepath.Join(dir, "mime", "globs2"))
+		if err != nil {
+			continue
+		}
+		found = true
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			// weight:mimetype:glob[:flags[:...]]
+			fields := strings.Split(line, ":")
+			if len(fields) < 3 || fields[2] == "__NOGLOBS__" {
+				continue
+			}
+			weight, err := strconv.Atoi(fields[0])
+			if err != nil {
+				continue
+			}
+			glob := mimeGlob{pattern: fields[2], mimeType: fields[1], weight: weight}
+			if len(fields) > 3 {
+				glob.caseSensitive = strings.Contains(fields[3], "cs")
+			}
+			if !glob.caseSensitive {
+				glob.pattern = strings.ToLower(glob.pattern)
+			}
+			key := glob.pattern + "\x00" + glob.mimeType
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			db.add(glob)
+		}
+		file.Close()
+	}
+
+	if !found {
+		return nil
+	}
+	return db
+}
+
+func (db *sharedMimeDatabase) add(glob mimeGlob) {
+	keep := func(existing mimeGlob, ok bool) bool {
+		return !ok || glob.weight > existing.weight
+	}
+	switch {
+	case !strings.ContainsAny(glob.pattern, "*?["):
+		if existing, ok := db.literals[glob.pattern]; keep(existing, ok) {
+			db.literals[glob.pattern] = glob
+		}
+	case strings.HasPrefix(glob.pattern, "*.") && !strings.ContainsAny(glob.pattern[1:], "*?["):
+		if existing, ok := db.suffixes[glob.pattern[1:]]; keep(existing, ok) {
+			db.suffixes[glob.pattern[1:]] = glob
+		}
+	default:
+		db.patterns = append(db.patterns, glob)
+	}
+}
+
+// match follows the shared-mime-info order: literal names first, then the
+// highest weight, then the longest pattern.
+func (db *sharedMimeDatabase) match(name string) string {
+	lower := strings.ToLower(name)
+	if glob, ok := db.literals[name]; ok && glob.caseSensitive {
+		return glob.mimeType
+	}
+	if glob, ok := db.literals[lower]; ok && !glob.caseSensitive {
+		return glob.mimeType
+	}
+
+	var best *mimeGlob
+	consider := func(glob mimeGlob) {
+		if best == nil || glob.weight > best.weight || (glob.weight == best.weight && len(glob.pattern) > len(best.pattern)) {
+			best = &glob
+		}
+	}
+
+	for i := 0; i < len(name); i++ {
+		if name[i] != '.' {
+			continue
+		}
+		if glob, ok := db.suffixes[name[i:]]; ok && glob.caseSensitive {
+			consider(glob)
+		}
+		if glob, ok := db.suffixes[strings.ToLower(name[i:])]; ok && !glob.caseSensitive {
+			consider(glob)
+		}
+	}
+	for _, glob := range db.patterns {
+		candidate := lower
+		if glob.caseSensitive {
+			candidate = name
+		}
+		if matched, _ := filepath.Match(glob.pattern, candidate); matched {
+			consider(glob)
+		}
+	}
+
+	if best == nil {
+		return ""
+	}
+	return best.mimeType
+}
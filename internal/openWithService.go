@@ -0,0 +1,446 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/adrg/xdg"
+)
+
+// OpenWithService lists the applications able to open a file and launches them.
+// Applications come from the .desktop files in the XDG data directories and the
+// user's preferences from mimeapps.list, as described by the freedesktop
+// "Association between MIME types and applications" specification.
+type OpenWithService struct {
+	Terminal *TerminalService // optional, runs applications with Terminal=true
+}
+
+// DesktopApp is an installed application that can open files.
+type DesktopApp struct {
+	ID          string   `json:"id"` // desktop file ID, e.g. "org.gnome.gedit.desktop"
+	Name        string   `json:"name"`
+	GenericName string   `json:"genericName,omitempty"`
+	Comment     string   `json:"comment,omitempty"`
+	Icon        string   `json:"icon,omitempty"`
+	Terminal    bool     `json:"terminal,omitempty"`
+	MimeTypes   []string `json:"mimeTypes"`
+	IsDefault   bool     `json:"isDefault"`
+}
+
+// mimeAssociations is the merged content of every mimeapps.list.
+type mimeAssociations struct {
+	defaults map[string][]string        // mime type -> desktop IDs, in preference order
+	added    map[string][]string        // mime type -> desktop IDs
+	removed  map[string]map[string]bool // mime type -> desktop IDs
+}
+
+const (
+	mimeAppsDefaultGroup = "[Default Applications]"
+	mimeAppsAddedGroup   = "[Added Associations]"
+	mimeAppsRemovedGroup = "[Removed Associations]"
+)
+
+// GetApplicationsFor returns the applications that can open every given file,
+// the default application first.
+func (o *OpenWithService) GetApplicationsFor(paths []string) Result[[]DesktopApp] {
+	mimeTypes, appErr := mimeTypesOf(paths)
+	if appErr != nil {
+		return Result[[]DesktopApp]{Error: appErr}
+	}
+
+	entries := loadDesktopEntries()
+	associations := loadMimeAssociations()
+
+	// Keep the apps supporting every MIME type of the selection
+	var candidates []string
+	for i, mimeType := range mimeTypes {
+		ids := associations.appsFor(mimeType, entries)
+		if i == 0 {
+			candidates = ids
+			continue
+		}
+		candidates = slices.DeleteFunc(candidates, func(id string) bool { return !slices.Contains(ids, id) })
+	}
+
+	defaultID := ""
+	if len(mimeTypes) > 0 {
+		defaultID = associations.defaultFor(mimeTypes[0], entries)
+	}
+
+	apps := []DesktopApp{}
+	for _, id := range candidates {
+		entry := entries[id]
+		apps = append(apps, DesktopApp{
+			ID:          entry.ID,
+			Name:        entry.Name,
+			GenericName: entry.GenericName,
+			Comment:     entry.Comment,
+			Icon:        entry.Icon,
+			Terminal:    entry.Terminal,
+			MimeTypes:   entry.MimeTypes,
+			IsDefault:   entry.ID == defaultID,
+		})
+	}
+	slices.SortStableFunc(apps, func(a, b DesktopApp) int {
+		if a.IsDefault != b.IsDefault {
+			if a.IsDefault {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+
+	return Result[[]DesktopApp]{Data: &apps}
+}
+
+// OpenWith launches the application appID with the given files.
+func (o *OpenWithService) OpenWith(appID string, paths []string) Result[string] {
+	entry, ok := loadDesktopEntries()[appID]
+	if !ok {
+		return Result[string]{Error: &AppError{Code: ApplicationNotFoundError, Message: fmt.Sprintf("application %s is not installed", appID)}}
+	}
+
+	absPaths := make([]string, 0, len(paths))
+	for _, p := range paths {
+		pathResult := canonicalPath(p)
+		if pathResult.Error != nil {
+			return Result[string]{Error: pathResult.Error}
+		}
+		absPaths = append(absPaths, *pathResult.Data)
+	}
+
+	commands, err := entry.expandExec(absPaths)
+	if err != nil {
+		return Result[string]{Error: &AppError{Code: ApplicationLaunchError, Message: fmt.Sprintf("cannot launch %s: %v", entry.Name, err), InnerError: err}}
+	}
+
+	for _, argv := range commands {
+		// Console applications (vim, htop...) need a terminal around them
+		if entry.Terminal && o.Terminal != nil {
+			var appErr *AppError
+			if argv, appErr = o.Terminal.terminalCommand(entry.WorkDir, argv); appErr != nil {
+				return Result[string]{Error: appErr}
+			}
+		}
+
+		cmd := exec.Command(argv[0], argv[1:]...)
+		cmd.Dir = entry.WorkDir
+		if err := cmd.Start(); err != nil {
+			return Result[string]{Error: &AppError{
+				Code:       ApplicationLaunchError,
+				Message:    fmt.Sprintf("failed to launch %s: %v", entry.Name, err),
+				InnerError: err,
+			}}
+		}
+		// Reap the process when it exits, we don't wait for it
+		go cmd.Wait()
+	}
+
+	return Result[string]{Data: ptrString(fmt.Sprintf("Opened %d item(s) with %s", len(absPaths), entry.Name))}
+}
+
+// SetDefaultApplication makes appID the default application for mimeType
+// by writing the user's mimeapps.list.
+func (o *OpenWithService) SetDefaultApplication(mimeType string, appID string) Result[string] {
+	entry, ok := loadDesktopEntries()[appID]
+	if !ok {
+		return Result[string]{Error: &AppError{Code: ApplicationNotFoundError, Message: fmt.Sprintf("application %s is not installed", appID)}}
+	}
+	if !strings.Contains(mimeType, "/") {
+		return Result[string]{Error: &AppError{Code: MimeAssociationError, Message: fmt.Sprintf("invalid MIME type %q", mimeType)}}
+	}
+
+	path := filepath.Join(xdg.ConfigHome, "mimeapps.list")
+	if err := setMimeAppsDefault(path, mimeType, appID); err != nil {
+		return Result[string]{Error: &AppError{
+			Code:       MimeAssociationError,
+			Message:    fmt.Sprintf("failed to update %s: %v", path, err),
+			InnerError: err,
+		}}
+	}
+
+	return Result[string]{Data: ptrString(fmt.Sprintf("%s now opens %s files", entry.Name, mimeType))}
+}
+
+// GetMimeTypes returns the MIME type of each path, in order.
+func (o *OpenWithService) GetMimeTypes(paths []string) Result[[]string] {
+	var mimeTypes []string
+	for _, p := range paths {
+		pathResult := canonicalPath(p)
+		if pathResult.Error != nil {
+			return Result[[]string]{Error: pathResult.Error}
+		}
+		mimeTypes = append(mimeTypes, detectMimeType(*pathResult.Data))
+	}
+	return Result[[]string]{Data: &mimeTypes}
+}
+
+// mimeTypesOf returns the distinct MIME types of the given files.
+func mimeTypesOf(paths []string) ([]string, *AppError) {
+	var mimeTypes []string
+	for _, p := range paths {
+		pathResult := canonicalPath(p)
+		if pathResult.Error != nil {
+			return nil, pathResult.Error
+		}
+		if mimeType := detectMimeType(*pathResult.Data); !slices.Contains(mimeTypes, mimeType) {
+			mimeTypes = append(mimeTypes, mimeType)
+		}
+	}
+	return mimeTypes, nil
+}
+
+// loadDesktopEntries indexes the .desktop files by desktop file ID.
+// Directories come in precedence order, so the first file found for an ID wins.
+func loadDesktopEntries() map[string]*desktopEntry {
+	entries := map[string]*desktopEntry{}
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return entries
+	}
+
+	for _, dir := range xdg.ApplicationDirs {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(path, ".desktop") {
+				return nil
+			}
+			// The desktop file ID of applications/kde/foo.desktop is kde-foo.desktop
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return nil
+			}
+			id := strings.ReplaceAll(filepath.ToSlash(rel), "/", "-")
+			if _, seen := entries[id]; seen {
+				return nil
+			}
+
+			entry, err := parseDesktopEntry(path, id)
+			if err != nil {
+				// Remember the ID anyway so a broken user file still shadows the system one
+				entries[id] = nil
+				return nil
+			}
+			entries[id] = entry
+			return nil
+		})
+	}
+
+	for id, entry := range entries {
+		if entry == nil || entry.Hidden || entry.Exec == "" || !entry.tryExecOK() {
+			delete(entries, id)
+		}
+	}
+	return entries
+}
+
+// tryExecOK reports whether the TryExec program, if any, is installed.
+func (e *desktopEntry) tryExecOK() bool {
+	if e.TryExec == "" {
+		return true
+	}
+	_, err := exec.LookPath(e.TryExec)
+	return err == nil
+}
+
+// appsFor returns the installed applications for mimeType: user added associations
+// first, then the ones declaring it in their MimeType key, minus removed associations.
+func (m *mimeAssociations) appsFor(mimeType string, entries map[string]*desktopEntry) []string {
+	var ids []string
+	add := func(id string) {
+		if _, ok := entries[id]; ok && !m.removed[mimeType][id] && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	for _, id := range m.defaults[mimeType] {
+		add(id)
+	}
+	for _, id := range m.added[mimeType] {
+		add(id)
+	}
+
+	// Text files (source code, scripts, YAML, ...) can also be opened by plain text editors
+	fallbacks := []string{mimeType}
+	if mimeType != "text/plain" && isTextMimeType(mimeType) {
+		fallbacks = append(fallbacks, "text/plain")
+	}
+	var declared []string
+	for id, entry := range entries {
+		for _, t := range fallbacks {
+			if slices.Contains(entry.MimeTypes, t) {
+				declared = append(declared, id)
+				break
+			}
+		}
+	}
+	slices.Sort(declared)
+	for _, id := range declared {
+		add(id)
+	}
+	return ids
+}
+
+// defaultFor returns the default application for mimeType: the first installed
+// entry of [Default Applications], else the first associated application.
+func (m *mimeAssociations) defaultFor(mimeType string, entries map[string]*desktopEntry) string {
+	for _, id := range m.defaults[mimeType] {
+		if _, ok := entries[id]; ok {
+			return id
+		}
+	}
+	if ids := m.appsFor(mimeType, entries); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+// mimeAppsListPaths returns the mimeapps.list files in precedence order.
+func mimeAppsListPaths() []string {
+	var desktops []string
+	for _, desktop := range strings.Split(os.Getenv("XDG_CURRENT_DESKTOP"), ":") {
+		if desktop != "" {
+			desktops = append(desktops, strings.ToLower(desktop))
+		}
+	}
+
+	var paths []string
+	addDir := func(dir string) {
+		for _, desktop := range desktops {
+			paths = append(paths, filepath.Join(dir, desktop+"-mimeapps.list"))
+		}
+		paths = append(paths, filepath.Join(dir, "mimeapps.list"))
+	}
+
+	addDir(xdg.ConfigHome)
+	for _, dir := range xdg.ConfigDirs {
+		addDir(dir)
+	}
+	addDir(filepath.Join(xdg.DataHome, "applications"))
+	for _, dir := range xdg.DataDirs {
+		addDir(filepath.Join(dir, "applications"))
+	}
+	return paths
+}
+
+// loadMimeAssociations merges every mimeapps.list, earlier files take precedence.
+func loadMimeAssociations() *mimeAssociations {
+	m := &mimeAssociations{
+		defaults: map[string][]string{},
+		added:    map[string][]string{},
+		removed:  map[string]map[string]bool{},
+	}
+
+	for _, path := range mimeAppsListPaths() {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+
+		group := ""
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if strings.HasPrefix(line, "[") {
+				group = line
+				continue
+			}
+			mimeType, value, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			mimeType = strings.TrimSpace(mimeType)
+
+			for _, id := range strings.Split(value, ";") {
+				id = strings.TrimSpace(id)
+				if id == "" {
+					continue
+				}
+				switch group {
+				case mimeAppsDefaultGroup:
+					m.defaults[mimeType] = append(m.defaults[mimeType], id)
+				case mimeAppsAddedGroup:
+					// An association removed by a more important file stays removed
+					if !m.removed[mimeType][id] {
+						m.added[mimeType] = append(m.added[mimeType], id)
+					}
+				case mimeAppsRemovedGroup:
+					if m.removed[mimeType] == nil {
+						m.removed[mimeType] = map[string]bool{}
+					}
+					if !slices.Contains(m.added[mimeType], id) {
+						m.removed[mimeType][id] = true
+					}
+				}
+			}
+		}
+		file.Close()
+	}
+	return m
+}
+
+// setMimeAppsDefault sets mimeType=appID in the [Default Applications] group of the
+// mimeapps.list at path, keeping everything else in the file as is.
+func setMimeAppsDefault(path string, mimeType string, appID string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	}
+
+	groupStart, groupEnd, keyLine := -1, len(lines), -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			if groupStart >= 0 && groupEnd == len(lines) {
+				groupEnd = i
+			}
+			if trimmed == mimeAppsDefaultGroup {
+				groupStart, groupEnd = i, len(lines)
+			}
+			continue
+		}
+		if groupStart >= 0 && groupEnd == len(lines) {
+			if key, _, ok := strings.Cut(trimmed, "="); ok && strings.TrimSpace(key) == mimeType {
+				keyLine = i
+			}
+		}
+	}
+
+	entry := mimeType + "=" + appID + ";"
+	switch {
+	case keyLine >= 0:
+		lines[keyLine] = entry
+	case groupStart >= 0:
+		// Insert after the last non-empty line of the group
+		insertAt := groupEnd
+		for insertAt > groupStart+1 && strings.TrimSpace(lines[insertAt-1]) == "" {
+			insertAt--
+		}
+		lines = slices.Insert(lines, insertAt, entry)
+	default:
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, mimeAppsDefaultGroup, entry)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+}
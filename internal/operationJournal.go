@@ -0,0 +1,313 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Copies and moves are recorded in a journal (one file per operation under the
+// state directory) while they run. The journal of an operation that completes
+// is removed; one that was interrupted by a crash, a shutdown or an error stays
+// and can be resumed or rolled back (see ListIncompleteOperations).
+
+// OperationKind is the kind of a journaled file operation.
+type OperationKind string
+
+const (
+	OperationCopy OperationKind = "copy"
+	OperationMove OperationKind = "move"
+)
+
+// OperationItemState is the progress of one pasted item.
+type OperationItemState string
+
+const (
+	ItemPending  OperationItemState = "pending"  // not started, Dest is not known yet
+	ItemCopying  OperationItemState = "copying"  // Dest is being written (or Source renamed to it)
+	ItemRemoving OperationItemState = "removing" // moves across devices: Dest is complete, Source is being removed
+	ItemDone     OperationItemState = "done"
+	ItemSkipped  OperationItemState = "skipped" // left alone by the conflict policy
+)
+
+// Operation is a copy or move recorded in the journal.
+type Operation struct {
+	ID      string          `json:"id"`
+	Kind    OperationKind   `json:"kind"`
+	Target  string          `json:"target"`
+	Options CopyOptions     `json:"options"`
+	Policy  ConflictPolicy  `json:"conflictPolicy"` // applied again to the pending items on resume
+	Started time.Time       `json:"started"`
+	Items   []OperationItem `json:"items"`
+	Error   string          `json:"error,omitempty"` // why it stopped, when it failed rather than being interrupted
+}
+
+type OperationItem struct {
+	Source   string             `json:"source"`
+	Dest     string             `json:"dest,omitempty"` // after conflict resolution
+	State    OperationItemState `json:"state"`
+	Replaces bool               `json:"replaces,omitempty"` // Dest is an existing file the item is renamed over once complete
+}
+
+const (
+	operationJournalDir = "operations"
+	operationJournalExt = ".jsonl"
+)
+
+// operationJournal is the journal file of one operation: a JSON line with the
+// operation, then one line per change appended as the operation goes, so a
+// change costs the same whatever the number of items. Lines reach the disk with
+// the page cache, which survives a crash of lazydir; the file is synced only
+// before the sources of a move are removed, the one step a lost line could not undo.
+// Failing to write it never fails the operation, it only cannot be resumed.
+type operationJournal struct {
+	path   string
+	file   *os.File
+	op     Operation
+	copied map[string]bool // files completed under the destinations
+}
+
+// journalRecord is a line of a journal file.
+type journalRecord struct {
+	Operation *Operation     `json:"operation,omitempty"`
+	Item      int            `json:"item,omitempty"`
+	Update    *OperationItem `json:"update,omitempty"`
+	Copied    string         `json:"copied,omitempty"`
+	Error     *string        `json:"error,omitempty"`
+}
+
+func newOperationJournal(kind OperationKind, target string, sources []string, options CopyOptions, policy ConflictPolicy) *operationJournal {
+	op := Operation{
+		ID:      newUUID(),
+		Kind:    kind,
+		Target:  target,
+		Options: options,
+		Policy:  policy,
+		Started: time.Now(),
+		Items:   make([]OperationItem, len(sources)),
+	}
+	for i, source := range sources {
+		op.Items[i] = OperationItem{Source: source, State: ItemPending}
+	}
+	journal := &operationJournal{op: op, copied: map[string]bool{}}
+	if path, err := operationJournalPath(op.ID); err == nil {
+		journal.path = path
+	} else {
+		Log(fmt.Sprintf("operation journal unavailable: %v", err))
+	}
+	journal.write(journalRecord{Operation: &op})
+	return journal
+}
+
+func operationJournalPath(id string) (string, error) {
+	return stateFilePath(filepath.Join(operationJournalDir, id+operationJournalExt))
+}
+
+// update records the progress of an item.
+func (j *operationJournal) update(i int, source string, dest string, state OperationItemState) {
+	j.op.Items[i] = OperationItem{Source: source, Dest: dest, State: state, Replaces: j.op.Items[i].Replaces}
+	item := j.op.Items[i]
+	j.write(journalRecord{Item: i, Update: &item})
+}
+
+// fileCopied records a file completed under a destination.
+func (j *operationJournal) fileCopied(path string) {
+	j.copied[path] = true
+	j.write(journalRecord{Copied: path})
+}
+
+// wasCopied tells whether a previous run completed the file at path.
+func (j *operationJournal) wasCopied(path string) bool {
+	if !j.copied[path] {
+		return false
+	}
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+func (j *operationJournal) setError(message string) {
+	j.op.Error = message
+	j.write(journalRecord{Error: &message})
+}
+
+func (j *operationJournal) write(record journalRecord) {
+	if j.path == "" {
+		return
+	}
+	if j.file == nil {
+		file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			Log(fmt.Sprintf("failed to open the operation journal %s: %v", j.path, err))
+			j.path = ""
+			return
+		}
+		j.file = file
+	}
+	line, err := json.Marshal(record)
+	if err == nil {
+		_, err = j.file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		Log(fmt.Sprintf("failed to write the operation journal %s: %v", j.path, err))
+	}
+}
+
+// sync flushes the journal to disk before a step that depends on it.
+func (j *operationJournal) sync() {
+	if j.file != nil {
+		if err := j.file.Sync(); err != nil {
+			Log(fmt.Sprintf("failed to sync the operation journal %s: %v", j.path, err))
+		}
+	}
+}
+
+func (j *operationJournal) close() {
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+}
+
+// started reports whether an item was touched, only then is there something to resume or roll back.
+func (j *operationJournal) started() bool {
+	return slices.ContainsFunc(j.op.Items, func(item OperationItem) bool {
+		return item.State == ItemCopying || item.State == ItemRemoving || item.State == ItemDone
+	})
+}
+
+// finish removes the journal of a completed operation, or keeps it with the error that stopped it.
+func (j *operationJournal) finish(appErr *AppError) {
+	if appErr != nil && j.started() {
+		j.setError(appErr.Error())
+		j.sync()
+		j.close()
+		return
+	}
+	j.discard()
+}
+
+func (j *operationJournal) discard() {
+	j.close()
+	if j.path == "" {
+		return
+	}
+	if err := os.Remove(j.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		Log(fmt.Sprintf("failed to remove the operation journal %s: %v", j.path, err))
+	}
+}
+
+// loadOperationJournal reads the journal of an incomplete operation. A last
+// line cut by a crash is ignored.
+func loadOperationJournal(id string) (*operationJournal, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, fs.ErrNotExist
+	}
+	path, err := operationJournalPath(id)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	journal := &operationJournal{path: path, copied: map[string]bool{}}
+	decoder := json.NewDecoder(file)
+	var header journalRecord
+	if err := decoder.Decode(&header); err != nil || header.Operation == nil {
+		return nil, fmt.Errorf("parse %s: no operation", path)
+	}
+	journal.op = *header.Operation
+	for {
+		var record journalRecord
+		if err := decoder.Decode(&record); err != nil {
+			break
+		}
+		switch {
+		case record.Update != nil && record.Item >= 0 && record.Item < len(journal.op.Items):
+			journal.op.Items[record.Item] = *record.Update
+		case record.Copied != "":
+			journal.copied[record.Copied] = true
+		case record.Error != nil:
+			journal.op.Error = *record.Error
+		}
+	}
+	return journal, nil
+}
+
+// loadOperations reads every journal, oldest first. Unreadable journals are skipped.
+func loadOperations() ([]Operation, error) {
+	operations := []Operation{}
+	dir, err := stateFilePath(operationJournalDir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return operations, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), operationJournalExt)
+		if !ok || entry.IsDir() || strings.HasPrefix(id, ".") {
+			continue
+		}
+		journal, err := loadOperationJournal(id)
+		if err != nil {
+			Log(fmt.Sprintf("skipping the operation journal %s: %v", entry.Name(), err))
+			continue
+		}
+		operations = append(operations, journal.op)
+	}
+	slices.SortFunc(operations, func(a, b Operation) int { return a.Started.Compare(b.Started) })
+	return operations, nil
+}
+
+// removePartialCopies deletes the temporary files an interrupted copy left
+// for dest: next to it for a file, anywhere below it for a directory.
+func removePartialCopies(dest string) {
+	info, err := os.Lstat(dest)
+	if err == nil && info.IsDir() {
+		filepath.WalkDir(dest, func(path string, entry fs.DirEntry, err error) error {
+			if err == nil && !entry.IsDir() && isPartialCopyName(entry.Name(), "") {
+				os.Remove(path)
+			}
+			return nil
+		})
+		return
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(dest))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && isPartialCopyName(entry.Name(), filepath.Base(dest)) {
+			os.Remove(filepath.Join(filepath.Dir(dest), entry.Name()))
+		}
+	}
+}
+
+// isPartialCopyName tells whether name was made by partialCopyPath, for a
+// destination called base when it is not empty.
+func isPartialCopyName(name string, base string) bool {
+	rest, ok := strings.CutPrefix(name, partialCopyPrefix)
+	if !ok {
+		return false
+	}
+	rest, ok = strings.CutSuffix(rest, partialCopySuffix)
+	if !ok || len(rest) != 17 || rest[8] != '-' || strings.Trim(rest[:8]+rest[9:], "0123456789abcdef") != "" {
+		return false
+	}
+	return base == "" || rest[:8] == partialCopyTag(base)
+}
@@ -0,0 +1,311 @@
+package internal
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adrg/xdg"
+)
+
+// useTestStateDir keeps the journals of a test in a temporary state directory.
+func useTestStateDir(t *testing.T) {
+	t.Cleanup(xdg.Reload) // after the environment is restored
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	xdg.Reload()
+}
+
+var testTree = map[string]string{"a": "alpha", "b": "bravo", "sub/c": "charlie"}
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns the files below root by slash separated relative path.
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		rel, _ := filepath.Rel(root, path)
+		files[filepath.ToSlash(rel)] = string(content)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func checkTree(t *testing.T, root string, want map[string]string) {
+	t.Helper()
+	if got := readTree(t, root); !maps.Equal(got, want) {
+		t.Fatalf("%s contains %v, want %v", root, got, want)
+	}
+}
+
+func checkMissing(t *testing.T, path string) {
+	t.Helper()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("%s exists after the operation", path)
+	}
+}
+
+func checkNoIncompleteOperation(t *testing.T, f *FileManagerService) {
+	t.Helper()
+	result := f.ListIncompleteOperations()
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if len(*result.Data) != 0 {
+		t.Fatalf("incomplete operations left: %+v", *result.Data)
+	}
+}
+
+// interruptedOperation writes the journal of an operation stopped with its only
+// item in state, as a crash leaves it.
+func interruptedOperation(t *testing.T, kind OperationKind, source, dest string, state OperationItemState) *operationJournal {
+	t.Helper()
+	journal := newOperationJournal(kind, filepath.Dir(dest), []string{source}, CopyOptions{}, ConflictAsk)
+	journal.update(0, source, dest, state)
+	return journal
+}
+
+func TestIsPartialCopyName(t *testing.T) {
+	name := filepath.Base(partialCopyPath(filepath.Join("dir", "file.txt")))
+	tests := []struct {
+		name string
+		base string
+		want bool
+	}{
+		{name, "file.txt", true},
+		{name, "", true},
+		{name, "other.txt", false},
+		{".lazydir-0123abcd-89abcdef.part", "", true},
+		{".lazydir-0123ABCD-89abcdef.part", "", false},
+		{".lazydir-0123abcd89abcdef.part", "", false},
+		{".lazydir-0123abcd-89abcde.part", "", false},
+		{".lazydir-0123abcd-89abcdef.tmp", "", false},
+		{"lazydir-0123abcd-89abcdef.part", "", false},
+		{".file.txt.0123abcd.part", "", false},
+		{"notes.part", "", false},
+	}
+	for _, test := range tests {
+		if got := isPartialCopyName(test.name, test.base); got != test.want {
+			t.Errorf("isPartialCopyName(%q, %q) = %v, want %v", test.name, test.base, got, test.want)
+		}
+	}
+	if len(name) != len(filepath.Base(partialCopyPath(strings.Repeat("n", 250)))) {
+		t.Error("the length of partial copy names depends on the destination name")
+	}
+}
+
+func TestResumeCopyKeepsRecordedFiles(t *testing.T) {
+	useTestStateDir(t)
+	dir := t.TempDir()
+	source, dest := filepath.Join(dir, "tree"), filepath.Join(dir, "out", "tree")
+	writeTree(t, source, testTree)
+
+	// Interrupted while copying: a is recorded, b has the right size but is not,
+	// c was being written
+	journal := interruptedOperation(t, OperationCopy, source, dest, ItemCopying)
+	writeTree(t, dest, map[string]string{"a": "kept!", "b": "xxxxx"})
+	journal.fileCopied(filepath.Join(dest, "a"))
+	partial := partialCopyPath(filepath.Join(dest, "sub", "c"))
+	writeTree(t, dest, map[string]string{"sub/" + filepath.Base(partial): "char"})
+	journal.close()
+
+	f := &FileManagerService{}
+	list := f.ListIncompleteOperations()
+	if list.Error != nil || len(*list.Data) != 1 || (*list.Data)[0].Items[0].State != ItemCopying {
+		t.Fatalf("ListIncompleteOperations = %+v, want the interrupted copy", list)
+	}
+
+	if result := f.ResumeOperation(journal.op.ID); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	checkTree(t, dest, map[string]string{"a": "kept!", "b": "bravo", "sub/c": "charlie"})
+	checkTree(t, source, testTree)
+	checkNoIncompleteOperation(t, f)
+}
+
+func TestResumeMoveRenamedBeforeRecord(t *testing.T) {
+	useTestStateDir(t)
+	dir := t.TempDir()
+	source, dest := filepath.Join(dir, "tree"), filepath.Join(dir, "out", "tree")
+	writeTree(t, source, testTree)
+
+	journal := interruptedOperation(t, OperationMove, source, dest, ItemCopying)
+	os.MkdirAll(filepath.Dir(dest), 0o755)
+	if err := os.Rename(source, dest); err != nil {
+		t.Fatal(err)
+	}
+	journal.close()
+
+	f := &FileManagerService{}
+	if result := f.ResumeOperation(journal.op.ID); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	checkTree(t, dest, testTree)
+	checkMissing(t, source)
+	checkNoIncompleteOperation(t, f)
+}
+
+func TestResumeMoveRemovingSource(t *testing.T) {
+	useTestStateDir(t)
+	dir := t.TempDir()
+	source, dest := filepath.Join(dir, "tree"), filepath.Join(dir, "out", "tree")
+	writeTree(t, source, map[string]string{"b": "bravo"}) // a and sub are removed already
+	writeTree(t, dest, testTree)
+
+	journal := interruptedOperation(t, OperationMove, source, dest, ItemRemoving)
+	journal.close()
+
+	f := &FileManagerService{}
+	if result := f.ResumeOperation(journal.op.ID); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	checkTree(t, dest, testTree)
+	checkMissing(t, source)
+	checkNoIncompleteOperation(t, f)
+}
+
+func TestRollbackMoveRemovingSource(t *testing.T) {
+	useTestStateDir(t)
+	dir := t.TempDir()
+	source, dest := filepath.Join(dir, "tree"), filepath.Join(dir, "out", "tree")
+	writeTree(t, source, map[string]string{"b": "bravo"})
+	// The copy of b changed since: the original left at the source must win
+	writeTree(t, dest, map[string]string{"a": "alpha", "b": "changed", "sub/c": "charlie"})
+
+	journal := interruptedOperation(t, OperationMove, source, dest, ItemRemoving)
+	journal.close()
+
+	f := &FileManagerService{}
+	if result := f.RollbackOperation(journal.op.ID); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	checkTree(t, source, testTree)
+	checkMissing(t, dest)
+	checkNoIncompleteOperation(t, f)
+}
+
+func TestRollbackMoveAcrossDevices(t *testing.T) {
+	useTestStateDir(t)
+	dir := t.TempDir()
+	other, err := os.MkdirTemp("/dev/shm", "lazydir-test-")
+	if err != nil {
+		t.Skip("no /dev/shm to move to another device")
+	}
+	t.Cleanup(func() { os.RemoveAll(other) })
+	dirInfo, _ := os.Stat(dir)
+	otherInfo, _ := os.Stat(other)
+	if deviceOf(dirInfo) == deviceOf(otherInfo) {
+		t.Skip("/dev/shm is on the same device as the temporary directory")
+	}
+
+	source, dest := filepath.Join(dir, "tree"), filepath.Join(other, "tree")
+	writeTree(t, dest, testTree)
+
+	journal := interruptedOperation(t, OperationMove, source, dest, ItemDone)
+	journal.close()
+
+	f := &FileManagerService{}
+	if result := f.RollbackOperation(journal.op.ID); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	checkTree(t, source, testTree)
+	checkMissing(t, dest)
+	checkNoIncompleteOperation(t, f)
+}
+
+func TestRollbackCopy(t *testing.T) {
+	useTestStateDir(t)
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	writeTree(t, dir, map[string]string{"a": "alpha", "b": "bravo", "c": "charlie"})
+	// a was copied, b was replacing an existing file, c not started
+	writeTree(t, out, map[string]string{"a": "alpha", "b": "old"})
+
+	journal := newOperationJournal(OperationCopy, out, []string{filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "c")}, CopyOptions{}, ConflictOverwrite)
+	journal.update(0, filepath.Join(dir, "a"), filepath.Join(out, "a"), ItemDone)
+	journal.op.Items[1].Replaces = true
+	journal.update(1, filepath.Join(dir, "b"), filepath.Join(out, "b"), ItemCopying)
+	journal.close()
+
+	f := &FileManagerService{}
+	result := f.RollbackOperation(journal.op.ID)
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if !strings.HasPrefix(*result.Data, "Rolled back 2 item(s)") {
+		t.Fatalf("RollbackOperation = %q", *result.Data)
+	}
+	checkTree(t, out, map[string]string{"b": "old"})
+	checkNoIncompleteOperation(t, f)
+}
+
+func TestFailedCopyKeepsJournal(t *testing.T) {
+	useTestStateDir(t)
+	dir := t.TempDir()
+	source, out := filepath.Join(dir, "tree"), filepath.Join(dir, "out")
+	writeTree(t, source, testTree)
+	os.Mkdir(out, 0o755)
+
+	f := &FileManagerService{}
+	if result := f.CopyFiles(out, []string{source, filepath.Join(dir, "missing")}); result.Error == nil {
+		t.Fatal("CopyFiles of a missing file succeeded")
+	}
+
+	list := f.ListIncompleteOperations()
+	if list.Error != nil || len(*list.Data) != 1 {
+		t.Fatalf("ListIncompleteOperations = %+v, want the failed copy", list)
+	}
+	op := (*list.Data)[0]
+	if op.Error == "" || op.Items[0].State != ItemDone || op.Items[1].State != ItemPending {
+		t.Fatalf("journal of the failed copy = %+v", op)
+	}
+	journal, err := loadOperationJournal(op.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !journal.copied[filepath.Join(out, "tree", "sub", "c")] {
+		t.Fatalf("completed files not recorded: %v", journal.copied)
+	}
+
+	if result := f.RollbackOperation(op.ID); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	checkMissing(t, filepath.Join(out, "tree"))
+	checkNoIncompleteOperation(t, f)
+}
+
+func TestJournalIgnoresCutLine(t *testing.T) {
+	useTestStateDir(t)
+	journal := newOperationJournal(OperationCopy, "/target", []string{"/a", "/b"}, CopyOptions{}, ConflictAsk)
+	journal.update(0, "/a", "/target/a", ItemDone)
+	journal.file.WriteString(`{"item":1,"update":{"source":"/b","de`)
+	journal.close()
+
+	loaded, err := loadOperationJournal(journal.op.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.op.Items[0].State != ItemDone || loaded.op.Items[1].State != ItemPending {
+		t.Fatalf("loaded items = %+v", loaded.op.Items)
+	}
+}
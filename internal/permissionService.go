@@ -0,0 +1,339 @@
+package internal
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// PermissionService changes the permissions and ownership of files.
+// A failure on one item does not stop the others: they are all reported.
+type PermissionService struct{}
+
+type ChmodOptions struct {
+	// Mode is octal ("755", "2775") or symbolic like chmod ("u+x,go-w", "a=rX").
+	Mode string `json:"mode"`
+	// FileMode and DirMode replace Mode for files and directories when set,
+	// e.g. 644 for files and 755 for directories.
+	FileMode  string `json:"fileMode,omitempty"`
+	DirMode   string `json:"dirMode,omitempty"`
+	Recursive bool   `json:"recursive"` // symbolic links inside directories are left alone
+}
+
+type PermissionChanges struct {
+	Changed int                 `json:"changed"`
+	Failed  []PermissionFailure `json:"failed"`
+}
+
+type PermissionFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// modeChange computes the new mode of an item from its current one.
+type modeChange func(mode fs.FileMode, isDir bool) fs.FileMode
+
+// Chmod changes the permissions of paths.
+func (p *PermissionService) Chmod(paths []string, options ChmodOptions) Result[PermissionChanges] {
+	fileSpec, dirSpec := options.Mode, options.Mode
+	if options.FileMode != "" {
+		fileSpec = options.FileMode
+	}
+	if options.DirMode != "" {
+		dirSpec = options.DirMode
+	}
+
+	changes := map[string]modeChange{}
+	for _, spec := range []string{fileSpec, dirSpec} {
+		if spec == "" {
+			continue
+		}
+		change, err := parseModeChange(spec)
+		if err != nil {
+			return Result[PermissionChanges]{Error: &AppError{Code: PermissionError, Message: fmt.Sprintf("invalid mode %q: %v", spec, err), InnerError: err}}
+		}
+		changes[spec] = change
+	}
+	if len(changes) == 0 {
+		return Result[PermissionChanges]{Error: &AppError{Code: PermissionError, Message: "no mode given"}}
+	}
+
+	return applyPermissions(paths, options.Recursive, func(path string, info fs.FileInfo) error {
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return nil // links have no permissions of their own
+		}
+		spec := fileSpec
+		if info.IsDir() {
+			spec = dirSpec
+		}
+		change := changes[spec]
+		if change == nil {
+			return nil // only a mode for the other kind was given
+		}
+		mode := change(info.Mode(), info.IsDir())
+		if mode == info.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky) {
+			return nil
+		}
+		return os.Chmod(path, mode)
+	})
+}
+
+// Chown changes the owner and/or group of paths, given by name or numeric id.
+// An empty owner or group is left unchanged. Not available on Windows.
+func (p *PermissionService) Chown(paths []string, owner string, group string, recursive bool) Result[PermissionChanges] {
+	if runtime.GOOS == "windows" {
+		return Result[PermissionChanges]{Error: &AppError{Code: PermissionError, Message: "changing ownership is not supported on Windows"}}
+	}
+	if owner == "" && group == "" {
+		return Result[PermissionChanges]{Error: &AppError{Code: PermissionError, Message: "no owner or group given"}}
+	}
+
+	uid, gid := -1, -1
+	if owner != "" {
+		id, err := lookupID(owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return Result[PermissionChanges]{Error: &AppError{Code: PermissionError, Message: fmt.Sprintf("unknown user %q", owner), InnerError: err}}
+		}
+		uid = id
+	}
+	if group != "" {
+		id, err := lookupID(group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return Result[PermissionChanges]{Error: &AppError{Code: PermissionError, Message: fmt.Sprintf("unknown group %q", group), InnerError: err}}
+		}
+		gid = id
+	}
+
+	return applyPermissions(paths, recursive, func(path string, info fs.FileInfo) error {
+		// Links found while recursing are changed themselves, not their target
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return os.Lchown(path, uid, gid)
+		}
+		return os.Chown(path, uid, gid)
+	})
+}
+
+// lookupID resolves a user or group name to its id; numbers are ids already.
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	id, err := lookup(name)
+	if err != nil {
+		if n, convErr := strconv.Atoi(name); convErr == nil && n >= 0 {
+			return n, nil
+		}
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// applyPermissions calls apply on every path (and everything below with recursive)
+// and collects the failures. Top level symbolic links are followed.
+func applyPermissions(paths []string, recursive bool, apply func(path string, info fs.FileInfo) error) Result[PermissionChanges] {
+	changes := PermissionChanges{Failed: []PermissionFailure{}}
+	fail := func(path string, err error) {
+		changes.Failed = append(changes.Failed, PermissionFailure{Path: path, Error: err.Error()})
+	}
+
+	for _, p := range paths {
+		pathResult := canonicalPath(p)
+		if pathResult.Error != nil {
+			fail(p, pathResult.Error)
+			continue
+		}
+		root := *pathResult.Data
+
+		info, err := os.Stat(root)
+		if err != nil {
+			fail(root, err)
+			continue
+		}
+		if err := apply(root, info); err != nil {
+			fail(root, err)
+		} else {
+			changes.Changed++
+		}
+		if !recursive || !info.IsDir() {
+			continue
+		}
+
+		filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if path == root {
+				return nil
+			}
+			if err != nil {
+				fail(path, err)
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				fail(path, err)
+				return nil
+			}
+			if err := apply(path, info); err != nil {
+				fail(path, err)
+			} else {
+				changes.Changed++
+			}
+			return nil
+		})
+	}
+	return Result[PermissionChanges]{Data: &changes}
+}
+
+// parseModeChange parses an octal or symbolic chmod mode.
+func parseModeChange(spec string) (modeChange, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("empty mode")
+	}
+	if strings.Trim(spec, "01234567") == "" {
+		if len(spec) > 4 {
+			return nil, fmt.Errorf("octal mode has more than 4 digits")
+		}
+		bits, _ := strconv.ParseUint(spec, 8, 32)
+		return func(fs.FileMode, bool) fs.FileMode { return fileModeFromUnix(uint32(bits)) }, nil
+	}
+
+	type clause struct {
+		who    uint32 // mask of the classes changed
+		op     byte
+		perms  string // rwxXst, or a single class (ugo) to copy from
+		copied bool
+	}
+	var clauses []clause
+	for _, part := range strings.Split(spec, ",") {
+		i := 0
+		var who uint32
+		for ; i < len(part) && strings.IndexByte("ugoa", part[i]) >= 0; i++ {
+			switch part[i] {
+			case 'u':
+				who |= 0o4700
+			case 'g':
+				who |= 0o2070
+			case 'o':
+				who |= 0o1007
+			case 'a':
+				who |= 0o7777
+			}
+		}
+		if who == 0 {
+			who = 0o7777
+		}
+		if i == len(part) {
+			return nil, fmt.Errorf("missing operator in %q", part)
+		}
+		for i < len(part) {
+			op := part[i]
+			if op != '+' && op != '-' && op != '=' {
+				return nil, fmt.Errorf("unexpected %q in %q", part[i], part)
+			}
+			i++
+			start := i
+			for ; i < len(part) && strings.IndexByte("+-=", part[i]) < 0; i++ {
+			}
+			perms := part[start:i]
+			copied := len(perms) == 1 && strings.IndexByte("ugo", perms[0]) >= 0
+			if !copied && strings.Trim(perms, "rwxXst") != "" {
+				return nil, fmt.Errorf("invalid permissions %q", perms)
+			}
+			clauses = append(clauses, clause{who: who, op: op, perms: perms, copied: copied})
+		}
+	}
+
+	return func(mode fs.FileMode, isDir bool) fs.FileMode {
+		bits := unixModeBits(mode)
+		for _, c := range clauses {
+			var set uint32
+			if c.copied {
+				var shift uint // o
+				switch c.perms[0] {
+				case 'u':
+					shift = 6
+				case 'g':
+					shift = 3
+				}
+				set = (bits >> shift) & 7
+				set = set<<6 | set<<3 | set
+			} else {
+				for _, perm := range c.perms {
+					switch perm {
+					case 'r':
+						set |= 0o444
+					case 'w':
+						set |= 0o222
+					case 'x':
+						set |= 0o111
+					case 'X':
+						if isDir || bits&0o111 != 0 {
+							set |= 0o111
+						}
+					case 's':
+						set |= 0o6000
+					case 't':
+						set |= 0o1000
+					}
+				}
+			}
+			set &= c.who
+			switch c.op {
+			case '+':
+				bits |= set
+			case '-':
+				bits &^= set
+			case '=':
+				// = keeps the setuid/setgid bits of directories, as chmod does
+				cleared := c.who & 0o1777
+				if !isDir {
+					cleared = c.who
+				}
+				bits = bits&^cleared | set
+			}
+		}
+		return fileModeFromUnix(bits)
+	}, nil
+}
+
+// unixModeBits converts the permissions of a FileMode to the usual octal bits.
+func unixModeBits(mode fs.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		bits |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		bits |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		bits |= 0o1000
+	}
+	return bits
+}
+
+func fileModeFromUnix(bits uint32) fs.FileMode {
+	mode := fs.FileMode(bits & 0o777)
+	if bits&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if bits&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if bits&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
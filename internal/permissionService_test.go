@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"io/fs"
+	"testing"
+)
+
+func TestParseModeChange(t *testing.T) {
+	tests := []struct {
+		spec  string
+		mode  fs.FileMode
+		isDir bool
+		want  fs.FileMode
+	}{
+		{"755", 0o600, false, 0o755},
+		{"4750", 0o600, false, 0o750 | fs.ModeSetuid},
+		{"u+x,go-w", 0o666, false, 0o744},
+		{"u+x,go-w", 0o777, true, 0o755},
+		{"a=rX", 0o640, false, 0o444},
+		{"a=rX", 0o740, false, 0o555},
+		{"a=rX", 0o700, true, 0o555},
+		{"+x", 0o644, false, 0o755},
+		{"g+s", 0o755, true, 0o755 | fs.ModeSetgid},
+		{"g-s", 0o755 | fs.ModeSetgid, true, 0o755},
+		{"u+s,g+s", 0o755, false, 0o755 | fs.ModeSetuid | fs.ModeSetgid},
+		{"o+t", 0o777, true, 0o777 | fs.ModeSticky},
+		{"a=rx", 0o775 | fs.ModeSetgid, true, 0o555 | fs.ModeSetgid}, // = keeps setgid on directories
+		{"a=rx", 0o755 | fs.ModeSetuid, false, 0o555},
+		{"go=u", 0o640, false, 0o666},
+		{"u=g", 0o640, false, 0o440},
+		{"u+w-x", 0o555, false, 0o655},
+		{" o-rwx ", 0o777, false, 0o770},
+	}
+	for _, test := range tests {
+		change, err := parseModeChange(test.spec)
+		if err != nil {
+			t.Errorf("parseModeChange(%q) failed: %v", test.spec, err)
+			continue
+		}
+		if got := change(test.mode, test.isDir); got != test.want {
+			t.Errorf("parseModeChange(%q) on %v (directory: %v) = %v, want %v", test.spec, test.mode, test.isDir, got, test.want)
+		}
+	}
+}
+
+func TestParseModeChangeInvalid(t *testing.T) {
+	for _, spec := range []string{"", " ", "12345", "8", "u", "ug", "u!x", "x+r", "u+z", "u+gw", "u+x,", "+x,g"} {
+		if _, err := parseModeChange(spec); err == nil {
+			t.Errorf("parseModeChange(%q) succeeded", spec)
+		}
+	}
+}
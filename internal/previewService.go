@@ -0,0 +1,428 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// PreviewService builds the quick-look previews of the preview pane.
+// Every preview reads a bounded part of the file, so huge files are as fast as small ones.
+type PreviewService struct{}
+
+type PreviewKind string
+
+const (
+	PreviewText      PreviewKind = "text"
+	PreviewImage     PreviewKind = "image"
+	PreviewBinary    PreviewKind = "binary"
+	PreviewDirectory PreviewKind = "directory"
+	PreviewArchive   PreviewKind = "archive"
+)
+
+// Preview is the preview of one path. Only the field matching Kind is set.
+type Preview struct {
+	Path     string      `json:"path"`
+	Name     string      `json:"name"`
+	Kind     PreviewKind `json:"kind"`
+	MimeType string      `json:"mimeType"`
+	Size     int64       `json:"size"`
+	Modified time.Time   `json:"modified"`
+
+	Text      *TextPreview      `json:"text,omitempty"`
+	Image     *ImagePreview     `json:"image,omitempty"`
+	Binary    *BinaryPreview    `json:"binary,omitempty"`
+	Directory *DirectoryPreview `json:"directory,omitempty"`
+	Archive   *ArchivePreview   `json:"archive,omitempty"`
+}
+
+type TextPreview struct {
+	Encoding  string        `json:"encoding"` // "utf-8", "utf-16le", "utf-16be" or "windows-1252"
+	Lines     []PreviewLine `json:"lines"`
+	Truncated bool          `json:"truncated"` // the file goes on after the last line
+}
+
+type PreviewLine struct {
+	Number int    `json:"number"`
+	Text   string `json:"text"`
+}
+
+type ImagePreview struct {
+	Format string `json:"format"` // "jpeg", "png", "gif" or "webp"
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	URL    string `json:"url"` // scaled down image, served by ThumbnailMiddleware
+}
+
+type BinaryPreview struct {
+	HexDump   string `json:"hexDump"` // hexdump -C style
+	Truncated bool   `json:"truncated"`
+}
+
+type DirectoryPreview struct {
+	Entries   []PreviewEntry `json:"entries"` // directories first, then by name
+	DirCount  int            `json:"dirCount"`
+	FileCount int            `json:"fileCount"`
+	FilesSize int64          `json:"filesSize"` // direct files only
+	Truncated bool           `json:"truncated"` // too many entries, counts are partial
+}
+
+type PreviewEntry struct {
+	Name     string    `json:"name"`
+	IsDir    bool      `json:"isDir"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+type ArchivePreview struct {
+	Format    string         `json:"format"` // "zip", "tar", "tar.gz", "tar.bz2" or "gzip"
+	Members   []PreviewEntry `json:"members"`
+	Truncated bool           `json:"truncated"` // more members than listed
+}
+
+const (
+	previewTextBytes  = 64 * 1024
+	previewTextLines  = 2000
+	previewHexBytes   = 4 * 1024
+	previewDirEntries = 200
+	// Directories are not counted past this many entries.
+	previewDirScan = 10000
+	previewMembers = 1000
+	// Compressed tar archives are only read this far, their listing needs decompressing everything before.
+	previewArchiveScanBytes = 64 << 20
+)
+
+// archiveFormats maps archive MIME types to the formats listArchive reads.
+var archiveFormats = map[string]string{
+	"application/zip":                         "zip",
+	"application/java-archive":                "zip",
+	"application/vnd.android.package-archive": "zip",
+	"application/x-tar":                       "tar",
+	"application/x-compressed-tar":            "tar.gz",
+	"application/x-bzip-compressed-tar":       "tar.bz2",
+	"application/x-bzip2-compressed-tar":      "tar.bz2",
+	"application/gzip":                        "gzip",
+	"application/x-gzip":                      "gzip",
+}
+
+// GetPreview returns the preview of a file or directory.
+func (p *PreviewService) GetPreview(path string) Result[Preview] {
+	pathResult := canonicalPath(path)
+	if pathResult.Error != nil {
+		return Result[Preview]{Error: pathResult.Error}
+	}
+	absPath := *pathResult.Data
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return Result[Preview]{Error: &AppError{Code: FileInfoError, Message: fmt.Sprintf("get info for %q: %v", absPath, err), InnerError: err}}
+	}
+	preview := Preview{
+		Path:     absPath,
+		Name:     filepath.Base(absPath),
+		MimeType: detectMimeType(absPath),
+		Size:     info.Size(),
+		Modified: info.ModTime(),
+	}
+
+	if info.IsDir() {
+		preview.Kind = PreviewDirectory
+		preview.Directory, err = previewDirectory(absPath)
+	} else if !info.Mode().IsRegular() {
+		// Reading a fifo or a device could block forever, only describe it
+		preview.Kind = PreviewBinary
+		preview.Binary = &BinaryPreview{}
+	} else {
+		err = previewFile(absPath, &preview)
+	}
+	if err != nil {
+		return Result[Preview]{Error: &AppError{Code: PreviewError, Message: fmt.Sprintf("failed to preview %s: %v", absPath, err), InnerError: err}}
+	}
+	return Result[Preview]{Data: &preview}
+}
+
+func previewFile(path string, preview *Preview) error {
+	if format, ok := archiveFormats[preview.MimeType]; ok {
+		archive, err := listArchive(path, format)
+		if err == nil {
+			preview.Kind, preview.Archive = PreviewArchive, archive
+			return nil
+		}
+		// Damaged or misnamed archive, show its bytes
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if strings.HasPrefix(preview.MimeType, "image/") {
+		if config, format, err := image.DecodeConfig(file); err == nil {
+			preview.Kind = PreviewImage
+			preview.Image = &ImagePreview{
+				Format: format,
+				Width:  config.Width,
+				Height: config.Height,
+				URL:    ThumbnailRoute + "?size=large&path=" + url.QueryEscape(path),
+			}
+			return nil
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	head := make([]byte, previewTextBytes)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	head = head[:n]
+	truncated := preview.Size > int64(n)
+
+	if text, encodingName, ok := decodeText(head, truncated); ok {
+		preview.Kind = PreviewText
+		preview.Text = &TextPreview{Encoding: encodingName, Truncated: truncated}
+		preview.Text.Lines, preview.Text.Truncated = numberLines(text, truncated)
+		return nil
+	}
+
+	preview.Kind = PreviewBinary
+	dumped := head[:min(len(head), previewHexBytes)]
+	preview.Binary = &BinaryPreview{HexDump: hex.Dump(dumped), Truncated: preview.Size > int64(len(dumped))}
+	return nil
+}
+
+// decodeText detects the encoding of data and decodes it. ok is false for binary data.
+// truncated tells that data was cut, so it may end in the middle of a character.
+func decodeText(data []byte, truncated bool) (string, string, bool) {
+	var enc encoding.Encoding
+	name := ""
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return string(data[3:validUTF8Prefix(data)]), "utf-8", true
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		enc, name = unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM), "utf-16le"
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		enc, name = unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM), "utf-16be"
+	}
+	if enc != nil {
+		if truncated {
+			data = data[:len(data)&^1]
+		}
+		decoded, err := enc.NewDecoder().Bytes(data)
+		if err != nil {
+			return "", "", false
+		}
+		return string(decoded), name, true
+	}
+
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", "", false
+	}
+	if truncated {
+		data = data[:validUTF8Prefix(data)]
+	}
+	if utf8.Valid(data) {
+		return string(data), "utf-8", looksLikeText(string(data))
+	}
+	// Legacy 8-bit text, most likely Windows-1252 (a superset of Latin-1)
+	decoded, err := charmap.Windows1252.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", "", false
+	}
+	return string(decoded), "windows-1252", looksLikeText(string(decoded))
+}
+
+// looksLikeText rejects data with many control characters, like most binary formats.
+func looksLikeText(s string) bool {
+	control, total := 0, 0
+	for _, r := range s {
+		total++
+		if r < 0x20 && r != '\n' && r != '\r' && r != '\t' && r != '\f' && r != 0x1b {
+			control++
+		}
+	}
+	return control*100 <= total // at most 1%
+}
+
+// numberLines splits text in numbered lines. The last line of a truncated text
+// is dropped since it is probably incomplete.
+func numberLines(text string, truncated bool) ([]PreviewLine, bool) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else if truncated && len(lines) > 1 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > previewTextLines {
+		lines, truncated = lines[:previewTextLines], true
+	}
+
+	numbered := make([]PreviewLine, len(lines))
+	for i, line := range lines {
+		numbered[i] = PreviewLine{Number: i + 1, Text: line}
+	}
+	return numbered, truncated
+}
+
+func previewDirectory(path string) (*DirectoryPreview, error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	preview := &DirectoryPreview{Entries: []PreviewEntry{}}
+	scanned := 0
+	for scanned < previewDirScan {
+		entries, err := dir.ReadDir(min(1000, previewDirScan-scanned))
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				continue // removed meanwhile
+			}
+			if entry.IsDir() {
+				preview.DirCount++
+			} else {
+				preview.FileCount++
+				preview.FilesSize += info.Size()
+			}
+			preview.Entries = append(preview.Entries, PreviewEntry{Name: entry.Name(), IsDir: entry.IsDir(), Size: info.Size(), Modified: info.ModTime()})
+		}
+		scanned += len(entries)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if scanned >= previewDirScan {
+		if more, _ := dir.ReadDir(1); len(more) > 0 {
+			preview.Truncated = true
+		}
+	}
+
+	sortPreviewEntries(preview.Entries)
+	if len(preview.Entries) > previewDirEntries {
+		preview.Entries = preview.Entries[:previewDirEntries]
+		preview.Truncated = true
+	}
+	return preview, nil
+}
+
+func sortPreviewEntries(entries []PreviewEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir != entries[j].IsDir {
+			return entries[i].IsDir
+		}
+		return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
+	})
+}
+
+// listArchive lists the first members of an archive.
+func listArchive(path string, format string) (*ArchivePreview, error) {
+	preview := &ArchivePreview{Format: format, Members: []PreviewEntry{}}
+
+	if format == "zip" {
+		reader, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		for _, member := range reader.File {
+			if len(preview.Members) == previewMembers {
+				preview.Truncated = true
+				break
+			}
+			preview.Members = append(preview.Members, PreviewEntry{
+				Name:     member.Name,
+				IsDir:    member.FileInfo().IsDir(),
+				Size:     int64(member.UncompressedSize64),
+				Modified: member.Modified,
+			})
+		}
+		return preview, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if format != "tar" {
+		// Decompressing is needed to reach the next header, bound the work
+		limited := io.LimitReader(file, previewArchiveScanBytes)
+		switch format {
+		case "tar.gz", "gzip":
+			gz, err := gzip.NewReader(limited)
+			if err != nil {
+				return nil, err
+			}
+			defer gz.Close()
+			if format == "gzip" {
+				// A single compressed file, its name is in the header
+				name := gz.Name
+				if name == "" {
+					name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+				}
+				// The uncompressed size is unknown without decompressing
+				preview.Members = append(preview.Members, PreviewEntry{Name: name, Size: -1, Modified: gz.ModTime})
+				return preview, nil
+			}
+			reader = gz
+		case "tar.bz2":
+			reader = bzip2.NewReader(limited)
+		}
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if len(preview.Members) > 0 && (errors.Is(err, io.ErrUnexpectedEOF) || format != "tar") {
+				// Scan limit reached
+				preview.Truncated = true
+				break
+			}
+			return nil, err
+		}
+		if len(preview.Members) == previewMembers {
+			preview.Truncated = true
+			break
+		}
+		preview.Members = append(preview.Members, PreviewEntry{
+			Name:     header.Name,
+			IsDir:    header.Typeflag == tar.TypeDir,
+			Size:     header.Size,
+			Modified: header.ModTime,
+		})
+	}
+	return preview, nil
+}
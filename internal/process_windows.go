@@ -0,0 +1,19 @@
+//go:build windows
+
+package internal
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// startInProcessGroup is a no-op on Windows, taskkill /T finds the children.
+func startInProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessTree kills a process and its children.
+func killProcessTree(cmd *exec.Cmd) error {
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// PtyService backs the embedded terminal panel: it runs the user's shell in a
+// pseudo-terminal and relays its output and input over events and bindings.
+// Pseudo-terminals are only supported on Linux.
+type PtyService struct {
+	App *application.App // used to stream terminal output, may be nil
+
+	mu       sync.Mutex
+	sessions map[string]*ptySession
+}
+
+// PtyOutput is emitted (EventPtyOutput) with everything the shell prints.
+type PtyOutput struct {
+	ID   string `json:"id"`
+	Data string `json:"data"` // raw terminal output, escape sequences included
+}
+
+// PtyExit is emitted (EventPtyExit) when the shell exits.
+type PtyExit struct {
+	ID       string `json:"id"`
+	ExitCode int    `json:"exitCode"`
+}
+
+type ptySession struct {
+	id         string
+	master     *os.File
+	cmd        *exec.Cmd
+	dir        string
+	followPane bool   // cd along when the pane navigates
+	pendingDir string // cd waiting for the shell to have the terminal again
+}
+
+const (
+	EventPtyOutput = "pty:output"
+	EventPtyExit   = "pty:exit"
+
+	ptyReadBuffer = 32 * 1024
+)
+
+var errPtyUnsupported = errors.New("the embedded terminal is only available on Linux")
+
+// OpenPty starts the user's shell ($SHELL, else /bin/sh) in a pseudo-terminal
+// rooted at dirPath and returns the session id. followPane enables cd sync.
+func (p *PtyService) OpenPty(dirPath string, cols int, rows int, followPane bool) Result[string] {
+	pathResult := canonicalPath(dirPath)
+	if pathResult.Error != nil {
+		return Result[string]{Error: pathResult.Error}
+	}
+	dir := *pathResult.Data
+
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+
+	master, cmd, err := startPty(shell, dir, cols, rows)
+	if err != nil {
+		return Result[string]{Error: &AppError{Code: PtyError, Message: fmt.Sprintf("failed to start terminal: %v", err), InnerError: err}}
+	}
+
+	session := &ptySession{id: newUUID(), master: master, cmd: cmd, dir: dir, followPane: followPane}
+	p.mu.Lock()
+	if p.sessions == nil {
+		p.sessions = map[string]*ptySession{}
+	}
+	p.sessions[session.id] = session
+	p.mu.Unlock()
+
+	go p.relay(session)
+
+	return Result[string]{Data: &session.id}
+}
+
+// WritePty sends keyboard input to the shell.
+func (p *PtyService) WritePty(id string, data string) Result[string] {
+	session, appErr := p.session(id)
+	if appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+	if _, err := io.WriteString(session.master, data); err != nil {
+		return Result[string]{Error: &AppError{Code: PtyError, Message: fmt.Sprintf("failed to write to terminal: %v", err), InnerError: err}}
+	}
+	return Result[string]{Data: ptrString("ok")}
+}
+
+// ResizePty tells the shell the panel now has cols x rows cells.
+func (p *PtyService) ResizePty(id string, cols int, rows int) Result[string] {
+	session, appErr := p.session(id)
+	if appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+	if err := resizePty(session.master, cols, rows); err != nil {
+		return Result[string]{Error: &AppError{Code: PtyError, Message: fmt.Sprintf("failed to resize terminal: %v", err), InnerError: err}}
+	}
+	return Result[string]{Data: ptrString(fmt.Sprintf("%dx%d", cols, rows))}
+}
+
+// SetPtyFollowPane turns cd sync on or off.
+func (p *PtyService) SetPtyFollowPane(id string, follow bool) Result[string] {
+	session, appErr := p.session(id)
+	if appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+	p.mu.Lock()
+	session.followPane = follow
+	p.mu.Unlock()
+	return Result[string]{Data: ptrString("ok")}
+}
+
+// PaneNavigated is called by the frontend when the active pane changes directory.
+// If cd sync is on, a cd command is typed into the shell. While a program started
+// from the shell has the terminal, the command waits for the shell to be back.
+func (p *PtyService) PaneNavigated(id string, dirPath string) Result[string] {
+	session, appErr := p.session(id)
+	if appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+	pathResult := canonicalPath(dirPath)
+	if pathResult.Error != nil {
+		return Result[string]{Error: pathResult.Error}
+	}
+	dir := *pathResult.Data
+
+	p.mu.Lock()
+	follow := session.followPane && dir != session.dir
+	if follow {
+		session.dir = dir
+		session.pendingDir = dir
+	}
+	p.mu.Unlock()
+	if !follow {
+		return Result[string]{Data: &dir}
+	}
+
+	if err := p.sendPendingCd(session); err != nil {
+		return Result[string]{Error: &AppError{Code: PtyError, Message: fmt.Sprintf("failed to write to terminal: %v", err), InnerError: err}}
+	}
+	return Result[string]{Data: &dir}
+}
+
+// sendPendingCd types the pending cd command if the shell has the terminal.
+// Anything else in the foreground would take it as keystrokes.
+func (p *PtyService) sendPendingCd(session *ptySession) error {
+	p.mu.Lock()
+	dir := session.pendingDir
+	send := dir != "" && ptyShellInForeground(session.master, session.cmd)
+	if send {
+		session.pendingDir = ""
+	}
+	p.mu.Unlock()
+	if !send {
+		return nil
+	}
+	// The leading space keeps the command out of bash/zsh history (ignorespace)
+	_, err := io.WriteString(session.master, " cd -- "+shellQuote(dir)+"\n")
+	return err
+}
+
+// ClosePty hangs up the shell. Closing the master ends the relay even when
+// background jobs, in process groups of their own, keep the terminal open.
+func (p *PtyService) ClosePty(id string) Result[string] {
+	session, appErr := p.session(id)
+	if appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+	killErr := killProcessTree(session.cmd)
+	session.master.Close()
+	if killErr != nil {
+		return Result[string]{Error: &AppError{Code: PtyError, Message: fmt.Sprintf("failed to close terminal: %v", killErr), InnerError: killErr}}
+	}
+	return Result[string]{Data: ptrString("Terminal closed")}
+}
+
+// ServiceShutdown closes the terminals still open when lazydir exits.
+func (p *PtyService) ServiceShutdown() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, session := range p.sessions {
+		killProcessTree(session.cmd)
+		session.master.Close()
+	}
+	return nil
+}
+
+func (p *PtyService) session(id string) (*ptySession, *AppError) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	session, ok := p.sessions[id]
+	if !ok {
+		return nil, &AppError{Code: PtyError, Message: fmt.Sprintf("no terminal %s", id)}
+	}
+	return session, nil
+}
+
+// relay streams the terminal output until the shell exits.
+// Output is only cut on UTF-8 boundaries so the frontend never gets half a character.
+func (p *PtyService) relay(session *ptySession) {
+	buf := make([]byte, ptyReadBuffer)
+	pending := 0
+	for {
+		n, err := session.master.Read(buf[pending:])
+		if n > 0 {
+			end := pending + n
+			valid := validUTF8Prefix(buf[:end])
+			p.emit(EventPtyOutput, PtyOutput{ID: session.id, Data: string(buf[:valid])})
+			pending = copy(buf, buf[valid:end])
+			// Output follows the shell getting the terminal back, with its prompt
+			if err := p.sendPendingCd(session); err != nil {
+				Log(fmt.Sprintf("failed to write to terminal %s: %v", session.id, err))
+			}
+		}
+		// Reading a pty whose shell exited fails with EIO, a closed master with ErrClosed
+		if err != nil {
+			break
+		}
+	}
+
+	session.cmd.Wait()
+	session.master.Close()
+
+	p.mu.Lock()
+	delete(p.sessions, session.id)
+	p.mu.Unlock()
+
+	p.emit(EventPtyExit, PtyExit{ID: session.id, ExitCode: session.cmd.ProcessState.ExitCode()})
+}
+
+// validUTF8Prefix returns the length of b without a trailing incomplete UTF-8 sequence.
+func validUTF8Prefix(b []byte) int {
+	// A rune is at most 4 bytes, look for the start of the last one
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(b[i]) {
+			continue
+		}
+		if utf8.FullRune(b[i:]) {
+			return len(b)
+		}
+		return i
+	}
+	return len(b)
+}
+
+func (p *PtyService) emit(name string, data any) {
+	if p.App != nil {
+		p.App.Event.Emit(name, data)
+	}
+}
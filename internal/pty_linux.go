@@ -0,0 +1,96 @@
+//go:build linux
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// startPty opens a pseudo-terminal pair and starts shell on its slave side,
+// as the leader of a new session with the terminal as controlling tty.
+func startPty(shell string, dir string, cols int, rows int) (*os.File, *exec.Cmd, error) {
+	// Non-blocking, so the master goes through the poller and Close interrupts a Read
+	masterFd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open /dev/ptmx: %w", err)
+	}
+	master := os.NewFile(uintptr(masterFd), "/dev/ptmx")
+
+	// unlockpt + ptsname
+	if err := unix.IoctlSetPointerInt(masterFd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unlock pty: %w", err)
+	}
+	ptyNumber, err := unix.IoctlGetUint32(masterFd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("get pty number: %w", err)
+	}
+	slavePath := fmt.Sprintf("/dev/pts/%d", ptyNumber)
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("open %s: %w", slavePath, err)
+	}
+	defer slave.Close()
+
+	if err := resizePty(master, cols, rows); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	cmd := exec.Command(shell)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "TERM=xterm-256color", "COLORTERM=truecolor")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	// New session with the pty as controlling terminal (fd 0 in the child),
+	// so job control and Ctrl+C work. The session id doubles as process group for killProcessTree.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, cmd, nil
+}
+
+// resizePty sets the terminal size, the shell gets SIGWINCH.
+func resizePty(master *os.File, cols int, rows int) error {
+	if cols <= 0 || rows <= 0 {
+		return fmt.Errorf("invalid terminal size %dx%d", cols, rows)
+	}
+	return ptyControl(master, func(fd int) error {
+		return unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, &unix.Winsize{Col: uint16(cols), Row: uint16(rows)})
+	})
+}
+
+// ptyShellInForeground tells whether the shell has the terminal, rather than a
+// program it started (an editor, a pager, a running command).
+func ptyShellInForeground(master *os.File, cmd *exec.Cmd) bool {
+	var pgrp int
+	err := ptyControl(master, func(fd int) error {
+		var err error
+		pgrp, err = unix.IoctlGetInt(fd, unix.TIOCGPGRP)
+		return err
+	})
+	return err == nil && pgrp == cmd.Process.Pid
+}
+
+// ptyControl runs an ioctl on the master. master.Fd() would switch it back to
+// blocking mode, after which Close no longer interrupts the relay.
+func ptyControl(master *os.File, control func(fd int) error) error {
+	conn, err := master.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var controlErr error
+	if err := conn.Control(func(fd uintptr) { controlErr = control(int(fd)) }); err != nil {
+		return err
+	}
+	return controlErr
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package internal
+
+import (
+	"os"
+	"os/exec"
+)
+
+func startPty(shell string, dir string, cols int, rows int) (*os.File, *exec.Cmd, error) {
+	return nil, nil, errPtyUnsupported
+}
+
+func resizePty(master *os.File, cols int, rows int) error {
+	return errPtyUnsupported
+}
+
+func ptyShellInForeground(master *os.File, cmd *exec.Cmd) bool {
+	return false
+}
@@ -0,0 +1,377 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionService saves and restores the workspace layout (tabs, split panes,
+// paths, view and sort state) so it survives a restart. Several named sessions
+// can be kept side by side in the lazydir config directory.
+type SessionService struct {
+	mu sync.Mutex
+
+	// InitialPath is used for panes whose directory no longer exists, usually FileManagerService.GetInitialPath.
+	InitialPath func() Result[string]
+}
+
+// Session is a saved workspace layout.
+// It mirrors the frontend tabsStore (see frontend/src/types).
+type Session struct {
+	Version     int          `json:"version"`
+	Name        string       `json:"name"`
+	SavedAt     time.Time    `json:"savedAt"`
+	Tabs        []SessionTab `json:"tabs"`
+	ActiveTabID string       `json:"activeTabId,omitempty"`
+}
+
+type SessionTab struct {
+	ID              string        `json:"id"`
+	Panes           []SessionPane `json:"panes"`
+	SplitPercentage float64       `json:"splitPercentage,omitempty"`
+	ActivePaneID    string        `json:"activePaneId,omitempty"`
+}
+
+type SessionPane struct {
+	ID           string        `json:"id"`
+	Path         string        `json:"path"`
+	ViewMode     string        `json:"viewMode"`
+	Sorting      []SessionSort `json:"sorting"`
+	Active       bool          `json:"active"`
+	History      []string      `json:"history"`
+	HistoryIndex int           `json:"historyIndex"`
+
+	// Set on restore when Path no longer existed and was replaced by the initial path.
+	MissingPath string `json:"missingPath,omitempty"`
+}
+
+// SessionSort is one column of a tanstack SortingState.
+type SessionSort struct {
+	ID   string `json:"id"`
+	Desc bool   `json:"desc"`
+}
+
+// SessionSummary describes a saved session without its layout.
+type SessionSummary struct {
+	Name     string    `json:"name"`
+	SavedAt  time.Time `json:"savedAt"`
+	TabCount int       `json:"tabCount"`
+	Last     bool      `json:"last"` // the session restored on startup
+}
+
+// sessionsIndex remembers which session to restore on startup.
+type sessionsIndex struct {
+	Version     int    `json:"version"`
+	LastSession string `json:"lastSession"`
+}
+
+const (
+	// sessionSchemaVersion must be bumped (with a migration in migrateSession) whenever
+	// the Session layout changes in a non backward compatible way.
+	sessionSchemaVersion = 1
+	sessionsDirName      = "sessions"
+	sessionsIndexName    = "sessions.json"
+	DefaultSessionName   = "default"
+)
+
+var sessionNamePattern = regexp.MustCompile(`^[\p{L}\p{N}_\- ]{1,64}$`)
+
+// SaveSession stores the layout under name (DefaultSessionName if empty) and
+// makes it the session restored on next startup.
+func (s *SessionService) SaveSession(name string, session Session) Result[string] {
+	name, appErr := normalizeSessionName(name)
+	if appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+
+	session.Version = sessionSchemaVersion
+	session.Name = name
+	session.SavedAt = time.Now()
+	for i := range session.Tabs {
+		for j := range session.Tabs[i].Panes {
+			session.Tabs[i].Panes[j].MissingPath = ""
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, err := sessionFilePath(name)
+	if err != nil {
+		return Result[string]{Error: sessionStoreError("failed to resolve session file", err)}
+	}
+	if err := writeJSONFileAtomic(path, session); err != nil {
+		return Result[string]{Error: sessionStoreError(fmt.Sprintf("failed to save session %q", name), err)}
+	}
+	if appErr := setLastSession(name); appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+
+	return Result[string]{Data: ptrString(fmt.Sprintf("Saved session %s", name))}
+}
+
+// LoadSession reads a named session and makes it the one restored on next startup.
+// Panes pointing to directories that no longer exist are moved to the initial path.
+func (s *SessionService) LoadSession(name string) Result[Session] {
+	name, appErr := normalizeSessionName(name)
+	if appErr != nil {
+		return Result[Session]{Error: appErr}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, found, appErr := readSession(name)
+	if appErr != nil {
+		return Result[Session]{Error: appErr}
+	}
+	if !found {
+		return Result[Session]{Error: &AppError{Code: SessionNotFoundError, Message: fmt.Sprintf("no session named %q", name)}}
+	}
+	if appErr := setLastSession(name); appErr != nil {
+		return Result[Session]{Error: appErr}
+	}
+
+	s.repair(session)
+	return Result[Session]{Data: session}
+}
+
+// RestoreLastSession is called by the frontend on startup. It returns the last
+// saved or loaded session, or a fresh single-tab layout if there is none.
+func (s *SessionService) RestoreLastSession() Result[Session] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := DefaultSessionName
+	var index sessionsIndex
+	if path, err := configFilePath(sessionsIndexName); err == nil {
+		if _, err := readJSONFile(path, &index); err != nil {
+			Log(fmt.Sprintf("RestoreLastSession: %v", err))
+		}
+	}
+	if index.LastSession != "" {
+		name = index.LastSession
+	}
+
+	session, found, appErr := readSession(name)
+	if appErr != nil {
+		// Don't lock the user out of the app because of a broken session file.
+		Log(fmt.Sprintf("RestoreLastSession: %v", appErr))
+		found = false
+	}
+	if !found {
+		session = &Session{Version: sessionSchemaVersion, Name: name}
+	}
+
+	s.repair(session)
+	return Result[Session]{Data: session}
+}
+
+// ListSessions returns the saved sessions sorted by name.
+func (s *SessionService) ListSessions() Result[[]SessionSummary] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir, err := configFilePath(sessionsDirName)
+	if err != nil {
+		return Result[[]SessionSummary]{Error: sessionStoreError("failed to resolve sessions directory", err)}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Result[[]SessionSummary]{Error: sessionStoreError("failed to list sessions", err)}
+	}
+
+	var index sessionsIndex
+	if path, err := configFilePath(sessionsIndexName); err == nil {
+		readJSONFile(path, &index)
+	}
+
+	summaries := []SessionSummary{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok || !sessionNamePattern.MatchString(name) {
+			continue
+		}
+		session, found, appErr := readSession(name)
+		if appErr != nil || !found {
+			continue
+		}
+		summaries = append(summaries, SessionSummary{
+			Name:     name,
+			SavedAt:  session.SavedAt,
+			TabCount: len(session.Tabs),
+			Last:     name == index.LastSession,
+		})
+	}
+	slices.SortFunc(summaries, func(a, b SessionSummary) int { return strings.Compare(a.Name, b.Name) })
+
+	return Result[[]SessionSummary]{Data: &summaries}
+}
+
+// DeleteSession removes a saved session.
+func (s *SessionService) DeleteSession(name string) Result[string] {
+	name, appErr := normalizeSessionName(name)
+	if appErr != nil {
+		return Result[string]{Error: appErr}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, err := sessionFilePath(name)
+	if err != nil {
+		return Result[string]{Error: sessionStoreError("failed to resolve session file", err)}
+	}
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Result[string]{Error: &AppError{Code: SessionNotFoundError, Message: fmt.Sprintf("no session named %q", name)}}
+		}
+		return Result[string]{Error: sessionStoreError(fmt.Sprintf("failed to delete session %q", name), err)}
+	}
+
+	return Result[string]{Data: ptrString(fmt.Sprintf("Deleted session %s", name))}
+}
+
+// repair makes a session safe to display: missing directories are replaced by
+// the initial path, out of range indexes are clamped and there is at least one tab.
+func (s *SessionService) repair(session *Session) {
+	fallback := s.fallbackPath()
+
+	for i := range session.Tabs {
+		tab := &session.Tabs[i]
+		if tab.ID == "" {
+			tab.ID = newUUID()
+		}
+		if len(tab.Panes) == 0 {
+			tab.Panes = []SessionPane{newSessionPane(fallback)}
+		}
+		for j := range tab.Panes {
+			pane := &tab.Panes[j]
+			if pane.ID == "" {
+				pane.ID = newUUID()
+			}
+			if info, err := os.Stat(pane.Path); pane.Path == "" || err != nil || !info.IsDir() {
+				pane.MissingPath = pane.Path
+				pane.Path = fallback
+				pane.History = nil
+			}
+			if len(pane.History) == 0 {
+				pane.History = []string{pane.Path}
+				pane.HistoryIndex = 0
+			}
+			pane.HistoryIndex = max(0, min(pane.HistoryIndex, len(pane.History)-1))
+			if pane.ViewMode == "" {
+				pane.ViewMode = "list"
+			}
+			if pane.Sorting == nil {
+				pane.Sorting = []SessionSort{}
+			}
+		}
+		if !slices.ContainsFunc(tab.Panes, func(p SessionPane) bool { return p.ID == tab.ActivePaneID }) {
+			tab.ActivePaneID = tab.Panes[0].ID
+		}
+	}
+
+	if len(session.Tabs) == 0 {
+		pane := newSessionPane(fallback)
+		session.Tabs = []SessionTab{{ID: newUUID(), Panes: []SessionPane{pane}, ActivePaneID: pane.ID}}
+	}
+	if !slices.ContainsFunc(session.Tabs, func(t SessionTab) bool { return t.ID == session.ActiveTabID }) {
+		session.ActiveTabID = session.Tabs[0].ID
+	}
+}
+
+func (s *SessionService) fallbackPath() string {
+	if s.InitialPath != nil {
+		if result := s.InitialPath(); result.Error == nil {
+			return *result.Data
+		}
+	}
+	return string(os.PathSeparator)
+}
+
+func newSessionPane(path string) SessionPane {
+	return SessionPane{
+		ID:       newUUID(),
+		Path:     path,
+		ViewMode: "list",
+		Sorting:  []SessionSort{},
+		Active:   true,
+		History:  []string{path},
+	}
+}
+
+// readSession loads and migrates a session file. found is false if it doesn't exist.
+func readSession(name string) (*Session, bool, *AppError) {
+	path, err := sessionFilePath(name)
+	if err != nil {
+		return nil, false, sessionStoreError("failed to resolve session file", err)
+	}
+
+	var session Session
+	found, err := readJSONFile(path, &session)
+	if err != nil {
+		return nil, found, sessionStoreError(fmt.Sprintf("failed to read session %q", name), err)
+	}
+	if !found {
+		return nil, false, nil
+	}
+	session.Name = name
+	if appErr := migrateSession(&session); appErr != nil {
+		return nil, true, appErr
+	}
+	return &session, true, nil
+}
+
+// migrateSession upgrades a session read from disk to sessionSchemaVersion.
+func migrateSession(session *Session) *AppError {
+	if session.Version > sessionSchemaVersion {
+		return &AppError{
+			Code:    SessionVersionError,
+			Message: fmt.Sprintf("session %q was saved by a newer lazydir (schema %d, supported %d)", session.Name, session.Version, sessionSchemaVersion),
+		}
+	}
+	// Version 0 (no version field) has the same layout as version 1.
+	session.Version = sessionSchemaVersion
+	return nil
+}
+
+func setLastSession(name string) *AppError {
+	path, err := configFilePath(sessionsIndexName)
+	if err != nil {
+		return sessionStoreError("failed to resolve sessions index", err)
+	}
+	if err := writeJSONFileAtomic(path, sessionsIndex{Version: sessionSchemaVersion, LastSession: name}); err != nil {
+		return sessionStoreError("failed to save sessions index", err)
+	}
+	return nil
+}
+
+func sessionFilePath(name string) (string, error) {
+	return configFilePath(filepath.Join(sessionsDirName, name+".json"))
+}
+
+func normalizeSessionName(name string) (string, *AppError) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return DefaultSessionName, nil
+	}
+	if !sessionNamePattern.MatchString(name) {
+		return "", &AppError{
+			Code:    SessionStoreError,
+			Message: fmt.Sprintf("invalid session name %q: use letters, digits, spaces, '-' or '_' (max 64)", name),
+		}
+	}
+	return name, nil
+}
+
+func sessionStoreError(message string, err error) *AppError {
+	return &AppError{Code: SessionStoreError, Message: fmt.Sprintf("%s: %v", message, err), InnerError: err}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package internal
+
+import (
+	"context"
+	"os/exec"
+)
+
+// shellCommand runs command through sh.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// shellArgument quotes value as a single argument of a shellCommand.
+func shellArgument(value string, env *[]string) string {
+	return shellQuote(value)
+}
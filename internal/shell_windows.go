@@ -0,0 +1,34 @@
+//go:build windows
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// shellCommand runs command through cmd.exe. cmd parses its command line itself
+// and knows nothing of the \" escapes Go adds for C programs, so the line is
+// passed as is: /S makes cmd strip the outer quotes and leave the rest alone,
+// /V:OFF keeps ! literal.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	shell := os.Getenv("ComSpec")
+	if shell == "" {
+		shell = "cmd.exe"
+	}
+	cmd := exec.CommandContext(ctx, shell)
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: `cmd /V:OFF /S /C "` + command + `"`}
+	return cmd
+}
+
+// shellArgument passes value to a shellCommand through an environment variable
+// added to env. No quoting stops cmd from expanding %VAR% in a path, but the
+// value of a variable is not expanded again.
+func shellArgument(value string, env *[]string) string {
+	name := fmt.Sprintf("LAZYDIR_ARG%d", len(*env))
+	*env = append(*env, name+"="+value)
+	return `"%` + name + `%"`
+}
@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/adrg/xdg"
+)
+
+// appDirName is the folder lazydir uses under the XDG base directories.
+const appDirName = "lazydir"
+
+// configFilePath returns the path of a file in lazydir's config directory
+// (e.g. ~/.config/lazydir/<name>), creating the directories if needed.
+func configFilePath(name string) (string, error) {
+	return appFilePath(xdg.ConfigHome, name)
+}
+
+// stateFilePath returns the path of a file in lazydir's state directory
+// (e.g. ~/.local/state/lazydir/<name>), for data that is not user configuration.
+func stateFilePath(name string) (string, error) {
+	return appFilePath(xdg.StateHome, name)
+}
+
+// appFilePath joins name (which may contain subdirectories) to lazydir's folder
+// under base and makes sure the parent directory exists.
+func appFilePath(base string, name string) (string, error) {
+	path := filepath.Join(base, appDirName, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// readJSONFile decodes the JSON file at path into v.
+// A missing file is not an error: found is false and v is left untouched.
+func readJSONFile(path string, v any) (found bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return true, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// writeJSONFileAtomic encodes v as indented JSON and writes it to path.
+func writeJSONFileAtomic(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0o644)
+}
+
+// writeFileAtomic writes data to a temporary file next to path, syncs it and
+// renames it over path, so readers never observe a half-written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,522 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// SyncService synchronizes two folders. PlanSync compares them and returns the
+// actions to take without touching anything; RunSync executes a plan in the
+// background with the same copy and trash helpers as the file operations,
+// reporting EventSyncProgress and EventSyncDone. CancelSync stops it.
+type SyncService struct {
+	App    *application.App    // used to report progress, may be nil
+	Files  *FileManagerService // deletions go to the trash through its file operations
+	Config *ConfigService      // metadata to preserve in copies
+
+	mu   sync.Mutex
+	jobs map[string]context.CancelFunc
+}
+
+type SyncMode string
+
+const (
+	// SyncMirror makes right a copy of left, Delete also removes what left does not have.
+	SyncMirror SyncMode = "mirror"
+	// SyncUpdate copies new and newer files from left to right, never overwriting a newer file.
+	SyncUpdate SyncMode = "update"
+	// SyncBidirectional propagates changes both ways. The state of the last sync
+	// tells deletions from additions; files changed on both sides are conflicts.
+	SyncBidirectional SyncMode = "bidirectional"
+)
+
+type SyncOptions struct {
+	Mode           SyncMode `json:"mode"`
+	Delete         bool     `json:"delete"` // mirror only
+	CompareContent bool     `json:"compareContent"`
+	TimeTolerance  int      `json:"timeTolerance"` // seconds, see CompareOptions
+}
+
+type SyncActionKind string
+
+const (
+	SyncCopyToRight SyncActionKind = "copyToRight"
+	SyncCopyToLeft  SyncActionKind = "copyToLeft"
+	SyncDeleteRight SyncActionKind = "deleteRight"
+	SyncDeleteLeft  SyncActionKind = "deleteLeft"
+	SyncConflict    SyncActionKind = "conflict" // reported only, left alone
+)
+
+type SyncAction struct {
+	Kind   SyncActionKind `json:"kind"`
+	Path   string         `json:"path"` // relative to both folders
+	IsDir  bool           `json:"isDir"`
+	Size   int64          `json:"size"` // bytes to copy, the whole tree for directories
+	Reason string         `json:"reason,omitempty"`
+}
+
+// SyncPlan is returned by PlanSync and given back to RunSync, possibly with
+// some actions removed.
+type SyncPlan struct {
+	Left    string       `json:"left"`
+	Right   string       `json:"right"`
+	Options SyncOptions  `json:"options"`
+	Actions []SyncAction `json:"actions"`
+}
+
+// SyncProgress is emitted (EventSyncProgress) after every action.
+type SyncProgress struct {
+	ID           string `json:"id"`
+	Path         string `json:"path"`
+	ActionsDone  int    `json:"actionsDone"`
+	ActionsTotal int    `json:"actionsTotal"`
+	BytesDone    int64  `json:"bytesDone"`
+	BytesTotal   int64  `json:"bytesTotal"`
+}
+
+// SyncDone is emitted (EventSyncDone) when a sync ends.
+type SyncDone struct {
+	ID        string        `json:"id"`
+	Done      int           `json:"done"`
+	Failed    []SyncFailure `json:"failed"`
+	Conflicts int           `json:"conflicts"`
+	Error     *AppError     `json:"error,omitempty"`
+	Cancelled bool          `json:"cancelled,omitempty"`
+}
+
+type SyncFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+const (
+	EventSyncProgress = "sync:progress"
+	EventSyncDone     = "sync:done"
+
+	syncStateDir = "sync"
+)
+
+// PlanSync compares left and right and returns what a sync would do (a dry run).
+func (s *SyncService) PlanSync(left string, right string, options SyncOptions) Result[SyncPlan] {
+	leftResult := canonicalPath(left)
+	if leftResult.Error != nil {
+		return Result[SyncPlan]{Error: leftResult.Error}
+	}
+	rightResult := canonicalPath(right)
+	if rightResult.Error != nil {
+		return Result[SyncPlan]{Error: rightResult.Error}
+	}
+	plan, appErr := planSync(*leftResult.Data, *rightResult.Data, options)
+	if appErr != nil {
+		return Result[SyncPlan]{Error: appErr}
+	}
+	return Result[SyncPlan]{Data: plan}
+}
+
+// RunSync executes a plan from PlanSync and returns the id of the operation.
+// Conflicts are skipped; a failed action does not stop the others.
+func (s *SyncService) RunSync(plan SyncPlan) Result[string] {
+	leftResult := canonicalPath(plan.Left)
+	if leftResult.Error != nil {
+		return Result[string]{Error: leftResult.Error}
+	}
+	rightResult := canonicalPath(plan.Right)
+	if rightResult.Error != nil {
+		return Result[string]{Error: rightResult.Error}
+	}
+	plan.Left, plan.Right = *leftResult.Data, *rightResult.Data
+	for _, action := range plan.Actions {
+		if !filepath.IsLocal(action.Path) {
+			return Result[string]{Error: &AppError{Code: SyncError, Message: fmt.Sprintf("invalid path %q in sync plan", action.Path)}}
+		}
+	}
+	if s.Files == nil {
+		return Result[string]{Error: &AppError{Code: SyncError, Message: "file operations are not available"}}
+	}
+
+	id := newUUID()
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	if s.jobs == nil {
+		s.jobs = map[string]context.CancelFunc{}
+	}
+	s.jobs[id] = cancel
+	s.mu.Unlock()
+
+	go func() {
+		done := s.run(ctx, id, plan)
+		done.ID = id
+		if ctx.Err() != nil && done.Error == nil {
+			done.Cancelled = true
+		}
+
+		s.mu.Lock()
+		delete(s.jobs, id)
+		s.mu.Unlock()
+		cancel()
+
+		s.emit(EventSyncDone, done)
+	}()
+
+	return Result[string]{Data: &id}
+}
+
+// CancelSync stops a running sync after the current action.
+func (s *SyncService) CancelSync(id string) Result[string] {
+	s.mu.Lock()
+	cancel, ok := s.jobs[id]
+	s.mu.Unlock()
+	if !ok {
+		return Result[string]{Error: &AppError{Code: SyncError, Message: fmt.Sprintf("no running sync %s", id)}}
+	}
+	cancel()
+	return Result[string]{Data: ptrString("Sync cancelled")}
+}
+
+// ServiceShutdown stops the running syncs.
+func (s *SyncService) ServiceShutdown() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cancel := range s.jobs {
+		cancel()
+	}
+	return nil
+}
+
+func (s *SyncService) emit(name string, data any) {
+	if s.App != nil {
+		s.App.Event.Emit(name, data)
+	}
+}
+
+func (s *SyncService) run(ctx context.Context, id string, plan SyncPlan) SyncDone {
+	done := SyncDone{Failed: []SyncFailure{}}
+	progress := SyncProgress{ID: id}
+	for _, action := range plan.Actions {
+		if action.Kind != SyncConflict {
+			progress.ActionsTotal++
+			progress.BytesTotal += action.Size
+		}
+	}
+
+	for _, action := range plan.Actions {
+		if ctx.Err() != nil {
+			return done
+		}
+		if action.Kind == SyncConflict {
+			done.Conflicts++
+			continue
+		}
+
+		if err := s.apply(plan, action); err != nil {
+			done.Failed = append(done.Failed, SyncFailure{Path: action.Path, Error: err.Error()})
+		} else {
+			done.Done++
+		}
+		progress.Path = action.Path
+		progress.ActionsDone++
+		progress.BytesDone += action.Size
+		s.emit(EventSyncProgress, progress)
+	}
+
+	if plan.Options.Mode == SyncBidirectional {
+		if err := saveSyncState(plan.Left, plan.Right, time.Duration(plan.Options.TimeTolerance)*time.Second); err != nil {
+			done.Error = &AppError{Code: SyncError, Message: fmt.Sprintf("failed to save the sync state: %v", err), InnerError: err}
+		}
+	}
+	return done
+}
+
+// apply executes one action.
+func (s *SyncService) apply(plan SyncPlan, action SyncAction) error {
+	from, to := plan.Left, plan.Right
+	switch action.Kind {
+	case SyncCopyToLeft:
+		from, to = plan.Right, plan.Left
+	case SyncDeleteRight:
+		return appErrorOf(s.Files.TrashFiles([]string{filepath.Join(plan.Right, action.Path)}))
+	case SyncDeleteLeft:
+		return appErrorOf(s.Files.TrashFiles([]string{filepath.Join(plan.Left, action.Path)}))
+	}
+
+	source := filepath.Join(from, action.Path)
+	dest := filepath.Join(to, action.Path)
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	// A directory never replaces a file (or the opposite) in place, what it
+	// replaces goes to the trash like the deletions
+	if destInfo, err := os.Lstat(dest); err == nil && (info.IsDir() || !destInfo.Mode().IsRegular()) {
+		if err := appErrorOf(s.Files.TrashFiles([]string{dest})); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	// Timestamps are always kept, comparisons and the sync state rely on them
+	options := s.Config.current().copyOptions()
+	options.Preserve.Timestamps = true
+	copier := newFileCopier(options)
+	if info.IsDir() {
+		return copier.copyDir(source, dest)
+	}
+	return copier.copyFile(source, dest)
+}
+
+// appErrorOf returns the error of a result, nil on success.
+func appErrorOf(result Result[string]) error {
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func planSync(left, right string, options SyncOptions) (*SyncPlan, *AppError) {
+	if strings.HasPrefix(right, left+string(filepath.Separator)) || strings.HasPrefix(left, right+string(filepath.Separator)) {
+		return nil, &AppError{Code: SyncError, Message: "cannot synchronize a folder with one inside it"}
+	}
+	comparison, err := compareDirectories(left, right, CompareOptions{CompareContent: options.CompareContent, TimeTolerance: options.TimeTolerance})
+	if err != nil {
+		return nil, &AppError{Code: SyncError, Message: fmt.Sprintf("failed to compare %s and %s: %v", left, right, err), InnerError: err}
+	}
+
+	plan := &SyncPlan{Left: left, Right: right, Options: options, Actions: []SyncAction{}}
+	add := func(kind SyncActionKind, path string, reason string) {
+		action := SyncAction{Kind: kind, Path: path, Reason: reason}
+		source := filepath.Join(left, path)
+		if kind == SyncCopyToLeft || kind == SyncDeleteRight {
+			source = filepath.Join(right, path)
+		}
+		if info, err := os.Lstat(source); err == nil {
+			action.IsDir = info.IsDir()
+			if kind == SyncCopyToLeft || kind == SyncCopyToRight {
+				action.Size = treeSize(source, info)
+			}
+		}
+		plan.Actions = append(plan.Actions, action)
+	}
+
+	switch options.Mode {
+	case SyncMirror:
+		for _, paths := range [][]string{comparison.OnlyLeft, comparison.NewerLeft, comparison.NewerRight, comparison.Different} {
+			for _, path := range paths {
+				add(SyncCopyToRight, path, "")
+			}
+		}
+		if options.Delete {
+			for _, path := range comparison.OnlyRight {
+				add(SyncDeleteRight, path, "")
+			}
+		}
+	case SyncUpdate:
+		for _, paths := range [][]string{comparison.OnlyLeft, comparison.NewerLeft} {
+			for _, path := range paths {
+				add(SyncCopyToRight, path, "")
+			}
+		}
+	case SyncBidirectional:
+		state, err := loadSyncState(left, right)
+		if err != nil {
+			return nil, &AppError{Code: SyncError, Message: fmt.Sprintf("failed to read the sync state: %v", err), InnerError: err}
+		}
+		planBidirectional(comparison, state, time.Duration(options.TimeTolerance)*time.Second, add)
+	default:
+		return nil, &AppError{Code: SyncError, Message: fmt.Sprintf("unknown sync mode %q", options.Mode)}
+	}
+	return plan, nil
+}
+
+// planBidirectional decides the direction of every difference from what changed
+// on each side since the last sync.
+func planBidirectional(comparison *DirectoryComparison, state *syncState, tolerance time.Duration, add func(SyncActionKind, string, string)) {
+	changed := func(root, path string) bool { return state.changedSince(root, path, tolerance) }
+	left, right := comparison.Left, comparison.Right
+
+	for _, path := range comparison.OnlyLeft {
+		switch {
+		case !state.has(path):
+			add(SyncCopyToRight, path, "new")
+		case changed(left, path):
+			add(SyncCopyToRight, path, "deleted on the right but modified on the left")
+		default:
+			add(SyncDeleteLeft, path, "deleted on the right")
+		}
+	}
+	for _, path := range comparison.OnlyRight {
+		switch {
+		case !state.has(path):
+			add(SyncCopyToLeft, path, "new")
+		case changed(right, path):
+			add(SyncCopyToLeft, path, "deleted on the left but modified on the right")
+		default:
+			add(SyncDeleteRight, path, "deleted on the left")
+		}
+	}
+	for _, paths := range [][]string{comparison.NewerLeft, comparison.NewerRight, comparison.Different} {
+		for _, path := range paths {
+			leftChanged, rightChanged := changed(left, path), changed(right, path)
+			switch {
+			case !state.has(path) && slices.Contains(comparison.NewerLeft, path):
+				add(SyncCopyToRight, path, "newer, never synchronized")
+			case !state.has(path) && slices.Contains(comparison.NewerRight, path):
+				add(SyncCopyToLeft, path, "newer, never synchronized")
+			case leftChanged && !rightChanged:
+				add(SyncCopyToRight, path, "modified")
+			case rightChanged && !leftChanged:
+				add(SyncCopyToLeft, path, "modified")
+			default:
+				add(SyncConflict, path, "modified on both sides")
+			}
+		}
+	}
+}
+
+// treeSize returns the size of a file, or of the files in a directory.
+func treeSize(path string, info fs.FileInfo) int64 {
+	if !info.IsDir() {
+		return info.Size()
+	}
+	var size int64
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// syncState records the entries both folders had after their last bidirectional sync.
+type syncState struct {
+	Left    string                    `json:"left"`
+	Right   string                    `json:"right"`
+	Entries map[string]syncStateEntry `json:"entries"` // by relative path
+}
+
+type syncStateEntry struct {
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	IsDir    bool      `json:"isDir,omitempty"`
+}
+
+func (st *syncState) has(path string) bool {
+	_, ok := st.Entries[path]
+	return ok
+}
+
+// changedSince tells whether root/path, or anything in it, differs from the state.
+func (st *syncState) changedSince(root, path string, tolerance time.Duration) bool {
+	changed := false
+	filepath.WalkDir(filepath.Join(root, path), func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			changed = true
+			return filepath.SkipAll
+		}
+		rel, _ := filepath.Rel(root, p)
+		info, err := entry.Info()
+		known, ok := st.Entries[rel]
+		if err != nil || !ok || !sameSyncEntry(known, info, tolerance) {
+			changed = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return changed
+}
+
+func sameSyncEntry(entry syncStateEntry, info fs.FileInfo, tolerance time.Duration) bool {
+	if entry.IsDir || info.IsDir() {
+		return entry.IsDir == info.IsDir()
+	}
+	// copyFile keeps modification times, so both sides record the same
+	delta := entry.Modified.Sub(info.ModTime())
+	return entry.Size == info.Size() && delta <= tolerance && -delta <= tolerance
+}
+
+// syncStatePath returns the state file of a pair of folders.
+func syncStatePath(left, right string) (string, error) {
+	sum := sha256.Sum256([]byte(left + "\x00" + right))
+	return stateFilePath(filepath.Join(syncStateDir, hex.EncodeToString(sum[:16])+".json"))
+}
+
+func loadSyncState(left, right string) (*syncState, error) {
+	state := &syncState{Left: left, Right: right, Entries: map[string]syncStateEntry{}}
+	path, err := syncStatePath(left, right)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := readJSONFile(path, state); err != nil {
+		return nil, err
+	}
+	if state.Entries == nil {
+		state.Entries = map[string]syncStateEntry{}
+	}
+	return state, nil
+}
+
+// saveSyncState records the entries that are now the same on both sides.
+// The others (conflicts, failures, actions left out of the plan) keep their
+// previous state, so the next sync still sees them as changed.
+func saveSyncState(left, right string, tolerance time.Duration) error {
+	previous, err := loadSyncState(left, right)
+	if err != nil {
+		return err
+	}
+
+	state := &syncState{Left: left, Right: right, Entries: map[string]syncStateEntry{}}
+	// Entries gone from both sides are dropped, their deletion is done
+	for rel, entry := range previous.Entries {
+		_, leftErr := os.Lstat(filepath.Join(left, rel))
+		_, rightErr := os.Lstat(filepath.Join(right, rel))
+		if leftErr == nil || rightErr == nil {
+			state.Entries[rel] = entry
+		}
+	}
+	err = filepath.WalkDir(left, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == left {
+			return nil
+		}
+		rel, _ := filepath.Rel(left, p)
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		current := syncStateEntry{Size: info.Size(), Modified: info.ModTime(), IsDir: info.IsDir()}
+		rightInfo, err := os.Lstat(filepath.Join(right, rel))
+		if err != nil || !sameSyncEntry(current, rightInfo, tolerance) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		state.Entries[rel] = current
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	path, err := syncStatePath(left, right)
+	if err != nil {
+		return err
+	}
+	return writeJSONFileAtomic(path, state)
+}
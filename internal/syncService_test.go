@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrg/xdg"
+)
+
+var syncTestTime = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// writeSyncTree creates the files of a test side, each modified at
+// syncTestTime plus its offset.
+func writeSyncTree(t *testing.T, root string, files map[string]time.Duration) {
+	t.Helper()
+	for name, offset := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		writeTree(t, root, map[string]string{name: "data"})
+		if err := os.Chtimes(path, syncTestTime, syncTestTime.Add(offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testSyncState records the entries of the last sync: files as written by
+// writeSyncTree, or directories when the name ends with a slash.
+func testSyncState(entries map[string]time.Duration) *syncState {
+	state := &syncState{Entries: map[string]syncStateEntry{}}
+	for name, offset := range entries {
+		if dir, ok := strings.CutSuffix(name, "/"); ok {
+			state.Entries[filepath.FromSlash(dir)] = syncStateEntry{IsDir: true}
+			continue
+		}
+		state.Entries[filepath.FromSlash(name)] = syncStateEntry{Size: int64(len("data")), Modified: syncTestTime.Add(offset)}
+	}
+	return state
+}
+
+func TestPlanBidirectional(t *testing.T) {
+	onlyLeft := func(c *DirectoryComparison) *[]string { return &c.OnlyLeft }
+	onlyRight := func(c *DirectoryComparison) *[]string { return &c.OnlyRight }
+	newerLeft := func(c *DirectoryComparison) *[]string { return &c.NewerLeft }
+	newerRight := func(c *DirectoryComparison) *[]string { return &c.NewerRight }
+	different := func(c *DirectoryComparison) *[]string { return &c.Different }
+
+	// Every case compares the entry f of both sides
+	tests := []struct {
+		name      string
+		left      map[string]time.Duration
+		right     map[string]time.Duration
+		state     map[string]time.Duration // nil when never synchronized
+		compared  func(*DirectoryComparison) *[]string
+		tolerance time.Duration
+		want      SyncActionKind
+	}{
+		{"new on the left", map[string]time.Duration{"f": 0}, nil, nil, onlyLeft, 0, SyncCopyToRight},
+		{"deleted on the right", map[string]time.Duration{"f": 0}, nil, map[string]time.Duration{"f": 0}, onlyLeft, 0, SyncDeleteLeft},
+		{"deleted on the right, modified on the left", map[string]time.Duration{"f": time.Hour}, nil, map[string]time.Duration{"f": 0}, onlyLeft, 0, SyncCopyToRight},
+		{"new on the right", nil, map[string]time.Duration{"f": 0}, nil, onlyRight, 0, SyncCopyToLeft},
+		{"deleted on the left", nil, map[string]time.Duration{"f": 0}, map[string]time.Duration{"f": 0}, onlyRight, 0, SyncDeleteRight},
+		{"deleted on the left, modified on the right", nil, map[string]time.Duration{"f": time.Hour}, map[string]time.Duration{"f": 0}, onlyRight, 0, SyncCopyToLeft},
+		{"deleted directory on the right", map[string]time.Duration{"f/a": 0}, nil, map[string]time.Duration{"f/": 0, "f/a": 0}, onlyLeft, 0, SyncDeleteLeft},
+		{"deleted directory on the right, file added on the left", map[string]time.Duration{"f/a": 0, "f/b": 0}, nil, map[string]time.Duration{"f/": 0, "f/a": 0}, onlyLeft, 0, SyncCopyToRight},
+		{"newer on the left, never synchronized", map[string]time.Duration{"f": time.Hour}, map[string]time.Duration{"f": 0}, nil, newerLeft, 0, SyncCopyToRight},
+		{"newer on the right, never synchronized", map[string]time.Duration{"f": 0}, map[string]time.Duration{"f": time.Hour}, nil, newerRight, 0, SyncCopyToLeft},
+		{"different, never synchronized", map[string]time.Duration{"f": 0}, map[string]time.Duration{"f": 0}, nil, different, 0, SyncConflict},
+		{"modified on the left", map[string]time.Duration{"f": time.Hour}, map[string]time.Duration{"f": 0}, map[string]time.Duration{"f": 0}, newerLeft, 0, SyncCopyToRight},
+		{"older copy restored on the right", map[string]time.Duration{"f": 0}, map[string]time.Duration{"f": -time.Hour}, map[string]time.Duration{"f": 0}, newerLeft, 0, SyncCopyToLeft},
+		{"modified on both sides", map[string]time.Duration{"f": time.Hour}, map[string]time.Duration{"f": 2 * time.Hour}, map[string]time.Duration{"f": 0}, newerRight, 0, SyncConflict},
+		{"left within the tolerance", map[string]time.Duration{"f": time.Second}, map[string]time.Duration{"f": time.Hour}, map[string]time.Duration{"f": 0}, newerRight, 2 * time.Second, SyncCopyToLeft},
+		{"left beyond the tolerance", map[string]time.Duration{"f": time.Second}, map[string]time.Duration{"f": time.Hour}, map[string]time.Duration{"f": 0}, newerRight, 0, SyncConflict},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			left, right := filepath.Join(t.TempDir(), "left"), filepath.Join(t.TempDir(), "right")
+			os.Mkdir(left, 0o755)
+			os.Mkdir(right, 0o755)
+			writeSyncTree(t, left, test.left)
+			writeSyncTree(t, right, test.right)
+
+			comparison := &DirectoryComparison{Left: left, Right: right}
+			*test.compared(comparison) = []string{"f"}
+
+			var actions []SyncAction
+			planBidirectional(comparison, testSyncState(test.state), test.tolerance, func(kind SyncActionKind, path string, reason string) {
+				actions = append(actions, SyncAction{Kind: kind, Path: path, Reason: reason})
+			})
+			if len(actions) != 1 || actions[0].Path != "f" || actions[0].Kind != test.want {
+				t.Fatalf("planBidirectional = %+v, want %s f", actions, test.want)
+			}
+		})
+	}
+}
+
+func TestSyncApplyTrashesReplacedItems(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("looks into the freedesktop trash")
+	}
+	dataHome := t.TempDir()
+	t.Cleanup(xdg.Reload) // after the environment is restored
+	t.Setenv("XDG_DATA_HOME", dataHome)
+	xdg.Reload()
+
+	dir := t.TempDir()
+	left, right := filepath.Join(dir, "left"), filepath.Join(dir, "right")
+	writeTree(t, left, map[string]string{"f": "file", "d/a": "alpha"})
+	// Mirror without Delete: what only the right has must survive somewhere
+	writeTree(t, right, map[string]string{"f/only-right": "kept", "d": "was a file"})
+
+	s := &SyncService{Files: &FileManagerService{}, Config: &ConfigService{}}
+	plan := SyncPlan{Left: left, Right: right, Options: SyncOptions{Mode: SyncMirror}}
+	for _, action := range []SyncAction{{Kind: SyncCopyToRight, Path: "f"}, {Kind: SyncCopyToRight, Path: "d", IsDir: true}} {
+		if err := s.apply(plan, action); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkTree(t, right, map[string]string{"f": "file", "d/a": "alpha"})
+	checkTree(t, filepath.Join(dataHome, "Trash", "files"), map[string]string{"f/only-right": "kept", "d": "was a file"})
+}
@@ -0,0 +1,271 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// TerminalService opens the user's terminal emulator and runs commands in a
+// directory, streaming their output to the frontend.
+type TerminalService struct {
+	App    *application.App // used to stream command output, may be nil
+	Config *ConfigService
+
+	mu       sync.Mutex
+	commands map[string]*exec.Cmd // running RunCommand processes by id
+}
+
+// CommandOutput is emitted (EventCommandOutput) for every line a command prints.
+type CommandOutput struct {
+	ID     string `json:"id"`
+	Stream string `json:"stream"` // "stdout" or "stderr"
+	Line   string `json:"line"`
+}
+
+// CommandExit is emitted (EventCommandExit) once a command has finished.
+type CommandExit struct {
+	ID         string `json:"id"`
+	ExitCode   int    `json:"exitCode"`        // -1 if the command could not run to completion
+	Error      string `json:"error,omitempty"` // set if the command was killed or failed to wait
+	DurationMs int64  `json:"durationMs"`
+}
+
+// terminalEmulator describes how to start a terminal in a directory and how to
+// make it run a command.
+type terminalEmulator struct {
+	binary  string
+	dirArgs func(dir string) []string // nil if the terminal only inherits its working directory
+	execArg []string                  // arguments placed before the command to run
+}
+
+const (
+	EventCommandOutput = "command:output"
+	EventCommandExit   = "command:exit"
+
+	// Longest line streamed as is, longer lines are split.
+	commandMaxLine = 64 * 1024
+)
+
+// knownTerminals are tried in order when no terminal is configured and $TERMINAL is unset.
+var knownTerminals = []terminalEmulator{
+	{binary: "x-terminal-emulator", execArg: []string{"-e"}},
+	{binary: "gnome-terminal", dirArgs: func(dir string) []string { return []string{"--working-directory=" + dir} }, execArg: []string{"--"}},
+	{binary: "konsole", dirArgs: func(dir string) []string { return []string{"--workdir", dir} }, execArg: []string{"-e"}},
+	{binary: "xfce4-terminal", dirArgs: func(dir string) []string { return []string{"--working-directory", dir} }, execArg: []string{"-x"}},
+	{binary: "kitty", dirArgs: func(dir string) []string { return []string{"--directory", dir} }},
+	{binary: "alacritty", dirArgs: func(dir string) []string { return []string{"--working-directory", dir} }, execArg: []string{"-e"}},
+	{binary: "wezterm", dirArgs: func(dir string) []string { return []string{"start", "--cwd", dir} }, execArg: []string{"--"}},
+	{binary: "foot", dirArgs: func(dir string) []string { return []string{"--working-directory=" + dir} }},
+	{binary: "tilix", dirArgs: func(dir string) []string { return []string{"--working-directory=" + dir} }, execArg: []string{"-e"}},
+	{binary: "terminator", dirArgs: func(dir string) []string { return []string{"--working-directory=" + dir} }, execArg: []string{"-x"}},
+	{binary: "lxterminal", dirArgs: func(dir string) []string { return []string{"--working-directory=" + dir} }, execArg: []string{"-e"}},
+	{binary: "xterm", execArg: []string{"-e"}},
+}
+
+// OpenTerminal opens a terminal emulator in dirPath.
+// The terminal is the one from the config ("terminal"), else $TERMINAL, else the
+// first installed of the common terminal emulators.
+func (t *TerminalService) OpenTerminal(dirPath string) Result[string] {
+	pathResult := canonicalPath(dirPath)
+	if pathResult.Error != nil {
+		return Result[string]{Error: pathResult.Error}
+	}
+	dir := *pathResult.Data
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return Result[string]{Error: &AppError{Code: ResolvePathError, Message: fmt.Sprintf("%s is not a directory", dir)}}
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		// Windows Terminal if available, else a classic console
+		if _, err := exec.LookPath("wt.exe"); err == nil {
+			cmd = exec.Command("wt.exe", "-d", dir)
+		} else {
+			cmd = exec.Command("cmd", "/C", "start", "cmd", "/K")
+		}
+	case "darwin":
+		cmd = exec.Command("open", "-a", "Terminal", dir)
+	default:
+		argv, appErr := t.terminalCommand(dir, nil)
+		if appErr != nil {
+			return Result[string]{Error: appErr}
+		}
+		cmd = exec.Command(argv[0], argv[1:]...)
+	}
+	cmd.Dir = dir
+
+	if err := cmd.Start(); err != nil {
+		return Result[string]{Error: &AppError{Code: TerminalLaunchError, Message: fmt.Sprintf("failed to open terminal: %v", err), InnerError: err}}
+	}
+	go cmd.Wait()
+
+	return Result[string]{Data: ptrString(fmt.Sprintf("Opened terminal in %s", dir))}
+}
+
+// RunCommand starts argv in dirPath and returns an id right away.
+// Output is streamed line by line with EventCommandOutput, then EventCommandExit
+// reports the exit status. CancelCommand stops it.
+func (t *TerminalService) RunCommand(dirPath string, argv []string) Result[string] {
+	if len(argv) == 0 || argv[0] == "" {
+		return Result[string]{Error: &AppError{Code: CommandRunError, Message: "empty command"}}
+	}
+	pathResult := canonicalPath(dirPath)
+	if pathResult.Error != nil {
+		return Result[string]{Error: pathResult.Error}
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = *pathResult.Data
+	startInProcessGroup(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return Result[string]{Error: &AppError{Code: CommandRunError, Message: fmt.Sprintf("failed to run %s: %v", argv[0], err), InnerError: err}}
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return Result[string]{Error: &AppError{Code: CommandRunError, Message: fmt.Sprintf("failed to run %s: %v", argv[0], err), InnerError: err}}
+	}
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return Result[string]{Error: &AppError{Code: CommandRunError, Message: fmt.Sprintf("failed to run %s: %v", argv[0], err), InnerError: err}}
+	}
+
+	id := newUUID()
+	t.mu.Lock()
+	if t.commands == nil {
+		t.commands = map[string]*exec.Cmd{}
+	}
+	t.commands[id] = cmd
+	t.mu.Unlock()
+
+	go func() {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go t.streamLines(&wg, id, "stdout", stdout)
+		go t.streamLines(&wg, id, "stderr", stderr)
+		// Wait must only be called once the pipes are fully read
+		wg.Wait()
+		err := cmd.Wait()
+
+		t.mu.Lock()
+		delete(t.commands, id)
+		t.mu.Unlock()
+
+		exit := CommandExit{ID: id, ExitCode: cmd.ProcessState.ExitCode(), DurationMs: time.Since(start).Milliseconds()}
+		var exitErr *exec.ExitError
+		if err != nil && !(errors.As(err, &exitErr) && exit.ExitCode >= 0) {
+			exit.Error = err.Error()
+		}
+		t.emit(EventCommandExit, exit)
+	}()
+
+	return Result[string]{Data: &id}
+}
+
+// CancelCommand kills a command started with RunCommand, and the processes it started.
+func (t *TerminalService) CancelCommand(id string) Result[string] {
+	t.mu.Lock()
+	cmd, ok := t.commands[id]
+	t.mu.Unlock()
+	if !ok {
+		return Result[string]{Error: &AppError{Code: CommandRunError, Message: fmt.Sprintf("no running command %s", id)}}
+	}
+	if err := killProcessTree(cmd); err != nil {
+		return Result[string]{Error: &AppError{Code: CommandRunError, Message: fmt.Sprintf("failed to stop command: %v", err), InnerError: err}}
+	}
+	return Result[string]{Data: ptrString("Command cancelled")}
+}
+
+// ServiceShutdown stops the commands still running when lazydir exits.
+func (t *TerminalService) ServiceShutdown() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, cmd := range t.commands {
+		killProcessTree(cmd)
+	}
+	return nil
+}
+
+func (t *TerminalService) streamLines(wg *sync.WaitGroup, id string, stream string, r io.Reader) {
+	defer wg.Done()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), commandMaxLine)
+	scanner.Split(scanLinesOrChunks)
+	for scanner.Scan() {
+		t.emit(EventCommandOutput, CommandOutput{ID: id, Stream: stream, Line: scanner.Text()})
+	}
+	// Keep draining so the process never blocks on a full pipe
+	io.Copy(io.Discard, r)
+}
+
+// scanLinesOrChunks is bufio.ScanLines that hands over overlong lines in pieces instead of failing.
+func scanLinesOrChunks(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if advance == 0 && token == nil && err == nil && len(data) >= commandMaxLine {
+		return len(data), data, nil
+	}
+	return advance, token, err
+}
+
+// terminalCommand returns the command line starting the user's terminal in dir,
+// running command inside it if not empty.
+func (t *TerminalService) terminalCommand(dir string, command []string) ([]string, *AppError) {
+	var candidates [][]string
+	if configured := strings.Fields(t.Config.current().Terminal); len(configured) > 0 {
+		candidates = append(candidates, configured)
+	}
+	if env := strings.Fields(os.Getenv("TERMINAL")); len(env) > 0 {
+		candidates = append(candidates, env)
+	}
+	for _, known := range knownTerminals {
+		candidates = append(candidates, []string{known.binary})
+	}
+
+	for _, candidate := range candidates {
+		if _, err := exec.LookPath(candidate[0]); err != nil {
+			continue
+		}
+
+		emulator := terminalEmulator{binary: candidate[0], execArg: []string{"-e"}}
+		for _, known := range knownTerminals {
+			if known.binary == filepath.Base(candidate[0]) {
+				emulator.dirArgs, emulator.execArg = known.dirArgs, known.execArg
+				break
+			}
+		}
+
+		argv := append([]string{}, candidate...)
+		if emulator.dirArgs != nil && dir != "" {
+			argv = append(argv, emulator.dirArgs(dir)...)
+		}
+		if len(command) > 0 {
+			argv = append(argv, emulator.execArg...)
+			argv = append(argv, command...)
+		}
+		return argv, nil
+	}
+
+	return nil, &AppError{
+		Code:    TerminalLaunchError,
+		Message: `no terminal emulator found, set "terminal" in the config or the TERMINAL environment variable`,
+	}
+}
+
+func (t *TerminalService) emit(name string, data any) {
+	if t.App != nil {
+		t.App.Event.Emit(name, data)
+	}
+}
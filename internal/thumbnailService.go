@@ -0,0 +1,408 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/adrg/xdg"
+	"github.com/wailsapp/wails/v3/pkg/application"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ThumbnailService generates image thumbnails following the freedesktop.org
+// thumbnail managing standard, so they are shared with other file managers:
+// $XDG_CACHE_HOME/thumbnails/{normal,large}/<md5 of the file URI>.png, tagged with
+// the Thumb::URI and Thumb::MTime of the original.
+//
+// The grid view loads them from the asset server through ThumbnailMiddleware:
+//
+//	/thumbnail?path=<absolute path>&size=normal|large
+//
+// which answers 404 for files that cannot be thumbnailed.
+type ThumbnailService struct {
+	startOnce sync.Once
+	jobs      chan *thumbnailJob
+	stop      chan struct{}
+
+	mu       sync.Mutex
+	inFlight map[string]*thumbnailJob // by thumbnail file, requests for the same file share the job
+}
+
+type thumbnailJob struct {
+	source string
+	size   thumbnailSize
+	info   os.FileInfo
+	key    string // thumbnail file
+	done   chan struct{}
+	path   string // thumbnail file, set when done without error
+	err    error
+}
+
+type thumbnailSize struct {
+	name   string
+	pixels int
+}
+
+const (
+	ThumbnailRoute = "/thumbnail"
+
+	thumbnailMaxWorkers = 4
+	thumbnailQueueSize  = 256
+	// Bigger files or images are not thumbnailed, decoding them would take too much time and memory.
+	thumbnailMaxFileSize = 128 << 20
+	thumbnailMaxPixels   = 100_000_000
+
+	thumbnailSoftware = "lazydir"
+)
+
+var (
+	thumbnailNormal = thumbnailSize{name: "normal", pixels: 128}
+	thumbnailLarge  = thumbnailSize{name: "large", pixels: 256}
+
+	// MIME types decoded by the registered image packages.
+	thumbnailMimeTypes = map[string]bool{
+		"image/jpeg": true,
+		"image/png":  true,
+		"image/gif":  true,
+		"image/webp": true,
+	}
+
+	errNoThumbnail = errors.New("no thumbnail for this file")
+)
+
+// ThumbnailMiddleware serves ThumbnailRoute from the asset server and passes every
+// other request on.
+func ThumbnailMiddleware(t *ThumbnailService) application.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != ThumbnailRoute {
+				next.ServeHTTP(w, r)
+				return
+			}
+			t.serveThumbnail(w, r)
+		})
+	}
+}
+
+// ServiceShutdown stops the workers.
+func (t *ThumbnailService) ServiceShutdown() error {
+	t.start()
+	close(t.stop)
+	return nil
+}
+
+func (t *ThumbnailService) serveThumbnail(w http.ResponseWriter, r *http.Request) {
+	size := thumbnailNormal
+	if r.URL.Query().Get("size") == thumbnailLarge.name {
+		size = thumbnailLarge
+	}
+	pathResult := canonicalPath(r.URL.Query().Get("path"))
+	if pathResult.Error != nil {
+		http.Error(w, pathResult.Error.Message, http.StatusBadRequest)
+		return
+	}
+
+	thumbnailPath, err := t.thumbnail(r.Context(), *pathResult.Data, size)
+	if errors.Is(err, errNoThumbnail) || errors.Is(err, os.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		return // the view scrolled away
+	}
+	if err != nil {
+		Log(fmt.Sprintf("ThumbnailService: %v", err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	file, err := os.Open(thumbnailPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache") // revalidated with Last-Modified
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
+
+// thumbnail returns the path of an up to date thumbnail of source, generating it
+// in the worker pool if needed.
+func (t *ThumbnailService) thumbnail(ctx context.Context, source string, size thumbnailSize) (string, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() || info.Size() > thumbnailMaxFileSize || !thumbnailMimeTypes[detectMimeType(source)] {
+		return "", errNoThumbnail
+	}
+
+	thumbnailPath := thumbnailFilePath(size.name, source)
+	if thumbnailUpToDate(thumbnailPath, source, info) {
+		return thumbnailPath, nil
+	}
+	if thumbnailUpToDate(thumbnailFilePath(filepath.Join("fail", thumbnailSoftware), source), source, info) {
+		return "", errNoThumbnail // failed before and did not change since
+	}
+
+	t.start()
+	t.mu.Lock()
+	job, running := t.inFlight[thumbnailPath]
+	if !running {
+		job = &thumbnailJob{source: source, size: size, info: info, key: thumbnailPath, done: make(chan struct{})}
+		t.inFlight[thumbnailPath] = job
+	}
+	t.mu.Unlock()
+
+	if !running {
+		select {
+		case t.jobs <- job:
+		case <-ctx.Done():
+			t.mu.Lock()
+			delete(t.inFlight, thumbnailPath)
+			t.mu.Unlock()
+			job.err = ctx.Err()
+			close(job.done)
+			return "", ctx.Err()
+		}
+	}
+
+	select {
+	case <-job.done:
+		if errors.Is(job.err, context.Canceled) && ctx.Err() == nil {
+			// The request that queued the job went away before it was queued, try again
+			return t.thumbnail(ctx, source, size)
+		}
+		return job.path, job.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (t *ThumbnailService) start() {
+	t.startOnce.Do(func() {
+		t.jobs = make(chan *thumbnailJob, thumbnailQueueSize)
+		t.stop = make(chan struct{})
+		t.inFlight = map[string]*thumbnailJob{}
+		for range min(runtime.NumCPU(), thumbnailMaxWorkers) {
+			go t.worker()
+		}
+	})
+}
+
+func (t *ThumbnailService) worker() {
+	for {
+		select {
+		case <-t.stop:
+			return
+		case job := <-t.jobs:
+			job.path, job.err = generateThumbnail(job.source, job.info, job.size)
+			t.mu.Lock()
+			delete(t.inFlight, job.key)
+			t.mu.Unlock()
+			close(job.done)
+		}
+	}
+}
+
+// generateThumbnail decodes source, scales it down and stores the thumbnail.
+// Images that cannot be decoded get an empty thumbnail in the fail directory,
+// as the standard asks, so they are not retried until modified.
+func generateThumbnail(source string, info os.FileInfo, size thumbnailSize) (string, error) {
+	img, err := decodeImage(source)
+	if err != nil {
+		Log(fmt.Sprintf("ThumbnailService: cannot thumbnail %s: %v", source, err))
+		failPath := thumbnailFilePath(filepath.Join("fail", thumbnailSoftware), source)
+		if err := writeThumbnail(failPath, image.NewNRGBA(image.Rect(0, 0, 1, 1)), source, info, image.Point{}); err != nil {
+			return "", err
+		}
+		return "", errNoThumbnail
+	}
+
+	bounds := img.Bounds()
+	thumbnail := img
+	// Never upscale, small images are stored as they are
+	if bounds.Dx() > size.pixels || bounds.Dy() > size.pixels {
+		width, height := size.pixels, size.pixels
+		if bounds.Dx() > bounds.Dy() {
+			height = max(1, bounds.Dy()*size.pixels/bounds.Dx())
+		} else {
+			width = max(1, bounds.Dx()*size.pixels/bounds.Dy())
+		}
+		scaled := image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.BiLinear.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+		thumbnail = scaled
+	}
+
+	thumbnailPath := thumbnailFilePath(size.name, source)
+	if err := writeThumbnail(thumbnailPath, thumbnail, source, info, bounds.Size()); err != nil {
+		return "", err
+	}
+	return thumbnailPath, nil
+}
+
+func decodeImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > thumbnailMaxPixels {
+		return nil, fmt.Errorf("image too large (%dx%d)", config.Width, config.Height)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(file)
+	return img, err
+}
+
+// writeThumbnail encodes img as PNG with the standard's text chunks and
+// atomically stores it, readable by the user only.
+func writeThumbnail(path string, img image.Image, source string, info os.FileInfo, original image.Point) error {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		return err
+	}
+
+	texts := [][2]string{
+		{"Thumb::URI", fileURI(source)},
+		{"Thumb::MTime", strconv.FormatInt(info.ModTime().Unix(), 10)},
+		{"Thumb::Size", strconv.FormatInt(info.Size(), 10)},
+		{"Thumb::Mimetype", detectMimeType(source)},
+		{"Software", thumbnailSoftware},
+	}
+	if original != (image.Point{}) {
+		texts = append(texts,
+			[2]string{"Thumb::Image::Width", strconv.Itoa(original.X)},
+			[2]string{"Thumb::Image::Height", strconv.Itoa(original.Y)},
+		)
+	}
+	data, err := insertPNGTexts(encoded.Bytes(), texts)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o600)
+}
+
+// thumbnailUpToDate tells whether the thumbnail at path was made from the current version of source.
+func thumbnailUpToDate(path string, source string, info os.FileInfo) bool {
+	texts, err := readPNGTexts(path)
+	if err != nil {
+		return false
+	}
+	if texts["Thumb::URI"] != fileURI(source) || texts["Thumb::MTime"] != strconv.FormatInt(info.ModTime().Unix(), 10) {
+		return false
+	}
+	if size, ok := texts["Thumb::Size"]; ok && size != strconv.FormatInt(info.Size(), 10) {
+		return false
+	}
+	return true
+}
+
+// thumbnailFilePath returns $XDG_CACHE_HOME/thumbnails/<dir>/<md5 of the URI of source>.png.
+func thumbnailFilePath(dir string, source string) string {
+	sum := md5.Sum([]byte(fileURI(source)))
+	return filepath.Join(xdg.CacheHome, "thumbnails", dir, hex.EncodeToString(sum[:])+".png")
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// insertPNGTexts adds tEXt chunks right after the IHDR chunk of an encoded PNG.
+func insertPNGTexts(data []byte, texts [][2]string) ([]byte, error) {
+	// Signature, then IHDR: length (4), type (4), 13 bytes of data, CRC (4)
+	headerEnd := len(pngSignature) + 4 + 4 + 13 + 4
+	if len(data) < headerEnd || !bytes.HasPrefix(data, pngSignature) || string(data[12:16]) != "IHDR" {
+		return nil, errors.New("invalid PNG data")
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:headerEnd])
+	for _, text := range texts {
+		chunk := append([]byte("tEXt"+text[0]+"\x00"), text[1]...)
+		binary.Write(&out, binary.BigEndian, uint32(len(chunk)-4))
+		out.Write(chunk)
+		binary.Write(&out, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	}
+	out.Write(data[headerEnd:])
+	return out.Bytes(), nil
+}
+
+// readPNGTexts returns the tEXt chunks of a PNG file, which come before the image data.
+func readPNGTexts(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(file, signature); err != nil || !bytes.Equal(signature, pngSignature) {
+		return nil, errors.New("not a PNG file")
+	}
+
+	texts := map[string]string{}
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(file, header[:]); err != nil {
+			return nil, err
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		switch chunkType := string(header[4:]); chunkType {
+		case "IDAT", "IEND":
+			return texts, nil
+		case "tEXt":
+			if length > 1<<20 {
+				return nil, errors.New("invalid tEXt chunk")
+			}
+			data := make([]byte, length)
+			if _, err := io.ReadFull(file, data); err != nil {
+				return nil, err
+			}
+			if key, value, ok := strings.Cut(string(data), "\x00"); ok {
+				texts[key] = value
+			}
+			if _, err := file.Seek(4, io.SeekCurrent); err != nil { // CRC
+				return nil, err
+			}
+		default:
+			if _, err := file.Seek(int64(length)+4, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
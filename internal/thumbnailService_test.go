@@ -0,0 +1,14 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestThumbnailFilePath(t *testing.T) {
+	// MD5 of file:///home/u/photo%20(1)!'*.jpg, the name GLib based file managers use
+	got := filepath.Base(thumbnailFilePath("normal", "/home/u/photo (1)!'*.jpg"))
+	if want := "f180810e75993ab8f1ce950d0f8a900f.png"; got != want {
+		t.Fatalf("thumbnailFilePath = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build darwin
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// moveToTrash asks Finder to trash path, so "Put Back" works as usual.
+func moveToTrash(path string) error {
+	script := fmt.Sprintf(`tell application "Finder" to delete POSIX file %q`, path)
+	if output, err := exec.Command("osascript", "-e", script).CombinedOutput(); err != nil {
+		if _, statErr := os.Lstat(path); statErr != nil {
+			return statErr
+		}
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
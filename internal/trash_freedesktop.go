@@ -0,0 +1,137 @@
+//go:build !windows && !darwin
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrg/xdg"
+)
+
+// moveToTrash follows the freedesktop.org trash specification: files go to the
+// home trash, or to the trash at the top of their mount when they live on another
+// device, so trashing never copies data.
+func moveToTrash(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	trashDir := filepath.Join(xdg.DataHome, "Trash")
+	infoPath := path // Path= of the .trashinfo, absolute for the home trash
+	if home := existingParentDevice(trashDir); home != deviceOf(info) {
+		topDir := mountTopDir(path)
+		if trashDir, err = topDirTrash(topDir); err != nil {
+			return err
+		}
+		infoPath, _ = filepath.Rel(topDir, path)
+	}
+
+	filesDir := filepath.Join(trashDir, "files")
+	infoDir := filepath.Join(trashDir, "info")
+	if err := os.MkdirAll(filesDir, 0o700); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(infoDir, 0o700); err != nil {
+		return err
+	}
+
+	escaped := (&url.URL{Path: filepath.ToSlash(infoPath)}).EscapedPath()
+	trashInfo := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n", escaped, time.Now().Format("2006-01-02T15:04:05"))
+
+	// The .trashinfo is created exclusively first, it reserves the name in files/
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := name
+		if i > 1 {
+			candidate = stem + "." + strconv.Itoa(i) + ext
+		}
+		infoFile := filepath.Join(infoDir, candidate+".trashinfo")
+		file, err := os.OpenFile(infoFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = file.WriteString(trashInfo)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(path, filepath.Join(filesDir, candidate))
+		}
+		if err != nil {
+			os.Remove(infoFile)
+			return err
+		}
+		return nil
+	}
+}
+
+// topDirTrash returns the trash of a mount: $topdir/.Trash/$uid when the admin
+// created a valid (sticky, not a symlink) $topdir/.Trash, else $topdir/.Trash-$uid.
+func topDirTrash(topDir string) (string, error) {
+	uid := strconv.Itoa(os.Getuid())
+	shared := filepath.Join(topDir, ".Trash")
+	if info, err := os.Lstat(shared); err == nil && info.IsDir() && info.Mode()&fs.ModeSticky != 0 {
+		dir := filepath.Join(shared, uid)
+		if err := os.MkdirAll(dir, 0o700); err == nil {
+			return dir, nil
+		}
+	}
+
+	dir := filepath.Join(topDir, ".Trash-"+uid)
+	if err := os.Mkdir(dir, 0o700); err != nil && !errors.Is(err, fs.ErrExist) {
+		return "", fmt.Errorf("no trash available on %s: %w", topDir, err)
+	}
+	if info, err := os.Lstat(dir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("no trash available on %s", topDir)
+	}
+	return dir, nil
+}
+
+// mountTopDir returns the highest directory above path on the same device.
+func mountTopDir(path string) string {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return filepath.Dir(path)
+	}
+	device := deviceOf(info)
+	dir := filepath.Dir(path)
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		parentInfo, err := os.Stat(parent)
+		if err != nil || deviceOf(parentInfo) != device {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// existingParentDevice returns the device of path, or of its closest existing parent.
+func existingParentDevice(path string) uint64 {
+	for {
+		if info, err := os.Stat(path); err == nil {
+			return deviceOf(info)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return 0
+		}
+		path = parent
+	}
+}
@@ -14,6 +14,16 @@ type FileInfo struct {
 	Mode      string    `json:"mode"`
 	Modified  time.Time `json:"modified"`
 	Extension string    `json:"extension,omitempty"`
+	MimeType  string    `json:"mimeType"` // from the content, or only from the name in large directories (see GetMimeTypes)
+
+	GitStatus GitFileStatus  `json:"gitStatus,omitempty"` // empty outside a repository or when clean
+	Metadata  *MediaMetadata `json:"metadata,omitempty"`  // images and audio, when the media columns are enabled
+}
+
+// FileProperties for the properties dialog
+type FileProperties struct {
+	File       FileInfo `json:"file"` // Metadata is always filled when available
+	LinkTarget string   `json:"linkTarget,omitempty"`
 }
 
 type PathInfo struct {
@@ -31,6 +41,8 @@ type DirectoryContents struct {
 	DirCount        int   `json:"dirCount"`        // Direct children only
 	FileCount       int   `json:"fileCount"`       // Direct children only
 	DirectSizeBytes int64 `json:"directSizeBytes"` // Direct files size in bytes
+
+	Git *GitRepoInfo `json:"git,omitempty"` // nil outside a git repository
 }
 
 type AppError struct {
@@ -51,6 +63,37 @@ const (
 	FileCleanupError            ErrorCode = "FileCleanupError"
 	FileCopyError               ErrorCode = "FileCopyError"
 	FileDeleteError             ErrorCode = "FileDeleteError"
+	FileTrashError              ErrorCode = "FileTrashError"
+	FileLinkError               ErrorCode = "FileLinkError"
+	BookmarkStoreError          ErrorCode = "BookmarkStoreError"
+	BookmarkNotFoundError       ErrorCode = "BookmarkNotFoundError"
+	BookmarkExistsError         ErrorCode = "BookmarkExistsError"
+	HistoryStoreError           ErrorCode = "HistoryStoreError"
+	SessionStoreError           ErrorCode = "SessionStoreError"
+	SessionNotFoundError        ErrorCode = "SessionNotFoundError"
+	SessionVersionError         ErrorCode = "SessionVersionError"
+	ConfigReadError             ErrorCode = "ConfigReadError"
+	ConfigWriteError            ErrorCode = "ConfigWriteError"
+	ConfigValidationError       ErrorCode = "ConfigValidationError"
+	ApplicationNotFoundError    ErrorCode = "ApplicationNotFoundError"
+	ApplicationLaunchError      ErrorCode = "ApplicationLaunchError"
+	MimeAssociationError        ErrorCode = "MimeAssociationError"
+	CustomActionError           ErrorCode = "CustomActionError"
+	CustomActionNotFoundError   ErrorCode = "CustomActionNotFoundError"
+	TerminalLaunchError         ErrorCode = "TerminalLaunchError"
+	CommandRunError             ErrorCode = "CommandRunError"
+	PtyError                    ErrorCode = "PtyError"
+	GitRepositoryNotFoundError  ErrorCode = "GitRepositoryNotFoundError"
+	GitStatusError              ErrorCode = "GitStatusError"
+	GitOperationError           ErrorCode = "GitOperationError"
+	PreviewError                ErrorCode = "PreviewError"
+	ChecksumError               ErrorCode = "ChecksumError"
+	DuplicateError              ErrorCode = "DuplicateError"
+	CompareError                ErrorCode = "CompareError"
+	SyncError                   ErrorCode = "SyncError"
+	PermissionError             ErrorCode = "PermissionError"
+	OperationJournalError       ErrorCode = "OperationJournalError"
+	OperationNotFoundError      ErrorCode = "OperationNotFoundError"
 )
 
 // AppError implements error.
@@ -71,6 +114,21 @@ type Result[T any] struct {
 	Error *AppError `json:"error,omitempty"`
 }
 
+// FileOperationReport is the outcome of a file operation that can partly succeed.
+type FileOperationReport struct {
+	Message  string   `json:"message"`
+	Warnings []string `json:"warnings"` // e.g. metadata that could not be preserved
+}
+
+// LinkKind is the kind of link LinkFiles creates.
+type LinkKind string
+
+const (
+	LinkSymbolic         LinkKind = "symlink"         // absolute target
+	LinkSymbolicRelative LinkKind = "relativeSymlink" // target relative to the link, survives moving both
+	LinkHard             LinkKind = "hardlink"        // files on the same device only
+)
+
 type OperatingSystem string
 
 const (
@@ -92,10 +150,22 @@ const (
 	ShortcutLogoVideos    ShortcutLogo = "videos"
 	ShortcutLogoDesktop   ShortcutLogo = "desktop"
 	ShortcutLogoDownloads ShortcutLogo = "downloads"
+	ShortcutLogoNetwork   ShortcutLogo = "network"
+)
+
+// ShortcutSource tells where a sidebar shortcut comes from.
+type ShortcutSource string
+
+const (
+	ShortcutSourcePlace    ShortcutSource = "place"    // built-in XDG places
+	ShortcutSourceBookmark ShortcutSource = "bookmark" // added by the user in lazydir
+	ShortcutSourceGtk      ShortcutSource = "gtk"      // imported from ~/.config/gtk-3.0/bookmarks
 )
 
 type Shortcut struct {
-	Name string       `json:"name"`
-	Path string       `json:"path"`
-	Logo ShortcutLogo `json:"logo"`
+	Name   string         `json:"name"`
+	Path   string         `json:"path"` // absolute path, or a URI (sftp://...) for remote bookmarks
+	Logo   ShortcutLogo   `json:"logo"`
+	Source ShortcutSource `json:"source,omitempty"`
+	Remote bool           `json:"remote,omitempty"` // not a local path, cannot be listed directly
 }
@@ -0,0 +1,34 @@
+//go:build darwin || freebsd || netbsd
+
+package internal
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ACLs are not exposed as extended attributes on these systems.
+var aclXattrs []string
+
+// accessTime returns the last access time of a file.
+func accessTime(info fs.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atimespec.Unix())
+	}
+	return info.ModTime()
+}
+
+func listXattrs(path string) ([]string, error) {
+	return readXattrList(func(dest []byte) (int, error) { return unix.Llistxattr(path, dest) })
+}
+
+func getXattr(path string, name string) ([]byte, error) {
+	return readXattr(func(dest []byte) (int, error) { return unix.Lgetxattr(path, name, dest) })
+}
+
+func setXattr(path string, name string, value []byte) error {
+	return unix.Lsetxattr(path, name, value, 0)
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package internal
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// POSIX ACLs are stored in these extended attributes.
+var aclXattrs = []string{"system.posix_acl_access", "system.posix_acl_default"}
+
+// accessTime returns the last access time of a file.
+func accessTime(info fs.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atim.Unix())
+	}
+	return info.ModTime()
+}
+
+func listXattrs(path string) ([]string, error) {
+	return readXattrList(func(dest []byte) (int, error) { return unix.Llistxattr(path, dest) })
+}
+
+func getXattr(path string, name string) ([]byte, error) {
+	return readXattr(func(dest []byte) (int, error) { return unix.Lgetxattr(path, name, dest) })
+}
+
+func setXattr(path string, name string, value []byte) error {
+	return unix.Lsetxattr(path, name, value, 0)
+}
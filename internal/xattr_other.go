@@ -0,0 +1,29 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+
+package internal
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+)
+
+var aclXattrs []string
+
+// accessTime falls back to the modification time where it is not available.
+func accessTime(info fs.FileInfo) time.Time {
+	return info.ModTime()
+}
+
+// Extended attributes are not supported here: there are never any to copy.
+func listXattrs(path string) ([]string, error) {
+	return nil, nil
+}
+
+func getXattr(path string, name string) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func setXattr(path string, name string, value []byte) error {
+	return errors.ErrUnsupported
+}
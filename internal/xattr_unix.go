@@ -0,0 +1,52 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package internal
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattr calls read with a buffer large enough for the value, which can
+// grow between the size query and the read.
+func readXattr(read func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := read(nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			// read with an empty buffer would be another size query
+			return []byte{}, nil
+		}
+		buf := make([]byte, size)
+		n, err := read(buf)
+		if errors.Is(err, unix.ERANGE) || n > len(buf) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+// readXattrList reads a NUL separated list of attribute names.
+func readXattrList(read func(dest []byte) (int, error)) ([]string, error) {
+	data, err := readXattr(read)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil, nil // the file system has no extended attributes
+		}
+		return nil, err
+	}
+	var names []string
+	for _, name := range bytes.Split(data, []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+	return names, nil
+}
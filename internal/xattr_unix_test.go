@@ -0,0 +1,56 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package internal
+
+import (
+	"slices"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// fakeXattr returns a read function for value, which becomes grown after the
+// first call, like an attribute written between the size query and the read.
+func fakeXattr(value string, grown string) func(dest []byte) (int, error) {
+	return func(dest []byte) (int, error) {
+		current := value
+		value = grown
+		if len(dest) == 0 {
+			return len(current), nil
+		}
+		if len(dest) < len(current) {
+			return 0, unix.ERANGE
+		}
+		return copy(dest, current), nil
+	}
+}
+
+func TestReadXattr(t *testing.T) {
+	tests := []struct {
+		value string
+		grown string
+		want  string
+	}{
+		{"abc", "abc", "abc"},
+		{"", "", ""},
+		{"", "abc", ""}, // reading into an empty buffer would return 3
+		{"abc", "abcdef", "abcdef"},
+	}
+	for _, test := range tests {
+		data, err := readXattr(fakeXattr(test.value, test.grown))
+		if err != nil || string(data) != test.want {
+			t.Errorf("readXattr of %q growing to %q = %q, %v, want %q", test.value, test.grown, data, err, test.want)
+		}
+	}
+}
+
+func TestReadXattrList(t *testing.T) {
+	names, err := readXattrList(fakeXattr("user.a\x00user.b\x00", "user.a\x00user.b\x00"))
+	if err != nil || !slices.Equal(names, []string{"user.a", "user.b"}) {
+		t.Fatalf("readXattrList = %q, %v", names, err)
+	}
+	names, err = readXattrList(fakeXattr("", "user.a\x00"))
+	if err != nil || len(names) != 0 {
+		t.Fatalf("readXattrList of an empty list = %q, %v", names, err)
+	}
+}
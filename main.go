@@ -23,6 +23,20 @@ func init() {
 	// This is not required, but the binding generator will pick up registered events
 	// and provide a strongly typed JS/TS API for them.
 	application.RegisterEvent[string]("time")
+	application.RegisterEvent[internal.Config](internal.EventConfigChanged)
+	application.RegisterEvent[internal.AppError](internal.EventConfigError)
+	application.RegisterEvent[internal.CommandOutput](internal.EventCommandOutput)
+	application.RegisterEvent[internal.CommandExit](internal.EventCommandExit)
+	application.RegisterEvent[internal.PtyOutput](internal.EventPtyOutput)
+	application.RegisterEvent[internal.PtyExit](internal.EventPtyExit)
+	application.RegisterEvent[internal.GitDirectoryStatus](internal.EventGitStatus)
+	application.RegisterEvent[internal.ChecksumProgress](internal.EventChecksumProgress)
+	application.RegisterEvent[internal.ChecksumDone](internal.EventChecksumDone)
+	application.RegisterEvent[internal.DuplicateProgress](internal.EventDuplicateProgress)
+	application.RegisterEvent[internal.DuplicateGroup](internal.EventDuplicateGroup)
+	application.RegisterEvent[internal.DuplicateScanDone](internal.EventDuplicateDone)
+	application.RegisterEvent[internal.SyncProgress](internal.EventSyncProgress)
+	application.RegisterEvent[internal.SyncDone](internal.EventSyncDone)
 }
 
 // main function serves as the application's entry point. It initializes the application, creates a window,
@@ -35,24 +49,69 @@ func main() {
 	// 'Assets' configures the asset server with the 'FS' variable pointing to the frontend files.
 	// 'Bind' is a list of Go struct instances. The frontend has access to the methods of these instances.
 	// 'Mac' options tailor the application when running an macOS.
+	thumbnails := &internal.ThumbnailService{}
+
 	app := application.New(application.Options{
 		Name:        "lazydir",
 		Description: "A lazy file manager built with Go & React",
 		Services:    []application.Service{},
 		Assets: application.AssetOptions{
-			Handler: application.AssetFileServerFS(assets),
+			Handler:    application.AssetFileServerFS(assets),
+			Middleware: internal.ThumbnailMiddleware(thumbnails),
 		},
 		Mac: application.MacOptions{
 			ApplicationShouldTerminateAfterLastWindowClosed: true,
 		},
 	})
 
-	fmService := application.NewService(&internal.FileManagerService{})
+	config := &internal.ConfigService{App: app}
+	app.RegisterService(application.NewService(config))
+
+	app.RegisterService(application.NewService(thumbnails))
+	app.RegisterService(application.NewService(&internal.PreviewService{}))
+
+	bookmarks := &internal.BookmarkService{}
+	app.RegisterService(application.NewService(bookmarks))
+
+	history := &internal.HistoryService{}
+	app.RegisterService(application.NewService(history))
+
+	gitStatus := &internal.GitStatusService{App: app}
+	app.RegisterService(application.NewService(gitStatus))
+
+	app.RegisterService(application.NewService(&internal.GitService{Status: gitStatus}))
+
+	checksums := &internal.ChecksumService{App: app}
+	app.RegisterService(application.NewService(checksums))
+
+	fileManager := &internal.FileManagerService{Bookmarks: bookmarks, History: history, Config: config, Git: gitStatus}
+	fmService := application.NewService(fileManager)
 	app.RegisterService(fmService)
 
-	dialogService := application.NewService(&internal.DialogService{App: app})
+	app.RegisterService(application.NewService(&internal.DuplicateService{App: app, Files: fileManager}))
+	app.RegisterService(application.NewService(&internal.CompareService{}))
+	app.RegisterService(application.NewService(&internal.SyncService{App: app, Files: fileManager, Config: config}))
+	app.RegisterService(application.NewService(&internal.PermissionService{}))
+
+	terminal := &internal.TerminalService{App: app, Config: config}
+	app.RegisterService(application.NewService(terminal))
+
+	ptyService := application.NewService(&internal.PtyService{App: app})
+	app.RegisterService(ptyService)
+
+	openWithService := application.NewService(&internal.OpenWithService{Terminal: terminal})
+	app.RegisterService(openWithService)
+
+	sessionService := application.NewService(&internal.SessionService{InitialPath: fileManager.GetInitialPath})
+	app.RegisterService(sessionService)
+
+	dialogs := &internal.DialogService{App: app}
+	dialogService := application.NewService(dialogs)
 	app.RegisterService(dialogService)
 
+	customActionService := application.NewService(&internal.CustomActionService{Config: config, Dialogs: dialogs})
+	app.RegisterService(customActionService)
+
 	// Create a new window with the necessary options.
 	// 'Title' is the title of the window.
 	// 'Mac' options tailor the window when running on macOS.